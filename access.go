@@ -0,0 +1,316 @@
+package elstore
+
+import (
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Accesses are tracked in shards by ID, so that concurrent readers of
+// different elements rarely contend
+const accessShards = 16
+
+// Last access times are persisted in accessFile with a granularity of
+// accessGranularity, as the time tracking started followed by records of
+// an ID and a Unix time in nanoseconds, little endian. The file is
+// rewritten every accessFlushInterval if an access moved an element to a
+// later bucket, and when the store is closed, so that reads cost no I/O
+const (
+	accessFile        = ".accessed"
+	accessGranularity = time.Hour
+)
+
+var accessFlushInterval = 5 * time.Minute
+
+type accessShard struct {
+	mu    sync.Mutex
+	stats map[uint64]accessStat
+	_     [48]byte // keep shards on separate cache lines
+}
+
+type accessStat struct {
+	reads uint64
+	last  int64 // Unix nanoseconds
+}
+
+// Tracks how often and when each element is read, for AccessStats and
+// ColdIDs. Reads made with GetOpts.NoCache, such as scans, are not
+// counted. Read counts start over when the store is opened, while last
+// access times are kept to the hour across restarts
+func WithAccessTracking() Option {
+	return option(func(c *ElementStore) {
+		c.accessTracking = true
+		c.accessSince = time.Now()
+	})
+}
+
+func (c *ElementStore) recordAccess(id uint64) {
+	if !c.accessTracking {
+		return
+	}
+
+	now := time.Now().UnixNano()
+	shard := &c.access[id%accessShards]
+	shard.mu.Lock()
+	if shard.stats == nil {
+		shard.stats = make(map[uint64]accessStat)
+	}
+
+	st := shard.stats[id]
+	shard.stats[id] = accessStat{saturatingAdd(st.reads, 1), now}
+	shard.mu.Unlock()
+	if st.last/int64(accessGranularity) != now/int64(accessGranularity) {
+		atomic.StoreInt32(&c.accessDirty, 1)
+	}
+}
+
+// loads the persisted last access times, and starts flushing them
+func (c *ElementStore) loadAccess() error {
+	if !c.accessTracking {
+		return nil
+	}
+
+	path := filepath.Join(c.workdir, accessFile)
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if len(data) >= 8 {
+		c.accessSince = time.Unix(0, int64(binary.LittleEndian.Uint64(data)))
+		for rec := data[8:]; len(rec) >= 16; rec = rec[16:] {
+			id := binary.LittleEndian.Uint64(rec)
+			if _, ok := c.onDisk[id]; !ok {
+				continue
+			}
+
+			shard := &c.access[id%accessShards]
+			if shard.stats == nil {
+				shard.stats = make(map[uint64]accessStat)
+			}
+
+			shard.stats[id] = accessStat{last: int64(binary.LittleEndian.Uint64(rec[8:]))}
+		}
+	} else {
+		atomic.StoreInt32(&c.accessDirty, 1)
+	}
+
+	if !c.sharedReader {
+		c.schedule("access-flush", accessFlushInterval, func() {
+			c.flushAccess()
+		})
+	}
+
+	return nil
+}
+
+// writes the last access times if any moved to a later bucket
+func (c *ElementStore) flushAccess() error {
+	c.accessFlushMu.Lock()
+	defer c.accessFlushMu.Unlock()
+	if !atomic.CompareAndSwapInt32(&c.accessDirty, 1, 0) {
+		return nil
+	}
+
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, uint64(c.accessSince.UnixNano()))
+	for i := range c.access {
+		shard := &c.access[i]
+		shard.mu.Lock()
+		for id, st := range shard.stats {
+			buf = appendIDTime(buf, id, st.last-st.last%int64(accessGranularity))
+		}
+
+		shard.mu.Unlock()
+	}
+
+	if _, err := writeData(filepath.Join(c.workdir, accessFile), c.fileMode(), false, buf); err != nil {
+		atomic.StoreInt32(&c.accessDirty, 1)
+		return err
+	}
+
+	return nil
+}
+
+func (c *ElementStore) forgetAccess(id uint64) {
+	shard := &c.access[id%accessShards]
+	shard.mu.Lock()
+	delete(shard.stats, id)
+	shard.mu.Unlock()
+}
+
+func (c *ElementStore) accessOf(id uint64) accessStat {
+	shard := &c.access[id%accessShards]
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	return shard.stats[id]
+}
+
+// Access statistics of an element, as returned by AccessStats
+type IDStat struct {
+	ID         uint64    `json:"id"`
+	Reads      uint64    `json:"reads"`
+	LastAccess time.Time `json:"last_access"` // zero if not read
+	Size       int64     `json:"size"`
+}
+
+// Per-call options for AccessStatsWith. The zero value behaves like
+// AccessStats
+type AccessOpts struct {
+	// Sample this fraction of the elements at random, if between zero and
+	// one
+	SampleRate float64
+
+	// Return the K most read elements, by descending read count, if
+	// positive
+	TopK int
+}
+
+// Returns the access statistics of all elements on disk, in ascending ID
+// order. Read counts and access times are zero unless the store was
+// opened with WithAccessTracking
+func (c *ElementStore) AccessStats() []IDStat {
+	return c.AccessStatsWith(AccessOpts{})
+}
+
+// Like AccessStats, with options for sampling and for picking the most
+// read elements
+func (c *ElementStore) AccessStatsWith(opts AccessOpts) []IDStat {
+	c.storeMutex.RLock()
+	stats := make([]IDStat, 0, len(c.onDisk))
+	for id, size := range c.onDisk {
+		if opts.SampleRate > 0 && opts.SampleRate < 1 &&
+			rand.Float64() >= opts.SampleRate {
+			continue
+		}
+
+		stats = append(stats, IDStat{ID: id, Size: size})
+	}
+
+	c.storeMutex.RUnlock()
+
+	for i := range stats {
+		if st := c.accessOf(stats[i].ID); st.last != 0 {
+			stats[i].Reads = st.reads
+			stats[i].LastAccess = time.Unix(0, st.last)
+		}
+	}
+
+	if opts.TopK <= 0 {
+		sort.Slice(stats, func(i, j int) bool { return stats[i].ID < stats[j].ID })
+		return stats
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Reads != stats[j].Reads {
+			return stats[i].Reads > stats[j].Reads
+		}
+
+		return stats[i].ID < stats[j].ID
+	})
+
+	if len(stats) > opts.TopK {
+		stats = stats[:opts.TopK]
+	}
+
+	return stats
+}
+
+// Returns up to 'limit' elements, coldest first, that haven't been read in
+// the last 'olderThan'. Elements that haven't been read since access
+// tracking started count as last accessed when they were inserted, or when
+// tracking started if later. Access times loaded from a previous run are
+// accurate to accessGranularity. Aliases are not returned. A non-positive 'limit' returns all cold elements
+//
+// Returns nil unless the store was opened with WithAccessTracking
+func (c *ElementStore) ColdIDs(olderThan time.Duration, limit int) []uint64 {
+	if !c.accessTracking {
+		return nil
+	}
+
+	type cold struct {
+		id   uint64
+		last time.Time
+	}
+
+	c.storeMutex.RLock()
+	ids := make([]uint64, 0, len(c.onDisk))
+	for id := range c.onDisk {
+		ids = append(ids, id)
+	}
+
+	c.storeMutex.RUnlock()
+
+	cutoff := time.Now().Add(-olderThan)
+	var colds []cold
+	for _, id := range ids {
+		var last time.Time
+		if st := c.accessOf(id); st.last != 0 {
+			last = time.Unix(0, st.last)
+		} else if last = c.insertedOrModified(id); last.Before(c.accessSince) {
+			last = c.accessSince
+		}
+
+		if last.Before(cutoff) {
+			colds = append(colds, cold{id, last})
+		}
+	}
+
+	sort.Slice(colds, func(i, j int) bool {
+		if !colds[i].last.Equal(colds[j].last) {
+			return colds[i].last.Before(colds[j].last)
+		}
+
+		return colds[i].id < colds[j].id
+	})
+
+	if limit > 0 && len(colds) > limit {
+		colds = colds[:limit]
+	}
+
+	ids = make([]uint64, len(colds))
+	for i, cold := range colds {
+		ids[i] = cold.id
+	}
+
+	return ids
+}
+
+// Writes access statistics as CSV, with a header row of "id", "reads",
+// "last_access" and "size". Access times are in RFC 3339 format, and empty
+// for elements that haven't been read
+func WriteAccessStatsCSV(w io.Writer, stats []IDStat) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"id", "reads", "last_access", "size"})
+	for _, st := range stats {
+		last := ""
+		if !st.LastAccess.IsZero() {
+			last = st.LastAccess.UTC().Format(time.RFC3339Nano)
+		}
+
+		cw.Write([]string{
+			strconv.FormatUint(st.ID, 10),
+			strconv.FormatUint(st.Reads, 10),
+			last,
+			strconv.FormatInt(st.Size, 10),
+		})
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// Writes access statistics as a JSON array
+func WriteAccessStatsJSON(w io.Writer, stats []IDStat) error {
+	return json.NewEncoder(w).Encode(stats)
+}
@@ -0,0 +1,149 @@
+package elstore
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAccessStats(t *testing.T) {
+	c, err := NewElementStore(0, testDir, WithAccessTracking())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	for id := uint64(1); id <= 3; id++ {
+		if err := c.Put(make([]byte, id), id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c.Sync()
+	for _, id := range []uint64{2, 2, 3} {
+		if _, err := c.Get(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// scans are not counted
+	c.GetWith(1, GetOpts{NoCache: true})
+
+	stats := c.AccessStats()
+	if len(stats) != 3 || stats[0].Reads != 0 || !stats[0].LastAccess.IsZero() ||
+		stats[1].Reads != 2 || stats[1].Size != 2 || stats[2].Reads != 1 {
+		t.Fatal("unexpected stats", stats)
+	}
+
+	top := c.AccessStatsWith(AccessOpts{TopK: 2})
+	if len(top) != 2 || top[0].ID != 2 || top[1].ID != 3 {
+		t.Fatal("unexpected top elements", top)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteAccessStatsCSV(&buf, top); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 || lines[0] != "id,reads,last_access,size" ||
+		!strings.HasPrefix(lines[1], "2,2,") || !strings.HasSuffix(lines[1], ",2") {
+		t.Fatal("unexpected CSV", lines)
+	}
+
+	buf.Reset()
+	if err := WriteAccessStatsJSON(&buf, top); err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded []IDStat
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	} else if len(decoded) != 2 || decoded[0].ID != 2 || decoded[0].Reads != 2 {
+		t.Fatal("unexpected JSON", buf.String())
+	}
+}
+
+func TestColdIDs(t *testing.T) {
+	c, err := NewElementStore(0, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.Put(testData2, 1)
+	c.Sync()
+	if ids := c.ColdIDs(0, 0); ids != nil {
+		t.Fatal("expected no cold IDs without access tracking, got", ids)
+	}
+
+	c.Close()
+	c, err = NewElementStore(0, testDir, WithAccessTracking())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	c.Put(testData2, 2)
+	c.Put(testData2, 3)
+	c.Sync()
+	time.Sleep(20 * time.Millisecond)
+	c.Get(1)
+
+	// 1 was read recently, 2 and 3 were only written
+	ids := c.ColdIDs(10*time.Millisecond, 0)
+	sortIDs(ids)
+	if !reflect.DeepEqual(ids, []uint64{2, 3}) {
+		t.Fatal("unexpected cold IDs", ids)
+	} else if ids := c.ColdIDs(10*time.Millisecond, 1); len(ids) != 1 {
+		t.Fatal("limit not applied", ids)
+	} else if ids := c.ColdIDs(time.Hour, 0); len(ids) != 0 {
+		t.Fatal("expected no cold IDs, got", ids)
+	}
+}
+
+func TestAccessPersisted(t *testing.T) {
+	c, err := NewElementStore(0, testDir, WithAccessTracking())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	c.Put(testData2, 1)
+	c.Put(testData2, 2)
+	c.Sync()
+	read := time.Now()
+	c.Get(1)
+	c.Get(1)
+
+	c.Close()
+	c, err = NewElementStore(0, testDir, WithAccessTracking())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+
+	// the access time is kept to the hour, the read count is not kept
+	stats := c.AccessStats()
+	bucket := read.Truncate(accessGranularity)
+	if !stats[0].LastAccess.Equal(bucket) || stats[0].Reads != 0 ||
+		!stats[1].LastAccess.IsZero() {
+		t.Fatal("unexpected stats after restart", stats)
+	}
+
+	// reads within the same bucket don't need a flush
+	atomic.StoreInt32(&c.accessDirty, 0)
+	c.Get(1)
+	if atomic.LoadInt32(&c.accessDirty) != 0 && time.Now().Truncate(accessGranularity).Equal(bucket) {
+		t.Fatal("read within the same bucket marked for flushing")
+	}
+
+	c.Get(2)
+	if atomic.LoadInt32(&c.accessDirty) != 1 {
+		t.Fatal("first read not marked for flushing")
+	}
+}
@@ -0,0 +1,86 @@
+package elstore
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+)
+
+// suffix of alias files, which are kept next to where the alias' element
+// file would be and contain the hex ID of the target
+const aliasSuffix = ".alias"
+
+func (c *ElementStore) aliasFile(base string, id uint64) string {
+	return c.elFile(base, id) + aliasSuffix
+}
+
+// returns the ID of an alias file name
+func (c *ElementStore) parseAlias(name string) (uint64, bool) {
+	return c.parseSuffixed(name, aliasSuffix)
+}
+
+func readAlias(path string) (uint64, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseUint(string(data), 16, 64)
+}
+
+func (c *ElementStore) writeAlias(base string, aliasID, targetID uint64) error {
+	if err := os.MkdirAll(elDir(base, aliasID), c.dirMode()); err != nil {
+		return err
+	}
+
+	path := c.aliasFile(base, aliasID)
+	tmp := path + ".tmp"
+	data := []byte(strconv.FormatUint(targetID, 16))
+	if err := ioutil.WriteFile(tmp, data, c.fileMode()); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// Makes the element 'targetID' reachable as 'aliasID' as well, without
+// storing its payload twice. An alias of an alias points to the final
+// target. If the Put of the target is cancelled, the alias is left
+// dangling and Get returns ErrDoesNotExist for it
+//
+// Returns ErrAlreadyExists if 'aliasID' is in use, and ErrDoesNotExist if
+// 'targetID' is not recognized
+func (c *ElementStore) Alias(aliasID, targetID uint64) error {
+	if c.IsReadOnly() {
+		return ErrReadOnly
+	}
+
+	c.storeMutex.Lock()
+	defer c.storeMutex.Unlock()
+
+	if c.isClosed() {
+		return ErrClosed
+	} else if c.has(aliasID) {
+		return ErrAlreadyExists
+	}
+
+	if target, ok := c.aliases[targetID]; ok {
+		targetID = target
+	} else if !c.has(targetID) {
+		return ErrDoesNotExist
+	}
+
+	for _, base := range []string{c.root(aliasID), c.standby} {
+		if base == "" {
+			continue
+		}
+
+		if err := c.writeAlias(base, aliasID, targetID); err != nil {
+			return err
+		}
+	}
+
+	c.aliases[aliasID] = targetID
+	c.notifyID(aliasID)
+	return nil
+}
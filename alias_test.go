@@ -0,0 +1,64 @@
+package elstore
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestAlias(t *testing.T) {
+	c, err := NewElementStore(0, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	if err := c.Put(testData2, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Alias(2, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// resolves to 1
+	if err := c.Alias(3, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Alias(2, 1); err != ErrAlreadyExists {
+		t.Fatal("expected ErrAlreadyExists, got", err)
+	}
+
+	if err := c.Alias(4, 5); err != ErrDoesNotExist {
+		t.Fatal("expected ErrDoesNotExist, got", err)
+	}
+
+	if err := c.Put(testData2, 3); err != ErrAlreadyExists {
+		t.Fatal("expected ErrAlreadyExists, got", err)
+	}
+
+	c.Sync()
+	c.Close()
+	c, err = NewElementStore(0, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+
+	if c.aliases[3] != 1 {
+		t.Fatal("alias not resolved to final target", c.aliases)
+	}
+
+	if ids := c.IDs(); !reflect.DeepEqual(ids, []uint64{1, 2, 3}) {
+		t.Fatal("unexpected IDs", ids)
+	}
+
+	data, err := c.Get(3)
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(data, testData2) {
+		t.Fatalf("expected\n%v\n\ngot\n%v\n\n", testData2, data)
+	}
+}
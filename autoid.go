@@ -0,0 +1,88 @@
+package elstore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// IDs handed out by PutAuto are reserved in blocks, by recording the first
+// ID after the block in autoIDFile, so that the file is written once per
+// block rather than once per element. IDs left in a block when the store
+// is closed are skipped
+const (
+	autoIDFile  = ".next-id"
+	autoIDBlock = 1024
+)
+
+type autoIDs struct {
+	sync.Mutex
+	loaded   bool
+	next     uint64
+	reserved uint64 // first ID not reserved
+}
+
+// Inserts an element under the next free ID, counting up from 1, and
+// returns the ID. IDs in use are skipped, and IDs are not reused after
+// their elements are deleted, also after the store is reopened
+//
+// In a workdir shared by several writers, each writer has a counter of its
+// own, and IDs may be taken by another writer before they're visible
+func (c *ElementStore) PutAuto(elem []byte) (uint64, error) {
+	for {
+		id, err := c.nextID()
+		if err != nil {
+			return 0, err
+		}
+
+		if err := c.Put(elem, id); err == nil {
+			return id, nil
+		} else if err != ErrAlreadyExists {
+			return 0, err
+		}
+	}
+}
+
+func (c *ElementStore) nextID() (uint64, error) {
+	c.autoIDs.Lock()
+	defer c.autoIDs.Unlock()
+	path := filepath.Join(c.workdir, autoIDFile)
+	if !c.autoIDs.loaded {
+		data, err := ioutil.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			return 0, err
+		}
+
+		next := uint64(1)
+		if err == nil {
+			if next, err = strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64); err != nil {
+				return 0, err
+			}
+		}
+
+		c.autoIDs.next, c.autoIDs.reserved = next, next
+		c.autoIDs.loaded = true
+	}
+
+	if c.autoIDs.next == c.autoIDs.reserved {
+		reserved := c.autoIDs.next + autoIDBlock
+		tmp := path + tmpSuffix
+		record := strconv.FormatUint(reserved, 10) + "\n"
+		if err := ioutil.WriteFile(tmp, []byte(record), c.fileMode()); err != nil {
+			return 0, err
+		}
+
+		if err := os.Rename(tmp, path); err != nil {
+			return 0, err
+		}
+
+		c.autoIDs.reserved = reserved
+	}
+
+	id := c.autoIDs.next
+	c.autoIDs.next++
+	return id, nil
+}
@@ -0,0 +1,50 @@
+package elstore
+
+import (
+	"testing"
+)
+
+func TestPutAuto(t *testing.T) {
+	c, err := NewElementStore(0, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// taken IDs are skipped
+	if err := c.Put(testData2, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []uint64
+	for i := 0; i < 2; i++ {
+		id, err := c.PutAuto(testData2)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ids = append(ids, id)
+	}
+
+	if ids[0] != 1 || ids[1] != 3 {
+		t.Fatal("unexpected IDs", ids)
+	}
+
+	// the rest of the reserved block is skipped after reopening
+	c.Sync()
+	c.Close()
+	c, err = NewElementStore(0, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	if id, err := c.PutAuto(testData2); err != nil {
+		t.Fatal(err)
+	} else if id != 1+autoIDBlock {
+		t.Fatal("expected ID after the reserved block, got", id)
+	}
+
+	if len(c.OpenReport().Ignored) != 0 {
+		t.Fatal("counter file reported as ignored", c.OpenReport().Ignored)
+	}
+}
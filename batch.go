@@ -0,0 +1,63 @@
+package elstore
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// number of goroutines writing the elements of a batch
+const batchWriters = 8
+
+// Inserts several elements at once. The IDs are checked and the elements
+// scheduled under a single lock acquisition, and the elements are written
+// by a bounded number of goroutines rather than one per element. Elements
+// are still written to individual files
+//
+// Returns ErrAlreadyExists, inserting nothing, if any of the IDs exists
+func (c *ElementStore) PutBatch(elems map[uint64][]byte) error {
+	if c.IsReadOnly() {
+		return ErrReadOnly
+	}
+
+	if err := c.WriteError(); err != nil {
+		return err
+	}
+
+	c.storeMutex.Lock()
+	defer c.storeMutex.Unlock()
+
+	if c.isClosed() {
+		return ErrClosed
+	}
+
+	for id := range elems {
+		if c.has(id) {
+			return ErrAlreadyExists
+		} else if err := c.writeErr(id); err != nil {
+			return err
+		}
+	}
+
+	writes := make(chan queuedWrite, len(elems))
+	for id, elem := range elems {
+		pw := c.newPendingWrite(elem, id)
+		c.inTransfer[id] = pw
+		c.notifyID(id)
+		atomic.AddUint64(&c.io.accepted, uint64(len(elem)))
+		writes <- queuedWrite{pw, id}
+		c.mirror(context.Background(), elem, id)
+	}
+
+	close(writes)
+	c.checkCapacity()
+	c.activeWrites.Add(len(elems))
+	for i := 0; i < batchWriters && i < len(elems); i++ {
+		go func() {
+			for w := range writes {
+				c.write(w.pw, w.id)
+			}
+		}()
+	}
+
+	return nil
+}
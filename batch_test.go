@@ -0,0 +1,45 @@
+package elstore
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPutBatch(t *testing.T) {
+	c, err := NewElementStore(0, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	batch := make(map[uint64][]byte)
+	for id := uint64(1); id <= 100; id++ {
+		batch[id] = bytes.Repeat([]byte{byte(id)}, int(id))
+	}
+
+	if err := c.PutBatch(batch); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Sync()
+	if err := c.WriteError(); err != nil {
+		t.Fatal(err)
+	}
+
+	for id, expected := range batch {
+		data, err := c.Get(id)
+		if err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(data, expected) {
+			t.Fatalf("expected\n%v\n\ngot\n%v\n\n", expected, data)
+		}
+	}
+
+	// one existing ID fails the whole batch
+	err = c.PutBatch(map[uint64][]byte{101: testData2, 50: testData2})
+	if err != ErrAlreadyExists {
+		t.Fatal("expected ErrAlreadyExists, got", err)
+	} else if c.Has(101) {
+		t.Fatal("element of failed batch inserted")
+	}
+}
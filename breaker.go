@@ -0,0 +1,87 @@
+package elstore
+
+import (
+	"sync"
+	"time"
+)
+
+// circuit breaker around disk operations. A nil *circuitBreaker is valid
+// and always closed
+type circuitBreaker struct {
+	threshold int
+	interval  time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	open      bool
+	probing   bool
+	nextProbe time.Time
+}
+
+// returns true if a disk operation may be attempted
+func (b *circuitBreaker) allow() bool {
+	if b == nil {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.open {
+		return true
+	}
+
+	// half-open: let a single probe through per interval
+	if b.probing || time.Now().Before(b.nextProbe) {
+		return false
+	}
+
+	b.probing = true
+	b.nextProbe = time.Now().Add(b.interval)
+	return true
+}
+
+// records the outcome of a disk operation
+func (b *circuitBreaker) record(err error) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	if err == nil {
+		b.failures = 0
+		b.open = false
+		return
+	}
+
+	b.failures++
+	if b.failures >= b.threshold && !b.open {
+		b.open = true
+		b.nextProbe = time.Now().Add(b.interval)
+	}
+}
+
+// lets another probe through once the interval has passed, without
+// counting the outcome of an operation that was abandoned
+func (b *circuitBreaker) abandon() {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+func (b *circuitBreaker) isOpen() bool {
+	if b == nil {
+		return false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.open
+}
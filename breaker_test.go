@@ -0,0 +1,100 @@
+package elstore
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	b := &circuitBreaker{threshold: 2, interval: 20 * time.Millisecond}
+	fail := errors.New("fail")
+
+	b.record(fail)
+	if !b.allow() || b.isOpen() {
+		t.Fatal("breaker opened before threshold")
+	}
+
+	b.record(fail)
+	if b.allow() || !b.isOpen() {
+		t.Fatal("breaker not opened at threshold")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if !b.allow() {
+		t.Fatal("no probe allowed after interval")
+	}
+
+	if b.allow() {
+		t.Fatal("more than one concurrent probe allowed")
+	}
+
+	b.record(nil)
+	if !b.allow() || b.isOpen() {
+		t.Fatal("breaker not closed after successful probe")
+	}
+}
+
+func TestCircuitBreakerServesCache(t *testing.T) {
+	c, err := NewElementStore(1, testDir, WithCircuitBreaker(1, time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	for id := uint64(1); id <= 2; id++ {
+		if err := c.Put(testData2, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c.Sync()
+	if _, err := c.Get(1); err != nil {
+		t.Fatal(err)
+	}
+
+	c.waitAdmissions()
+	c.breaker.record(errors.New("disk on fire"))
+	if _, err := c.Get(1); err != nil {
+		t.Fatal("cached element not served with open circuit:", err)
+	}
+
+	if _, err := c.Get(2); err != ErrUnavailable {
+		t.Fatal("expected ErrUnavailable, got", err)
+	}
+
+	if h := c.Health(); h != Degraded {
+		t.Fatal("expected degraded store, got", h)
+	}
+}
+
+func TestCircuitBreakerCancelledProbe(t *testing.T) {
+	c, err := NewElementStore(0, testDir, WithCircuitBreaker(1, 10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	if err := c.Put(testData2, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Sync()
+	c.breaker.record(errors.New("disk on fire"))
+	time.Sleep(20 * time.Millisecond)
+
+	// the probe is abandoned by its caller
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.GetCtx(ctx, 1); err != context.Canceled {
+		t.Fatal("expected context.Canceled, got", err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if _, err := c.Get(1); err != nil {
+		t.Fatal("no probe allowed after an abandoned one:", err)
+	} else if c.breaker.isOpen() {
+		t.Fatal("breaker not closed after successful probe")
+	}
+}
@@ -0,0 +1,285 @@
+package elstore
+
+import (
+	"container/heap"
+	"container/list"
+)
+
+// A CachePolicy keeps track of the IDs of cached elements and decides
+// which element to evict when the cache is full. Read counts are passed on
+// lazily, in batches, so recency is approximate
+//
+// The methods are called with the store's lock held and must not block. A
+// policy must not be shared between stores
+type CachePolicy interface {
+	Len() int
+	Has(id uint64) bool
+
+	// Adds an element, given the number of times it has been read
+	Add(id uint64, reads uint64)
+
+	// Records 'n' reads of an element, which may not be cached
+	Hit(id uint64, n uint64)
+
+	// Returns true if an element with 'reads' reads is worth a place in the
+	// full cache. If so, Evict is called to make room for it
+	Admit(id uint64, reads uint64) bool
+
+	// Removes and returns the element to evict
+	Evict() uint64
+
+	// Removes an element, e.g. one that was deleted from the store
+	Remove(id uint64)
+
+	Reset()
+}
+
+// Returns a policy that evicts the least read element. Elements are only
+// admitted to a full cache if they have been read more often than the
+// element they would replace. This is the default policy
+func NewLFUPolicy() CachePolicy {
+	return &lfuPolicy{pos: make(map[uint64]int)}
+}
+
+// a min-heap of cached elements by read count
+type lfuPolicy struct {
+	ids    []uint64
+	counts []uint64
+	pos    map[uint64]int
+}
+
+func (p *lfuPolicy) Len() int { return len(p.ids) }
+
+func (p *lfuPolicy) Less(i, j int) bool { return p.counts[i] < p.counts[j] }
+
+func (p *lfuPolicy) Swap(i, j int) {
+	p.ids[i], p.ids[j] = p.ids[j], p.ids[i]
+	p.counts[i], p.counts[j] = p.counts[j], p.counts[i]
+	p.pos[p.ids[i]] = i
+	p.pos[p.ids[j]] = j
+}
+
+func (p *lfuPolicy) Push(x interface{}) {
+	e := x.([2]uint64)
+	p.pos[e[0]] = len(p.ids)
+	p.ids = append(p.ids, e[0])
+	p.counts = append(p.counts, e[1])
+}
+
+func (p *lfuPolicy) Pop() interface{} {
+	last := len(p.ids) - 1
+	id := p.ids[last]
+	delete(p.pos, id)
+	p.ids, p.counts = p.ids[:last], p.counts[:last]
+	return id
+}
+
+func (p *lfuPolicy) Has(id uint64) bool {
+	_, ok := p.pos[id]
+	return ok
+}
+
+func (p *lfuPolicy) Add(id uint64, reads uint64) {
+	heap.Push(p, [2]uint64{id, reads})
+}
+
+func (p *lfuPolicy) Hit(id uint64, n uint64) {
+	if ix, ok := p.pos[id]; ok {
+		p.counts[ix] = saturatingAdd(p.counts[ix], n)
+		heap.Fix(p, ix)
+	}
+}
+
+func (p *lfuPolicy) Admit(id uint64, reads uint64) bool {
+	return len(p.ids) == 0 || p.counts[0] < reads
+}
+
+func (p *lfuPolicy) Evict() uint64 {
+	return heap.Pop(p).(uint64)
+}
+
+func (p *lfuPolicy) Remove(id uint64) {
+	if ix, ok := p.pos[id]; ok {
+		heap.Remove(p, ix)
+	}
+}
+
+func (p *lfuPolicy) Reset() {
+	*p = lfuPolicy{pos: make(map[uint64]int)}
+}
+
+// Returns a policy that evicts the least recently read element and always
+// admits new elements
+func NewLRUPolicy() CachePolicy {
+	return &lruPolicy{elems: make(map[uint64]*list.Element)}
+}
+
+type lruPolicy struct {
+	order list.List // most recently read first
+	elems map[uint64]*list.Element
+}
+
+func (p *lruPolicy) Len() int { return len(p.elems) }
+
+func (p *lruPolicy) Has(id uint64) bool {
+	_, ok := p.elems[id]
+	return ok
+}
+
+func (p *lruPolicy) Add(id uint64, reads uint64) {
+	p.elems[id] = p.order.PushFront(id)
+}
+
+func (p *lruPolicy) Hit(id uint64, n uint64) {
+	if e, ok := p.elems[id]; ok {
+		p.order.MoveToFront(e)
+	}
+}
+
+func (p *lruPolicy) Admit(id uint64, reads uint64) bool { return true }
+
+func (p *lruPolicy) Evict() uint64 {
+	id := p.order.Remove(p.order.Back()).(uint64)
+	delete(p.elems, id)
+	return id
+}
+
+func (p *lruPolicy) Remove(id uint64) {
+	if e, ok := p.elems[id]; ok {
+		p.order.Remove(e)
+		delete(p.elems, id)
+	}
+}
+
+func (p *lruPolicy) Reset() {
+	p.order.Init()
+	p.elems = make(map[uint64]*list.Element)
+}
+
+// Returns an Adaptive Replacement Cache policy, which balances recently
+// and frequently read elements and adapts the balance to the workload by
+// remembering recently evicted elements
+func NewARCPolicy() CachePolicy {
+	p := &arcPolicy{}
+	p.Reset()
+	return p
+}
+
+// the lists of the ARC paper: t1 and t2 hold cached elements read once and
+// more than once, b1 and b2 the IDs of elements recently evicted from them
+type arcPolicy struct {
+	t1, t2, b1, b2 list.List // most recent first
+	elems          map[uint64]*list.Element
+	lists          map[uint64]*list.List
+
+	capacity  int // learned from the size of the cache when evicting
+	target    int // target size of t1
+	candidate uint64
+}
+
+func (p *arcPolicy) Len() int { return p.t1.Len() + p.t2.Len() }
+
+func (p *arcPolicy) Has(id uint64) bool {
+	l := p.lists[id]
+	return l == &p.t1 || l == &p.t2
+}
+
+func (p *arcPolicy) move(id uint64, to *list.List) {
+	if e, ok := p.elems[id]; ok {
+		p.lists[id].Remove(e)
+	}
+
+	p.elems[id] = to.PushFront(id)
+	p.lists[id] = to
+}
+
+func (p *arcPolicy) drop(id uint64) {
+	if e, ok := p.elems[id]; ok {
+		p.lists[id].Remove(e)
+		delete(p.elems, id)
+		delete(p.lists, id)
+	}
+}
+
+func (p *arcPolicy) dropOldest(l *list.List) {
+	if e := l.Back(); e != nil {
+		p.drop(e.Value.(uint64))
+	}
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+
+	return b
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+
+	return b
+}
+
+func (p *arcPolicy) Add(id uint64, reads uint64) {
+	switch p.lists[id] {
+	case &p.b1:
+		// evicted too early for being read once; favor recency
+		p.target = minInt(p.capacity, p.target+maxInt(p.b2.Len()/p.b1.Len(), 1))
+		p.move(id, &p.t2)
+	case &p.b2:
+		p.target = maxInt(0, p.target-maxInt(p.b1.Len()/p.b2.Len(), 1))
+		p.move(id, &p.t2)
+	default:
+		p.move(id, &p.t1)
+		if p.capacity > 0 && p.t1.Len()+p.b1.Len() > p.capacity {
+			p.dropOldest(&p.b1)
+		}
+
+		if p.capacity > 0 && p.Len()+p.b1.Len()+p.b2.Len() > 2*p.capacity {
+			p.dropOldest(&p.b2)
+		}
+	}
+}
+
+func (p *arcPolicy) Hit(id uint64, n uint64) {
+	if p.Has(id) {
+		p.move(id, &p.t2)
+	}
+}
+
+func (p *arcPolicy) Admit(id uint64, reads uint64) bool {
+	p.candidate = id
+	return true
+}
+
+func (p *arcPolicy) Evict() uint64 {
+	p.capacity = p.Len()
+	from, to := &p.t2, &p.b2
+	t1 := p.t1.Len()
+	if t1 > 0 && (t1 > p.target || (t1 == p.target && p.lists[p.candidate] == &p.b2) || p.t2.Len() == 0) {
+		from, to = &p.t1, &p.b1
+	}
+
+	id := from.Back().Value.(uint64)
+	p.move(id, to)
+	return id
+}
+
+func (p *arcPolicy) Remove(id uint64) {
+	if p.Has(id) {
+		p.drop(id)
+	}
+}
+
+func (p *arcPolicy) Reset() {
+	p.t1.Init()
+	p.t2.Init()
+	p.b1.Init()
+	p.b2.Init()
+	p.elems = make(map[uint64]*list.Element)
+	p.lists = make(map[uint64]*list.List)
+	p.target = 0
+}
@@ -0,0 +1,172 @@
+package elstore
+
+import (
+	"testing"
+)
+
+func TestLFUPolicy(t *testing.T) {
+	p := NewLFUPolicy()
+	p.Add(1, 5)
+	p.Add(2, 1)
+	p.Add(3, 3)
+	if p.Admit(4, 1) {
+		t.Fatal("admitted element read as often as the least read one")
+	} else if !p.Admit(4, 2) {
+		t.Fatal("element read more often than the least read one not admitted")
+	}
+
+	p.Hit(2, 4)
+	p.Hit(4, 100) // not cached, ignored
+	p.Remove(1)
+	if id := p.Evict(); id != 3 {
+		t.Fatal("expected 3 to be evicted, got", id)
+	} else if id := p.Evict(); id != 2 {
+		t.Fatal("expected 2 to be evicted, got", id)
+	} else if p.Len() != 0 {
+		t.Fatal("expected empty policy, got length", p.Len())
+	}
+}
+
+func TestLRUPolicy(t *testing.T) {
+	p := NewLRUPolicy()
+	for id := uint64(1); id <= 3; id++ {
+		p.Add(id, 0)
+	}
+
+	p.Hit(1, 1)
+	if id := p.Evict(); id != 2 {
+		t.Fatal("expected 2 to be evicted, got", id)
+	} else if id := p.Evict(); id != 3 {
+		t.Fatal("expected 3 to be evicted, got", id)
+	} else if !p.Has(1) || p.Len() != 1 {
+		t.Fatal("expected 1 to remain")
+	}
+
+	p.Reset()
+	if p.Has(1) || p.Len() != 0 {
+		t.Fatal("policy not reset")
+	}
+}
+
+func TestARCPolicy(t *testing.T) {
+	p := NewARCPolicy()
+	p.Add(1, 0)
+	p.Hit(1, 1)
+	p.Add(2, 0)
+
+	// a scan of elements read once doesn't evict the element read twice
+	for id := uint64(3); id <= 10; id++ {
+		if !p.Admit(id, 0) {
+			t.Fatal("element not admitted", id)
+		}
+
+		if evicted := p.Evict(); evicted == 1 {
+			t.Fatal("frequently read element evicted by scan")
+		}
+
+		p.Add(id, 0)
+	}
+
+	if !p.Has(1) || !p.Has(10) || p.Len() != 2 {
+		t.Fatal("unexpected cache contents")
+	}
+
+	// 9 was evicted recently, and is promoted when it comes back
+	p.Admit(9, 0)
+	p.Evict()
+	p.Add(9, 0)
+	if !p.Has(9) || !p.Has(1) {
+		t.Fatal("unexpected cache contents after ghost hit")
+	}
+
+	p.Remove(9)
+	if p.Has(9) || p.Len() != 1 {
+		t.Fatal("element not removed")
+	}
+}
+
+func TestWithCachePolicy(t *testing.T) {
+	for _, tc := range []struct {
+		policy CachePolicy
+		cached uint64
+	}{
+		{NewLFUPolicy(), 1},
+		{NewLRUPolicy(), 2},
+		{NewARCPolicy(), 2},
+	} {
+		c, err := NewElementStore(1, testDir, WithCachePolicy(tc.policy))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c.Put(testData2, 1)
+		c.Put(testData2, 2)
+		c.Sync()
+
+		// 1 is read more often, 2 more recently
+		c.Get(1)
+		c.waitAdmissions()
+		c.Get(1)
+		c.Get(2)
+		c.waitAdmissions()
+		if _, ok := c.inMemIDMap.Load(tc.cached); !ok || c.inMem.Len() != 1 {
+			c.Remove()
+			t.Fatalf("%T: expected %v to be cached", tc.policy, tc.cached)
+		}
+
+		if err := c.Remove(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := NewElementStore(1, testDir, WithCachePolicy(nil)); err == nil {
+		t.Fatal("expected error for nil policy")
+	}
+}
+
+func TestMaxCacheBytes(t *testing.T) {
+	c, err := NewElementStore(100, testDir, WithMaxCacheBytes(2500))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	for id := uint64(1); id <= 3; id++ {
+		c.Put(make([]byte, 1000), id)
+	}
+
+	c.Put(make([]byte, 3000), 4)
+	c.Sync()
+
+	// 3 is read more often than 1 and 2, and makes room for itself. 4 is
+	// larger than the cache
+	for _, id := range []uint64{1, 2, 3, 3, 4} {
+		if _, err := c.Get(id); err != nil {
+			t.Fatal(err)
+		}
+
+		c.waitAdmissions()
+	}
+
+	cached := func(id uint64) bool {
+		_, ok := c.inMemIDMap.Load(id)
+		return ok
+	}
+
+	if !cached(3) || cached(4) || c.inMem.Len() != 2 || c.cacheBytes != 2000 {
+		t.Fatal("unexpected cache contents", c.inMem.Len(), c.cacheBytes)
+	}
+
+	if err := c.ApplyOptions(WithMaxCacheBytes(1000)); err != nil {
+		t.Fatal(err)
+	}
+
+	if !cached(3) || c.inMem.Len() != 1 || c.cacheBytes != 1000 {
+		t.Fatal("cache not shrunk to its byte size", c.inMem.Len(), c.cacheBytes)
+	}
+
+	c.DisableCache()
+	if c.cacheBytes != 0 {
+		t.Fatal("expected no cached bytes, got", c.cacheBytes)
+	}
+}
@@ -0,0 +1,142 @@
+package elstore
+
+import (
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// Limits the total size of the elements in the store to 'n' bytes, so that
+// it can be used as a bounded persistent cache. When a Put takes the store
+// over the limit, the least recently read elements are deleted in the
+// background, elements never read counting as read when inserted. Elements
+// in transfer are never deleted, so the limit may be exceeded briefly.
+// Zero, the default, means no limit. Implies WithAccessTracking
+func WithMaxDiskBytes(n int64) Option {
+	if n < 0 {
+		return Option{err: fmt.Errorf("%w: max disk bytes %v", ErrInvalidOption, n)}
+	}
+
+	return option(func(c *ElementStore) {
+		c.maxDiskBytes = n
+		WithAccessTracking().apply(c)
+	})
+}
+
+// Limits the number of elements in the store to 'n', deleting the least
+// recently read elements like WithMaxDiskBytes. Aliases don't count toward
+// the limit. Zero, the default, means no limit. Implies WithAccessTracking
+func WithMaxElements(n int) Option {
+	if n < 0 {
+		return Option{err: fmt.Errorf("%w: max elements %v", ErrInvalidOption, n)}
+	}
+
+	return option(func(c *ElementStore) {
+		c.maxElements = n
+		WithAccessTracking().apply(c)
+	})
+}
+
+// wakes up the evictor if the store has capacity limits
+func (c *ElementStore) checkCapacity() {
+	if c.maxDiskBytes == 0 && c.maxElements == 0 {
+		return
+	}
+
+	c.evictorStart.Do(func() {
+		c.evictSignal = make(chan struct{}, 1)
+		go c.evictor()
+	})
+
+	select {
+	case c.evictSignal <- struct{}{}:
+	default:
+	}
+}
+
+func (c *ElementStore) evictor() {
+	for {
+		select {
+		case <-c.evictSignal:
+			c.evictToCapacity()
+		case <-c.quit:
+			return
+		}
+	}
+}
+
+// deletes the least recently read elements until the store is within its
+// capacity limits
+func (c *ElementStore) evictToCapacity() {
+	if c.IsReadOnly() {
+		return
+	}
+
+	type candidate struct {
+		id   uint64
+		size int64
+		last time.Time
+	}
+
+	var candidates []candidate
+	var elements int
+	var bytes int64
+	c.storeMutex.RLock()
+	for id, pw := range c.inTransfer {
+		if _, ok := c.onDisk[id]; !ok {
+			elements++
+			bytes += int64(len(pw.elem))
+		}
+	}
+
+	for id, size := range c.onDisk {
+		elements++
+		bytes += size
+		if _, ok := c.inTransfer[id]; !ok {
+			candidates = append(candidates, candidate{id: id, size: size})
+		}
+	}
+
+	c.storeMutex.RUnlock()
+
+	over := func() bool {
+		return (c.maxElements > 0 && elements > c.maxElements) ||
+			(c.maxDiskBytes > 0 && bytes > c.maxDiskBytes)
+	}
+
+	if !over() {
+		return
+	}
+
+	for i := range candidates {
+		cand := &candidates[i]
+		if st := c.accessOf(cand.id); st.last != 0 {
+			cand.last = time.Unix(0, st.last)
+		} else {
+			cand.last, _ = c.inserted.get(cand.id)
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if !candidates[i].last.Equal(candidates[j].last) {
+			return candidates[i].last.Before(candidates[j].last)
+		}
+
+		return candidates[i].id < candidates[j].id
+	})
+
+	for _, cand := range candidates {
+		if !over() {
+			return
+		}
+
+		if err := c.Delete(cand.id); err != nil && err != ErrDoesNotExist {
+			return
+		}
+
+		elements--
+		bytes -= cand.size
+		atomic.AddUint64(&c.evictions, 1)
+	}
+}
@@ -0,0 +1,44 @@
+package elstore
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCapacityLimits(t *testing.T) {
+	if _, err := NewElementStore(0, testDir, WithMaxElements(-1)); err == nil {
+		t.Fatal("expected error for negative limit")
+	}
+
+	for _, opt := range []Option{WithMaxElements(2), WithMaxDiskBytes(int64(2 * len(testData2)))} {
+		c, err := NewElementStore(0, testDir, opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c.Put(testData2, 1)
+		c.Put(testData2, 2)
+		c.Sync()
+		c.Get(1)
+		c.Put(testData2, 3)
+
+		// 2 was never read, and was inserted before 1 was read
+		for i := 0; c.Has(2); i++ {
+			if i == 100 {
+				c.Remove()
+				t.Fatal("least recently read element not evicted")
+			}
+
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		if !c.Has(1) || !c.Has(3) || c.Stats().DiskEvictions != 1 {
+			c.Remove()
+			t.Fatal("unexpected elements after eviction", c.IDs())
+		}
+
+		if err := c.Remove(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package elstore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"os"
+	"time"
+)
+
+// Element files start with a header of a magic string followed by the
+// CRC-32 (IEEE) of the element, big endian. Files without the header were
+// written before checksums were introduced, and are served unverified
+const checksumMagic = "\x89ELS\r\n\x1a\n"
+const headerSize = len(checksumMagic) + 4
+
+func elementHeader(elem []byte) []byte {
+	hdr := make([]byte, headerSize)
+	copy(hdr, checksumMagic)
+	binary.BigEndian.PutUint32(hdr[len(checksumMagic):], crc32.ChecksumIEEE(elem))
+	return hdr
+}
+
+// returns the element of the contents of an element file, and true if its
+// checksum was verified. Returns ErrChecksumMismatch if the element is
+// corrupt
+func decodeElement(data []byte) ([]byte, bool, error) {
+	if isCompressed(data) {
+		elem, err := decompressElement(data)
+		return elem, err == nil, err
+	}
+
+	if len(data) < headerSize || !bytes.HasPrefix(data, []byte(checksumMagic)) {
+		return data, false, nil
+	}
+
+	elem := data[headerSize:]
+	sum := binary.BigEndian.Uint32(data[len(checksumMagic):])
+	if crc32.ChecksumIEEE(elem) != sum {
+		return nil, false, ErrChecksumMismatch
+	}
+
+	return elem, true, nil
+}
+
+// returns the size of the element in a file of 'size' bytes, starting
+// with 'prefix'. This is the size of the element as inserted, however it
+// was written
+func elementSize(prefix []byte, size int64) int64 {
+	switch {
+	case len(prefix) >= encryptedSizeEnd &&
+		bytes.HasPrefix(prefix, []byte(encryptedMagic)):
+		return int64(binary.BigEndian.Uint64(prefix[len(encryptedMagic):]))
+	case isCompressed(prefix):
+		return uncompressedSize(prefix)
+	case len(prefix) >= headerSize && bytes.HasPrefix(prefix, []byte(checksumMagic)):
+		return size - int64(headerSize)
+	}
+
+	// written before checksums
+	return size
+}
+
+// returns the size of the element in the file at 'path' of 'size' bytes,
+// reading the header of the file
+func fileElementSize(path string, size int64) int64 {
+	// the longest header telling the size
+	prefix := make([]byte, compressedHeaderSize)
+	f, err := os.Open(path)
+	if err != nil {
+		return elementSize(nil, size)
+	}
+
+	defer f.Close()
+	n, _ := io.ReadFull(f, prefix)
+	return elementSize(prefix[:n], size)
+}
+
+// Checks the checksums of all elements on disk, in the workdir and in the
+// standby, e.g. as a periodic scrub. Elements written before checksums
+// were introduced are neither verified nor reported as mismatched
+//
+// Returns the first error other than a mismatch that prevented an element
+// from being checked, along with the report of the elements checked
+func (c *ElementStore) Verify() (report VerifyReport, err error) {
+	defer func(start time.Time) { report.Duration = time.Since(start) }(time.Now())
+	c.storeMutex.RLock()
+	ids := make([]uint64, 0, len(c.onDisk))
+	for id := range c.onDisk {
+		ids = append(ids, id)
+	}
+
+	c.storeMutex.RUnlock()
+	sortIDs(ids)
+	for _, id := range ids {
+		verified, mismatch := false, false
+		for _, base := range []string{c.root(id), c.standby} {
+			if base == "" {
+				continue
+			}
+
+			data, err := c.readRaw(base, id)
+			if os.IsNotExist(err) {
+				// deleted, staged or written while failed over
+				continue
+			} else if err != nil {
+				return report, err
+			}
+
+			c.ioThrottle.wait(len(data), 1)
+			report.Bytes += int64(len(data))
+			if data, err = c.decryptElement(data); err != nil {
+				return report, err
+			}
+
+			if _, ok, err := decodeElement(data); err != nil {
+				mismatch = true
+			} else if ok {
+				verified = true
+			}
+		}
+
+		if mismatch {
+			report.Mismatched = append(report.Mismatched, id)
+		} else if verified {
+			report.Verified++
+		}
+	}
+
+	return report, nil
+}
@@ -0,0 +1,66 @@
+package elstore
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"testing"
+)
+
+func TestChecksums(t *testing.T) {
+	c, err := NewElementStore(0, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	for id := uint64(1); id <= 3; id++ {
+		if err := c.Put(testData2, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c.Sync()
+
+	// flip a bit of 2, and replace 3 with a file written before checksums
+	path := elFile(testDir, 2)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data[len(data)-1] ^= 1
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(elFile(testDir, 3), testData2, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.Get(2); err != ErrChecksumMismatch {
+		t.Fatal("expected ErrChecksumMismatch, got", err)
+	} else if !errors.Is(err, ErrCorrupted) {
+		t.Fatal("expected ErrCorrupted, got", err)
+	}
+
+	for _, id := range []uint64{1, 3} {
+		if data, err := c.Get(id); err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(data, testData2) {
+			t.Fatalf("expected\n%v\n\ngot\n%v\n\n", testData2, data)
+		}
+	}
+
+	report, err := c.Verify()
+	if err != nil {
+		t.Fatal(err)
+	} else if report.Verified != 1 || len(report.Mismatched) != 1 || report.Mismatched[0] != 2 {
+		t.Fatal("unexpected report", report)
+	}
+
+	// sizes don't include the header
+	if s := c.Stats(); s.Bytes != 3*uint64(len(testData2)) {
+		t.Fatal("unexpected size", s.Bytes)
+	}
+}
@@ -0,0 +1,36 @@
+package elstore
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+var ErrClosed = errors.New("Store is closed")
+
+// Closes the store, leaving its files in place. Puts and deletes are
+// refused from the moment Close is called, pending writes and deletes are
+// completed, the index and access times are written and the workdir is
+// released for other stores. The store can't be used afterwards
+//
+// Returns ErrClosed if the store is already closed
+func (c *ElementStore) Close() error {
+	c.storeMutex.Lock()
+	closed := atomic.SwapInt32(&c.closed, 1) == 1
+	c.storeMutex.Unlock()
+	if closed {
+		return ErrClosed
+	}
+
+	if err := c.Sync(); err != nil {
+		return err
+	}
+
+	return c.release()
+}
+
+// returns true once Close has been called. Operations that add to
+// activeWrites or deletes check it while holding storeMutex, so that Close
+// never waits for work scheduled after it
+func (c *ElementStore) isClosed() bool {
+	return atomic.LoadInt32(&c.closed) == 1
+}
@@ -0,0 +1,51 @@
+package elstore
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestClose(t *testing.T) {
+	c, err := NewElementStore(0, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	if err := c.Put(testData2, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// pending writes are completed
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Close(); err != ErrClosed {
+		t.Fatal("expected ErrClosed on second Close, got", err)
+	} else if err := c.Put(testData2, 2); err != ErrClosed {
+		t.Fatal("expected ErrClosed from Put, got", err)
+	} else if _, err := c.Get(1); err != ErrClosed {
+		t.Fatal("expected ErrClosed from Get, got", err)
+	} else if err := c.Delete(1); err != ErrClosed {
+		t.Fatal("expected ErrClosed from Delete, got", err)
+	} else if err := c.Alias(2, 1); err != ErrClosed {
+		t.Fatal("expected ErrClosed from Alias, got", err)
+	} else if _, _, err := c.GetReader(1); err != ErrClosed {
+		t.Fatal("expected ErrClosed from GetReader, got", err)
+	}
+
+	// the workdir is released
+	c, err = NewElementStore(0, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	data, err := c.Get(1)
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(data, testData2) {
+		t.Fatalf("expected\n%v\n\ngot\n%v\n\n", testData2, data)
+	}
+}
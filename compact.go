@@ -0,0 +1,181 @@
+package elstore
+
+import (
+	"io"
+	"math"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// Per-call options for CompactSegmentsWith. The zero value behaves like
+// CompactSegments
+type CompactOpts struct {
+	// Report what would be compacted without changing anything
+	DryRun bool
+}
+
+// Segments compacted by CompactSegmentsWith, or that would be in a dry run
+type CompactReport struct {
+	Segments  int   // segments removed
+	Moved     int   // elements moved out of them
+	Reclaimed int64 // bytes held by deleted and superseded elements
+	DryRun    bool
+	Duration  time.Duration
+}
+
+// Returns the report in the form shared by maintenance operations
+func (r CompactReport) Report() Report {
+	return Report{
+		Operation: "compact",
+		DryRun:    r.DryRun,
+		Duration:  r.Duration,
+		Elements:  r.Moved,
+		Bytes:     r.Reclaimed,
+	}
+}
+
+// Rewrites the elements of segments where more than half of the space is
+// held by deleted and superseded elements to the end of the last segment,
+// and removes the old segments. The last segment is left as it is, as are
+// segments with a damaged record
+//
+// Elements are read from where they were until they've been moved, so
+// reads don't wait for compaction; Stats counts such reads. Compaction
+// counts towards the background IO limit
+func (c *ElementStore) CompactSegments() error {
+	_, err := c.CompactSegmentsWith(CompactOpts{})
+	return err
+}
+
+// Like CompactSegments, with options, returning a report of the compacted
+// segments. Dry runs are allowed on read-only stores
+func (c *ElementStore) CompactSegmentsWith(opts CompactOpts) (report CompactReport, err error) {
+	defer func(start time.Time) { report.Duration = time.Since(start) }(time.Now())
+	report.DryRun = opts.DryRun
+	if c.IsReadOnly() && !opts.DryRun {
+		return report, ErrReadOnly
+	} else if c.segments == nil {
+		return report, nil
+	}
+
+	s := c.segments
+	s.compactMu.Lock()
+	defer s.compactMu.Unlock()
+
+	s.mu.RLock()
+	live := make([]int64, len(s.files))
+	elements := make([]int, len(s.files))
+	for _, loc := range s.index {
+		live[loc.seg] += int64(segmentRecordHeader + loc.n)
+		elements[loc.seg]++
+	}
+
+	var segs []int
+	for seg := 0; seg < len(s.files)-1; seg++ {
+		if s.files[seg] == nil || s.damaged[seg] {
+			continue
+		}
+
+		if fi, err := s.files[seg].Stat(); err == nil && live[seg]*2 < fi.Size() {
+			segs = append(segs, seg)
+			report.Moved += elements[seg]
+			report.Reclaimed += fi.Size() - live[seg]
+		}
+	}
+
+	s.mu.RUnlock()
+	report.Segments = len(segs)
+	if opts.DryRun {
+		return report, nil
+	}
+
+	for _, seg := range segs {
+		if err := c.compactSegment(seg); err != nil {
+			return report, err
+		}
+	}
+
+	return report, nil
+}
+
+func (c *ElementStore) compactSegment(seg int) error {
+	s := c.segments
+	s.mu.RLock()
+	if seg >= len(s.files) {
+		// closed
+		s.mu.RUnlock()
+		return os.ErrClosed
+	}
+
+	f, older := s.files[seg], false
+	for _, other := range s.files[:seg] {
+		older = older || other != nil
+	}
+
+	// elements are moved to this segment and the ones after it
+	first := len(s.files) - 1
+	s.mu.RUnlock()
+
+	atomic.StoreInt32(&s.compacting, int32(seg+1))
+	defer atomic.StoreInt32(&s.compacting, 0)
+
+	var err error
+	size, _ := scanSegment(io.NewSectionReader(f, 0, math.MaxInt64),
+		func(rec segmentRecord) {
+			if err != nil {
+				return
+			}
+
+			c.ioThrottle.wait(segmentRecordHeader+len(rec.data), 1)
+			s.appendMu.Lock()
+			defer s.appendMu.Unlock()
+			loc, ok := s.lookup(rec.id)
+			if rec.data == nil && (ok || !older) {
+				// superseded, or nothing older left to hide
+				return
+			} else if rec.data != nil && (!ok || loc.seg != seg || loc.off != rec.off) {
+				// deleted or superseded
+				return
+			}
+
+			// moved records keep the time they were written
+			var n int
+			_, n, err = s.appendRecord(rec.id, rec.data, rec.written, false, nil)
+			atomic.AddUint64(&c.io.written, uint64(n))
+		})
+
+	atomic.AddUint64(&c.io.read, uint64(size))
+	if err != nil {
+		return err
+	}
+
+	// the moved elements must be on disk before the old segment is gone,
+	// whatever the durability
+	s.mu.RLock()
+	if first >= len(s.files) {
+		s.mu.RUnlock()
+		return os.ErrClosed
+	}
+
+	moved := append([]*os.File(nil), s.files[first:]...)
+	s.mu.RUnlock()
+	for _, f := range moved {
+		if err := f.Sync(); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	if seg < len(s.files) {
+		s.files[seg] = nil
+	}
+
+	s.mu.Unlock()
+	f.Close()
+	if err := os.Remove(f.Name()); err != nil {
+		return err
+	}
+
+	return c.fileChanged(c.workdir, f.Name(), true)
+}
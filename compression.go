@@ -0,0 +1,131 @@
+package elstore
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+)
+
+// Compression of element files
+type Compression byte
+
+const (
+	NoCompression Compression = iota
+	Gzip
+	Flate
+	Snappy // the block format, faster than Gzip and Flate but compressing less
+)
+
+// Compressed element files start with a header of a magic string, the
+// CRC-32 (IEEE) of the uncompressed element, the Compression used and the
+// size of the uncompressed element, big endian, followed by the compressed
+// element
+const compressedMagic = "\x89ELZ\r\n\x1a\n"
+const compressedHeaderSize = len(compressedMagic) + 13
+
+// Compresses elements written to disk, and decompresses them on read.
+// Elements that don't get smaller are written uncompressed. Files are
+// marked with how they were compressed, so changing the option, or leaving
+// it out, doesn't affect the elements already written
+func WithCompression(compression Compression) Option {
+	if compression > Snappy {
+		return Option{err: fmt.Errorf("%w: compression %v", ErrInvalidOption, compression)}
+	}
+
+	return option(func(c *ElementStore) {
+		c.compression = compression
+	})
+}
+
+func compress(compression Compression, elem []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	var err error
+	switch compression {
+	case Snappy:
+		return snappyEncode(elem), nil
+	case Gzip:
+		w = gzip.NewWriter(&buf)
+	case Flate:
+		w, err = flate.NewWriter(&buf, flate.DefaultCompression)
+	default:
+		return nil, fmt.Errorf("Unknown compression %v", compression)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write(elem); err != nil {
+		return nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// returns the header and body of the file of an element
+func (c *ElementStore) encodeElement(elem []byte) ([]byte, []byte) {
+	if c.compression == NoCompression {
+		return elementHeader(elem), elem
+	}
+
+	z, err := compress(c.compression, elem)
+	if err != nil || len(z)+compressedHeaderSize >= len(elem)+headerSize {
+		return elementHeader(elem), elem
+	}
+
+	hdr := make([]byte, compressedHeaderSize)
+	copy(hdr, compressedMagic)
+	binary.BigEndian.PutUint32(hdr[len(compressedMagic):], crc32.ChecksumIEEE(elem))
+	hdr[len(compressedMagic)+4] = byte(c.compression)
+	binary.BigEndian.PutUint64(hdr[len(compressedMagic)+5:], uint64(len(elem)))
+	return hdr, z
+}
+
+func isCompressed(data []byte) bool {
+	return len(data) >= compressedHeaderSize &&
+		bytes.HasPrefix(data, []byte(compressedMagic))
+}
+
+// returns the size of the uncompressed element of a compressed element file
+func uncompressedSize(data []byte) int64 {
+	return int64(binary.BigEndian.Uint64(data[len(compressedMagic)+5:]))
+}
+
+// decompresses and verifies the element of a compressed element file
+func decompressElement(data []byte) ([]byte, error) {
+	var r io.Reader
+	var elem []byte
+	var err error
+	body := data[compressedHeaderSize:]
+	switch Compression(data[len(compressedMagic)+4]) {
+	case Gzip:
+		r, err = gzip.NewReader(bytes.NewReader(body))
+	case Flate:
+		r = flate.NewReader(bytes.NewReader(body))
+	case Snappy:
+		elem, err = snappyDecode(body)
+	default:
+		return nil, ErrChecksumMismatch
+	}
+
+	if err == nil && r != nil {
+		elem, err = ioutil.ReadAll(r)
+	}
+
+	sum := binary.BigEndian.Uint32(data[len(compressedMagic):])
+	if err != nil || crc32.ChecksumIEEE(elem) != sum {
+		return nil, ErrChecksumMismatch
+	}
+
+	return elem, nil
+}
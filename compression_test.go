@@ -0,0 +1,99 @@
+package elstore
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+func TestCompression(t *testing.T) {
+	compressible := bytes.Repeat([]byte("compressible text "), 100)
+	for _, compression := range []Compression{Gzip, Flate, Snappy} {
+		c, err := NewElementStore(0, testDir, WithCompression(compression))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := c.Put(compressible, 1); err != nil {
+			t.Fatal(err)
+		}
+
+		// too small to gain from compression
+		if err := c.Put(testData2[:4], 2); err != nil {
+			t.Fatal(err)
+		}
+
+		c.Sync()
+		if fi, err := os.Stat(elFile(testDir, 1)); err != nil {
+			t.Fatal(err)
+		} else if fi.Size() >= int64(len(compressible)) {
+			t.Fatal("element not compressed", compression, fi.Size())
+		}
+
+		// elements stay readable without the option, and keep their size
+		c.Close()
+		c, err = NewElementStore(0, testDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if info, err := c.Info(1); err != nil {
+			t.Fatal(err)
+		} else if info.Size != int64(len(compressible)) {
+			t.Fatal("unexpected size after reopen", compression, info.Size)
+		}
+
+		for id, expected := range map[uint64][]byte{1: compressible, 2: testData2[:4]} {
+			if data, err := c.Get(id); err != nil {
+				t.Fatal(err)
+			} else if !bytes.Equal(data, expected) {
+				t.Fatalf("expected\n%v\n\ngot\n%v\n\n", expected, data)
+			}
+		}
+
+		if report, err := c.Verify(); err != nil || report.Verified != 2 {
+			t.Fatal("unexpected report", report, err)
+		}
+
+		if err := c.Remove(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := NewElementStore(0, testDir, WithCompression(Snappy+1)); err == nil {
+		t.Fatal("expected error for unknown compression")
+	}
+}
+
+func TestSnappy(t *testing.T) {
+	// as encoded by the reference implementation
+	encoded := []byte{0x0c, 0x0c, 'a', 'b', 'c', 'd', 0x11, 0x04}
+	if data := snappyEncode([]byte("abcdabcdabcd")); !bytes.Equal(data, encoded) {
+		t.Fatalf("expected\n%v\n\ngot\n%v\n\n", encoded, data)
+	}
+
+	long := bytes.Repeat([]byte("a"), 100000)
+	random := make([]byte, 100000)
+	rand.Read(random)
+	for _, data := range [][]byte{nil, []byte("abc"), long, random,
+		append(testData, testData...)} {
+		decoded, err := snappyDecode(snappyEncode(data))
+		if err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(decoded, data) {
+			t.Fatalf("expected\n%v\n\ngot\n%v\n\n", data, decoded)
+		}
+	}
+
+	for _, corrupt := range [][]byte{
+		{},
+		{0x0c, 0x0c, 'a', 'b', 'c', 'd'}, // short
+		{0x0c, 0x0c, 'a', 'b', 'c', 'd', 0x11, 0x05}, // offset before start
+		{0x04, 0x0c, 'a', 'b', 'c', 'd', 0x11, 0x04}, // longer than told
+	} {
+		if _, err := snappyDecode(corrupt); err != errSnappyCorrupt {
+			t.Fatal("expected errSnappyCorrupt, got", err, corrupt)
+		}
+	}
+}
@@ -0,0 +1,292 @@
+package elstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+// A duration in a configuration, written as a string such as "250ms"
+type configDuration time.Duration
+
+func (d *configDuration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration %s: expected a string such as \"250ms\"", data)
+	}
+
+	return d.set(s)
+}
+
+func (d *configDuration) set(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	*d = configDuration(v)
+	return nil
+}
+
+// names of the settings that select one of a set of values
+var (
+	configCachePolicies = map[string]func() CachePolicy{
+		"lfu": NewLFUPolicy,
+		"lru": NewLRUPolicy,
+		"arc": NewARCPolicy,
+	}
+
+	configCompressions = map[string]Compression{
+		"none":   NoCompression,
+		"gzip":   Gzip,
+		"flate":  Flate,
+		"snappy": Snappy,
+	}
+
+	configDurabilities = map[string]Durability{
+		"no_sync":          NoSync,
+		"sync_on_flush":    SyncOnFlush,
+		"sync_every_write": SyncEveryWrite,
+	}
+)
+
+// unmarshals a JSON string and passes it to 'set'
+func unmarshalConfigName(data []byte, set func(string) error) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("%s: expected a string", data)
+	}
+
+	return set(s)
+}
+
+// A cache policy in a configuration, named "lfu", "lru" or "arc"
+type configCachePolicy string
+
+func (p *configCachePolicy) UnmarshalJSON(data []byte) error {
+	return unmarshalConfigName(data, p.set)
+}
+
+func (p *configCachePolicy) set(s string) error {
+	if _, ok := configCachePolicies[s]; !ok {
+		return fmt.Errorf("unknown cache policy %q", s)
+	}
+
+	*p = configCachePolicy(s)
+	return nil
+}
+
+// A Compression in a configuration, named "none", "gzip", "flate" or
+// "snappy"
+type configCompression Compression
+
+func (comp *configCompression) UnmarshalJSON(data []byte) error {
+	return unmarshalConfigName(data, comp.set)
+}
+
+func (comp *configCompression) set(s string) error {
+	v, ok := configCompressions[s]
+	if !ok {
+		return fmt.Errorf("unknown compression %q", s)
+	}
+
+	*comp = configCompression(v)
+	return nil
+}
+
+// A Durability in a configuration, named "no_sync", "sync_on_flush" or
+// "sync_every_write"
+type configDurability Durability
+
+func (d *configDurability) UnmarshalJSON(data []byte) error {
+	return unmarshalConfigName(data, d.set)
+}
+
+func (d *configDurability) set(s string) error {
+	v, ok := configDurabilities[s]
+	if !ok {
+		return fmt.Errorf("unknown durability %q", s)
+	}
+
+	*d = configDurability(v)
+	return nil
+}
+
+// Settings understood by OptionsFromJSON and OptionsFromEnv. Settings that
+// are left out don't produce an option
+type optionConfig struct {
+	SlowOpThreshold      *configDuration `json:"slow_op_threshold"`
+	ReadTimeout          *configDuration `json:"read_timeout"`
+	BreakerThreshold     int             `json:"circuit_breaker_threshold"`
+	BreakerProbeInterval configDuration  `json:"circuit_breaker_probe_interval"`
+	Standby              string          `json:"standby"`
+	StagingDir           string          `json:"staging_dir"`
+	SharedWriter         bool            `json:"shared_writer"`
+	SharedReader         bool            `json:"shared_reader"`
+	NFSSafe              bool            `json:"nfs_safe"`
+
+	CachePolicy configCachePolicy  `json:"cache_policy"`
+	CacheBytes  *int64             `json:"cache_bytes"`
+	Compression *configCompression `json:"compression"`
+	Durability  *configDurability  `json:"durability"`
+}
+
+func (cfg *optionConfig) options() []Option {
+	var opts []Option
+	if cfg.SlowOpThreshold != nil {
+		opts = append(opts,
+			WithSlowOpThreshold(time.Duration(*cfg.SlowOpThreshold), nil))
+	}
+
+	if cfg.ReadTimeout != nil {
+		opts = append(opts, WithReadTimeout(time.Duration(*cfg.ReadTimeout)))
+	}
+
+	if cfg.BreakerThreshold > 0 {
+		opts = append(opts, WithCircuitBreaker(cfg.BreakerThreshold,
+			time.Duration(cfg.BreakerProbeInterval)))
+	}
+
+	if cfg.Standby != "" {
+		opts = append(opts, WithStandby(cfg.Standby))
+	}
+
+	if cfg.StagingDir != "" {
+		opts = append(opts, WithStagingDir(cfg.StagingDir))
+	}
+
+	if cfg.SharedWriter {
+		opts = append(opts, WithSharedWriter())
+	}
+
+	if cfg.SharedReader {
+		opts = append(opts, WithSharedReader())
+	}
+
+	if cfg.NFSSafe {
+		opts = append(opts, WithNFSSafe())
+	}
+
+	if cfg.CachePolicy != "" {
+		opts = append(opts, WithCachePolicy(configCachePolicies[string(cfg.CachePolicy)]()))
+	}
+
+	if cfg.CacheBytes != nil {
+		opts = append(opts, WithMaxCacheBytes(*cfg.CacheBytes))
+	}
+
+	if cfg.Compression != nil {
+		opts = append(opts, WithCompression(Compression(*cfg.Compression)))
+	}
+
+	if cfg.Durability != nil {
+		opts = append(opts, WithDurability(Durability(*cfg.Durability)))
+	}
+
+	return opts
+}
+
+// Reads options from a JSON object such as
+//
+//	{
+//		"slow_op_threshold": "250ms",
+//		"read_timeout": "2s",
+//		"circuit_breaker_threshold": 5,
+//		"circuit_breaker_probe_interval": "10s",
+//		"standby": "/mnt/disk2/store",
+//		"staging_dir": "/var/tmp/store-staging",
+//		"shared_writer": false,
+//		"shared_reader": false,
+//		"nfs_safe": false,
+//		"cache_policy": "lru",
+//		"cache_bytes": 67108864,
+//		"compression": "gzip",
+//		"durability": "sync_on_flush"
+//	}
+//
+// All settings are optional. Slow operations are logged. Unknown settings
+// are reported as errors. The cache policy is one of "lfu", "lru" and
+// "arc", the compression one of "none", "gzip", "flate" and "snappy", and
+// the durability one of "no_sync", "sync_on_flush" and "sync_every_write"
+//
+// YAML is not supported. A YAML configuration can be converted to JSON by
+// the caller
+func OptionsFromJSON(r io.Reader) ([]Option, error) {
+	var cfg optionConfig
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, err
+	}
+
+	return cfg.options(), nil
+}
+
+// Reads options from environment variables named as the settings of
+// OptionsFromJSON in upper case and prefixed by 'prefix', e.g.
+// ELSTORE_READ_TIMEOUT=2s for the prefix "ELSTORE_"
+func OptionsFromEnv(prefix string) ([]Option, error) {
+	var cfg optionConfig
+	setDuration := func(d **configDuration) func(string) error {
+		return func(s string) error {
+			*d = new(configDuration)
+			return (*d).set(s)
+		}
+	}
+
+	setBool := func(b *bool) func(string) error {
+		return func(s string) (err error) {
+			*b, err = strconv.ParseBool(s)
+			return err
+		}
+	}
+
+	vars := []struct {
+		name string
+		set  func(string) error
+	}{
+		{"SLOW_OP_THRESHOLD", setDuration(&cfg.SlowOpThreshold)},
+		{"READ_TIMEOUT", setDuration(&cfg.ReadTimeout)},
+		{"CIRCUIT_BREAKER_THRESHOLD", func(s string) (err error) {
+			cfg.BreakerThreshold, err = strconv.Atoi(s)
+			return err
+		}},
+		{"CIRCUIT_BREAKER_PROBE_INTERVAL", cfg.BreakerProbeInterval.set},
+		{"STANDBY", func(s string) error { cfg.Standby = s; return nil }},
+		{"STAGING_DIR", func(s string) error { cfg.StagingDir = s; return nil }},
+		{"SHARED_WRITER", setBool(&cfg.SharedWriter)},
+		{"SHARED_READER", setBool(&cfg.SharedReader)},
+		{"NFS_SAFE", setBool(&cfg.NFSSafe)},
+		{"CACHE_POLICY", cfg.CachePolicy.set},
+		{"CACHE_BYTES", func(s string) error {
+			cfg.CacheBytes = new(int64)
+			v, err := strconv.ParseInt(s, 10, 64)
+			*cfg.CacheBytes = v
+			return err
+		}},
+		{"COMPRESSION", func(s string) error {
+			cfg.Compression = new(configCompression)
+			return cfg.Compression.set(s)
+		}},
+		{"DURABILITY", func(s string) error {
+			cfg.Durability = new(configDurability)
+			return cfg.Durability.set(s)
+		}},
+	}
+
+	for _, v := range vars {
+		s, ok := os.LookupEnv(prefix + v.name)
+		if !ok {
+			continue
+		}
+
+		if err := v.set(s); err != nil {
+			return nil, fmt.Errorf("%s%s: %v", prefix, v.name, err)
+		}
+	}
+
+	return cfg.options(), nil
+}
@@ -0,0 +1,97 @@
+package elstore
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func applyOptions(opts []Option) *ElementStore {
+	c := &ElementStore{}
+	for _, opt := range opts {
+		opt.apply(c)
+	}
+
+	return c
+}
+
+func TestOptionsFromJSON(t *testing.T) {
+	opts, err := OptionsFromJSON(strings.NewReader(`{
+		"read_timeout": "2s",
+		"circuit_breaker_threshold": 5,
+		"circuit_breaker_probe_interval": "10s",
+		"standby": "standby-dir",
+		"nfs_safe": true,
+		"cache_policy": "lru",
+		"compression": "gzip"
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := applyOptions(opts)
+	if c.loadReadTimeout() != 2*time.Second || c.standby != "standby-dir" ||
+		!c.nfsSafe || c.slowOp.Load() != nil {
+		t.Fatal("options not applied", c)
+	}
+
+	if _, ok := c.inMem.(*lruPolicy); !ok || c.compression != Gzip {
+		t.Fatal("cache policy or compression not applied", c.inMem, c.compression)
+	}
+
+	if c.breaker == nil || c.breaker.threshold != 5 ||
+		c.breaker.interval != 10*time.Second {
+		t.Fatal("circuit breaker not configured", c.breaker)
+	}
+
+	for _, bad := range []string{
+		`{"read_timeout": 2}`,
+		`{"read_timeout": "soon"}`,
+		`{"cache_size": 10}`,
+		`{"cache_policy": "random"}`,
+		`{"compression": 1}`,
+		`{"durability": "sometimes"}`,
+	} {
+		if _, err := OptionsFromJSON(strings.NewReader(bad)); err == nil {
+			t.Fatal("expected an error for", bad)
+		}
+	}
+}
+
+func TestOptionsFromEnv(t *testing.T) {
+	t.Setenv("ELSTORE_TEST_SLOW_OP_THRESHOLD", "100ms")
+	t.Setenv("ELSTORE_TEST_STAGING_DIR", "staging-dir")
+	t.Setenv("ELSTORE_TEST_SHARED_WRITER", "true")
+	t.Setenv("ELSTORE_TEST_CACHE_BYTES", "4096")
+	t.Setenv("ELSTORE_TEST_DURABILITY", "sync_every_write")
+	opts, err := OptionsFromEnv("ELSTORE_TEST_")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := applyOptions(opts)
+	cfg, _ := c.slowOp.Load().(*slowOpConfig)
+	if cfg == nil || cfg.threshold != 100*time.Millisecond ||
+		c.staging != "staging-dir" || !c.sharedWriter || c.loadReadTimeout() != 0 {
+		t.Fatal("options not applied", c)
+	}
+
+	if c.cacheByteLimit() != 4096 || c.durability != SyncEveryWrite ||
+		c.compression != NoCompression {
+		t.Fatal("cache bytes or durability not applied", c.cacheByteLimit(), c.durability)
+	}
+
+	t.Setenv("ELSTORE_TEST_COMPRESSION", "zip")
+	if _, err := OptionsFromEnv("ELSTORE_TEST_"); err == nil ||
+		!strings.Contains(err.Error(), "ELSTORE_TEST_COMPRESSION") {
+		t.Fatal("expected an error naming the variable, got", err)
+	}
+
+	t.Setenv("ELSTORE_TEST_COMPRESSION", "flate")
+
+	t.Setenv("ELSTORE_TEST_NFS_SAFE", "maybe")
+	if _, err := OptionsFromEnv("ELSTORE_TEST_"); err == nil ||
+		!strings.Contains(err.Error(), "ELSTORE_TEST_NFS_SAFE") {
+		t.Fatal("expected an error naming the variable, got", err)
+	}
+}
@@ -0,0 +1,78 @@
+package elstore
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+)
+
+// Reads are counted in sharded buffers and merged into readCounters when
+// the cache makes an admission decision, or when a shard fills up. A
+// reader only locks a randomly picked shard, so concurrent readers rarely
+// contend, and storeMutex is left alone on the hot path
+const (
+	readBufShards    = 16
+	readBufFlushSize = 1024
+)
+
+type readBuffer struct {
+	mu     sync.Mutex
+	counts map[uint64]uint64
+	_      [48]byte // keep shards on separate cache lines
+}
+
+func saturatingAdd(a, b uint64) uint64 {
+	if a > math.MaxUint64-b { //overflow check
+		return math.MaxUint64
+	}
+
+	return a + b
+}
+
+func (c *ElementStore) countRead(id uint64) {
+	c.recordAccess(id)
+	if !c.cacheEnabled() {
+		return
+	}
+
+	buf := &c.readBufs[rand.Intn(readBufShards)]
+	buf.mu.Lock()
+	if buf.counts == nil {
+		buf.counts = make(map[uint64]uint64)
+	}
+
+	buf.counts[id] = saturatingAdd(buf.counts[id], 1)
+	full := len(buf.counts) >= readBufFlushSize
+	buf.mu.Unlock()
+
+	if full {
+		c.storeMutex.Lock()
+		c.mergeReadCounts()
+		c.storeMutex.Unlock()
+	}
+}
+
+// XXX: Assumes a storeMutex write lock is held
+func (c *ElementStore) mergeReadCounts() {
+	for i := range c.readBufs {
+		buf := &c.readBufs[i]
+		buf.mu.Lock()
+		for id, n := range buf.counts {
+			c.readCounters[id] = saturatingAdd(c.readCounters[id], n)
+			c.inMem.Hit(id, n)
+		}
+
+		buf.counts = nil
+		buf.mu.Unlock()
+	}
+}
+
+// XXX: Assumes a storeMutex write lock is held
+func (c *ElementStore) dropReadCounts() {
+	for i := range c.readBufs {
+		buf := &c.readBufs[i]
+		buf.mu.Lock()
+		buf.counts = nil
+		buf.mu.Unlock()
+	}
+}
@@ -0,0 +1,149 @@
+package elstore
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Kinds of errors counted by a DegradationPolicy
+type ErrorKind int
+
+const (
+	// Elements that could not be written, see FailedWrites
+	WriteFailures ErrorKind = iota
+
+	// Disk reads that took longer than WithReadTimeout
+	ReadTimeouts
+
+	// Elements read from disk with a checksum mismatch
+	Corruption
+
+	errorKinds
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case WriteFailures:
+		return "write failures"
+	case ReadTimeouts:
+		return "read timeouts"
+	case Corruption:
+		return "corruption"
+	default:
+		return "unknown"
+	}
+}
+
+// Error thresholds at which the store calls for action, e.g. to shed load,
+// switch to a fallback store or page someone
+type DegradationPolicy struct {
+	// Errors are counted over a sliding window of this length. The
+	// default is one minute
+	Window time.Duration
+
+	// Number of errors of each kind within Window at which OnDegraded is
+	// called. Zero disables the check
+	MaxWriteFailures int
+	MaxReadTimeouts  int
+	MaxCorruptions   int
+
+	// Called when a threshold is reached. It's not called again for the
+	// same kind of error until an error occurs with the count below the
+	// threshold. Called from the goroutine that hit the error, and should
+	// not block
+	OnDegraded func(Degradation)
+}
+
+// Passed to DegradationPolicy.OnDegraded
+type Degradation struct {
+	Kind    ErrorKind
+	Count   int           // errors within Window, the threshold
+	Window  time.Duration // as in the policy
+	LastErr error
+}
+
+func (d Degradation) String() string {
+	return fmt.Sprintf("%v %v within %v, last: %v", d.Count, d.Kind, d.Window, d.LastErr)
+}
+
+// Calls the OnDegraded callback of 'policy' when write failures, read
+// timeouts or corrupt reads cross its thresholds
+func WithDegradationPolicy(policy DegradationPolicy) Option {
+	if policy.OnDegraded == nil {
+		return Option{err: fmt.Errorf("%w: degradation policy without callback",
+			ErrInvalidOption)}
+	} else if policy.Window < 0 || policy.MaxWriteFailures < 0 ||
+		policy.MaxReadTimeouts < 0 || policy.MaxCorruptions < 0 {
+		return Option{err: fmt.Errorf("%w: negative degradation threshold",
+			ErrInvalidOption)}
+	}
+
+	if policy.Window == 0 {
+		policy.Window = time.Minute
+	}
+
+	return option(func(c *ElementStore) {
+		d := &degradationTracker{policy: policy}
+		for kind, max := range []int{policy.MaxWriteFailures,
+			policy.MaxReadTimeouts, policy.MaxCorruptions} {
+			if max > 0 {
+				d.recent[kind] = make([]time.Time, max)
+			}
+		}
+
+		c.degradation = d
+	})
+}
+
+// keeps the times of the last errors of each kind, up to the threshold. A
+// nil *degradationTracker is valid and ignores errors
+type degradationTracker struct {
+	policy DegradationPolicy
+
+	mu        sync.Mutex
+	recent    [errorKinds][]time.Time // ring buffers, oldest at next
+	next      [errorKinds]int
+	count     [errorKinds]int
+	triggered [errorKinds]bool
+}
+
+func (d *degradationTracker) record(kind ErrorKind, err error) {
+	if d == nil || d.recent[kind] == nil {
+		return
+	}
+
+	now := time.Now()
+	d.mu.Lock()
+	ring := d.recent[kind]
+	ring[d.next[kind]] = now
+	d.next[kind] = (d.next[kind] + 1) % len(ring)
+	if d.count[kind] < len(ring) {
+		d.count[kind]++
+	}
+
+	oldest := ring[d.next[kind]]
+	crossed := d.count[kind] == len(ring) && now.Sub(oldest) <= d.policy.Window
+	fire := crossed && !d.triggered[kind]
+	d.triggered[kind] = crossed
+	d.mu.Unlock()
+
+	if fire {
+		d.policy.OnDegraded(Degradation{
+			Kind:    kind,
+			Count:   len(ring),
+			Window:  d.policy.Window,
+			LastErr: err,
+		})
+	}
+}
+
+// counts an error of a disk read against the degradation policy
+func (c *ElementStore) recordReadError(err error) {
+	if err == ErrReadTimeout {
+		c.degradation.record(ReadTimeouts, err)
+	} else if errors.Is(err, ErrChecksumMismatch) {
+		c.degradation.record(Corruption, err)
+	}
+}
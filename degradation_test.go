@@ -0,0 +1,57 @@
+package elstore
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestDegradationPolicy(t *testing.T) {
+	if _, err := NewElementStore(0, testDir, WithDegradationPolicy(DegradationPolicy{})); err == nil {
+		t.Fatal("expected error for policy without callback")
+	}
+
+	var got []Degradation
+	c, err := NewElementStore(0, testDir, WithDegradationPolicy(DegradationPolicy{
+		Window:         50 * time.Millisecond,
+		MaxCorruptions: 2,
+		OnDegraded:     func(d Degradation) { got = append(got, d) },
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	c.Put(testData2, 1)
+	c.Sync()
+	path := elFile(testDir, 1)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data[len(data)-1] ^= 1
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// called once when the threshold is reached, not for every error
+	for i := 0; i < 4; i++ {
+		if _, err := c.Get(1); err != ErrChecksumMismatch {
+			t.Fatal("expected ErrChecksumMismatch, got", err)
+		}
+	}
+
+	if len(got) != 1 || got[0].Kind != Corruption || got[0].Count != 2 ||
+		got[0].LastErr != ErrChecksumMismatch {
+		t.Fatal("unexpected degradations", got)
+	}
+
+	// re-armed by an error with the count below the threshold
+	time.Sleep(60 * time.Millisecond)
+	c.Get(1)
+	c.Get(1)
+	if len(got) != 2 {
+		t.Fatal("expected a second degradation, got", got)
+	}
+}
@@ -0,0 +1,364 @@
+package elstore
+
+import (
+	"os"
+	"time"
+)
+
+// A tombstone next to an element file marks an element whose deletion is
+// in progress. Tombstones left by a crash are acted upon at startup, so
+// that no copy of a deleted element, e.g. on the standby, comes back
+const tombstoneSuffix = ".deleted"
+
+// attempts at removing the files of a deleted element before leaving them
+// to the next startup
+const deleteAttempts = 5
+
+func (c *ElementStore) parseTombstone(name string) (uint64, bool) {
+	return c.parseSuffixed(name, tombstoneSuffix)
+}
+
+// removes the files of an element from all directories, returning the
+// first error other than the file not existing
+func (c *ElementStore) deleteElementFiles(id uint64) error {
+	var ret error
+	for _, base := range []string{c.root(id), c.standby, c.staging} {
+		if base == "" {
+			continue
+		}
+
+		path := c.elFile(base, id)
+		err := os.Remove(path)
+		if err == nil {
+			c.fileChanged(base, path, true)
+		} else if !os.IsNotExist(err) && ret == nil {
+			ret = err
+		}
+	}
+
+	return ret
+}
+
+func (c *ElementStore) removeAliasFiles(id uint64) {
+	os.Remove(c.aliasFile(c.root(id), id))
+	if c.standby != "" {
+		os.Remove(c.aliasFile(c.standby, id))
+	}
+}
+
+func (c *ElementStore) cacheGeneration() uint64 {
+	c.storeMutex.RLock()
+	defer c.storeMutex.RUnlock()
+	return c.cacheGen
+}
+
+// XXX: Assumes a storeMutex-lock is held
+func (c *ElementStore) forgetCached(id uint64) {
+	c.cacheGen++
+	if c.memTier != nil {
+		c.memTier.Delete(id)
+	}
+
+	c.inMem.Remove(id)
+	c.uncache(id)
+	delete(c.readCounters, id)
+	c.forgetAccess(id)
+}
+
+// Deletes an element from the store. An element still in transfer to disk
+// is cancelled, and removed from disk if it got there. Deleting an element
+// deletes its aliases as well, while deleting an alias leaves its target
+// in place. Deletes are not passed on to mirrors, only to remote storage
+//
+// The element is tombstoned before Delete returns, while its files are
+// removed in the background. Sync waits for them to be removed
+//
+// Returns ErrDoesNotExist if the ID is not recognized
+func (c *ElementStore) Delete(id uint64) error {
+	if c.IsReadOnly() {
+		return ErrReadOnly
+	}
+
+	c.storeMutex.Lock()
+	defer c.storeMutex.Unlock()
+
+	if c.isClosed() {
+		return ErrClosed
+	}
+
+	if _, ok := c.aliases[id]; ok {
+		delete(c.aliases, id)
+		c.removeAliasFiles(id)
+		return nil
+	}
+
+	if !c.has(id) {
+		return ErrDoesNotExist
+	}
+
+	for alias, target := range c.aliases {
+		if target == id {
+			delete(c.aliases, alias)
+			c.removeAliasFiles(alias)
+		}
+	}
+
+	if _, ok := c.streaming[id]; ok {
+		// removed by PutReader or a synchronous Put when done
+		c.streaming[id] = true
+		return nil
+	}
+
+	c.deleteRemote(id)
+	c.forgetCached(id)
+	c.inserted.forget(id)
+	c.expiries.forget(id)
+	if pw, ok := c.inTransfer[id]; ok {
+		// the write goroutine removes what it has written, like for
+		// CancelPut
+		pw.cancelled = true
+		delete(c.inTransfer, id)
+		c.cancelled[id] = pw
+		return nil
+	}
+
+	delete(c.onDisk, id)
+	if c.segments != nil {
+		if _, ok := c.segments.lookup(id); ok {
+			// the delete record is the tombstone
+			return c.removeSegmented(id)
+		}
+	}
+
+	if pw, ok := c.staged[id]; ok {
+		// being moved into the workdir; removed by writeStaged when done
+		pw.cancelled = true
+		c.cancelled[id] = pw
+		return nil
+	}
+
+	if err := createEmpty(c.tombstone(id), c.fileMode()); os.IsNotExist(err) &&
+		c.remote != nil {
+		// only in remote storage, as its directory was never created
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	c.queueDelete(id)
+	return nil
+}
+
+func (c *ElementStore) tombstone(id uint64) string {
+	return c.elFile(c.root(id), id) + tombstoneSuffix
+}
+
+// queues the files of a tombstoned element for removal
+//
+// XXX: Assumes a storeMutex-lock is held
+func (c *ElementStore) queueDelete(id uint64) {
+	c.deleting[id] = make(chan struct{})
+	c.deleteQueue = append(c.deleteQueue, id)
+	c.deletes.Add(1)
+	if !c.deleterRunning {
+		c.deleterRunning = true
+		go c.deleter()
+	}
+}
+
+// removes the files of queued elements until the queue is empty
+func (c *ElementStore) deleter() {
+	for {
+		c.storeMutex.Lock()
+		if len(c.deleteQueue) == 0 {
+			c.deleteQueue = nil
+			c.deleterRunning = false
+			c.storeMutex.Unlock()
+			return
+		}
+
+		id := c.deleteQueue[0]
+		c.deleteQueue = c.deleteQueue[1:]
+		c.storeMutex.Unlock()
+
+		c.unlink(id)
+
+		c.storeMutex.Lock()
+		close(c.deleting[id])
+		delete(c.deleting, id)
+		c.storeMutex.Unlock()
+		c.deletes.Done()
+	}
+}
+
+// removes the files of a deleted element and then its tombstone. Failures
+// are retried a few times, after which the next startup tries again
+func (c *ElementStore) unlink(id uint64) {
+	backoff := 10 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		c.ioThrottle.wait(0, 1)
+		if c.deleteElementFiles(id) == nil {
+			os.Remove(c.tombstone(id))
+			return
+		} else if attempt == deleteAttempts {
+			return
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func createEmpty(path string, mode os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+// completes deletes interrupted by a crash
+func (c *ElementStore) finishDeletes(tombstones map[uint64]struct{}) {
+	for id := range tombstones {
+		delete(c.onDisk, id)
+		if !c.sharedReader {
+			c.queueDelete(id)
+		}
+	}
+}
+
+// Metadata of an element, as returned by Info and passed to DeleteWhere
+type ElementInfo struct {
+	Size     int64
+	ModTime  time.Time // when the element was written, zero while in transfer
+	Inserted time.Time // when the element was Put, zero while in transfer
+	Reads    uint64    // reads since the store was opened, if tracked
+	Expires  time.Time // zero unless Put with PutWithTTL
+	OnDisk   bool
+	Cached   bool
+}
+
+// Per-call options for DeleteWhereWith. The zero value behaves like
+// DeleteWhere
+type DeleteOpts struct {
+	// Report what would be deleted without deleting anything, e.g. to
+	// validate a retention policy before enabling it
+	DryRun bool
+}
+
+// Elements deleted by DeleteWhereWith, or that would be in a dry run
+type DeleteReport struct {
+	IDs      []uint64 // in ascending order
+	Aliases  []uint64 // deleted with their targets, in ascending order
+	Bytes    int64    // total size of the elements
+	DryRun   bool
+	Duration time.Duration
+}
+
+// Returns the report in the form shared by maintenance operations
+func (r DeleteReport) Report() Report {
+	return Report{
+		Operation: "delete",
+		DryRun:    r.DryRun,
+		Duration:  r.Duration,
+		Elements:  len(r.IDs),
+		Bytes:     r.Bytes,
+	}
+}
+
+// Deletes the elements for which 'fn' returns true, e.g. to enforce a
+// retention policy. 'fn' is passed the metadata of each element in
+// ascending ID order, without reading the elements. Aliases are not passed
+// to 'fn', but are deleted with their targets
+//
+// Returns the number of elements deleted, and the first error of Delete
+func (c *ElementStore) DeleteWhere(fn func(id uint64, info ElementInfo) bool) (int, error) {
+	report, err := c.DeleteWhereWith(fn, DeleteOpts{})
+	return len(report.IDs), err
+}
+
+// Like DeleteWhere, with options, returning a report of the deleted
+// elements. Dry runs are allowed on read-only stores
+func (c *ElementStore) DeleteWhereWith(fn func(id uint64, info ElementInfo) bool,
+	opts DeleteOpts) (report DeleteReport, err error) {
+	defer func(start time.Time) { report.Duration = time.Since(start) }(time.Now())
+	report.DryRun = opts.DryRun
+	if c.IsReadOnly() && !opts.DryRun {
+		return report, ErrReadOnly
+	}
+
+	c.storeMutex.RLock()
+	ids := make([]uint64, 0, len(c.onDisk)+len(c.inTransfer))
+	for id := range c.onDisk {
+		ids = append(ids, id)
+	}
+
+	for id := range c.inTransfer {
+		if _, ok := c.onDisk[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+
+	c.storeMutex.RUnlock()
+
+	sortIDs(ids)
+	for _, id := range ids {
+		info, ok := c.elementInfo(id)
+		if !ok || !fn(id, info) {
+			continue
+		}
+
+		aliases := c.aliasesOf(id)
+		if !opts.DryRun {
+			if err := c.Delete(id); err == ErrDoesNotExist {
+				continue
+			} else if err != nil {
+				return report, err
+			}
+		}
+
+		report.IDs = append(report.IDs, id)
+		report.Aliases = append(report.Aliases, aliases...)
+		report.Bytes += info.Size
+	}
+
+	sortIDs(report.Aliases)
+	return report, nil
+}
+
+// returns the aliases of an element
+func (c *ElementStore) aliasesOf(id uint64) []uint64 {
+	c.storeMutex.RLock()
+	defer c.storeMutex.RUnlock()
+	var aliases []uint64
+	for alias, target := range c.aliases {
+		if target == id {
+			aliases = append(aliases, alias)
+		}
+	}
+
+	return aliases
+}
+
+// returns the modification time of the file of an element, or the zero
+// time if there is none
+func (c *ElementStore) modTime(id uint64) time.Time {
+	if c.segments != nil {
+		if loc, ok := c.segments.lookup(id); ok {
+			return loc.written
+		}
+	}
+
+	for _, base := range []string{c.root(id), c.standby, c.staging} {
+		if base == "" {
+			continue
+		}
+
+		if fi, err := os.Stat(c.elFile(base, id)); err == nil {
+			return fi.ModTime()
+		}
+	}
+
+	return time.Time{}
+}
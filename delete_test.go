@@ -0,0 +1,338 @@
+package elstore
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDelete(t *testing.T) {
+	c, err := NewElementStore(2, testDir, WithStandby(testDir+"-standby"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	if err := c.Put(testData2, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Put(testData2, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Alias(3, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Sync()
+	c.Get(1)
+	c.waitAdmissions()
+	if err := c.Delete(1); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.Get(1); err != ErrDoesNotExist || c.Has(1) {
+		t.Fatal("deleted element still in store", err)
+	}
+
+	// files are removed in the background
+	c.Sync()
+
+	if c.inMem.Len() != 0 {
+		t.Fatal("deleted element still cached")
+	}
+
+	for _, dir := range []string{testDir, testDir + "-standby"} {
+		if _, err := os.Stat(elFile(dir, 1)); !os.IsNotExist(err) {
+			t.Fatal("element file left in", dir, err)
+		}
+	}
+
+	if err := c.Delete(1); err != ErrDoesNotExist {
+		t.Fatal("expected ErrDoesNotExist, got", err)
+	}
+
+	// aliases go with their target
+	if err := c.Delete(2); err != nil {
+		t.Fatal(err)
+	} else if c.Has(3) {
+		t.Fatal("alias of deleted element left")
+	}
+
+	// deleted while in transfer
+	if err := c.Put(testData2, 4); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Delete(4); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Sync()
+	if _, err := os.Stat(elFile(testDir, 4)); !os.IsNotExist(err) || c.Has(4) {
+		t.Fatal("element deleted in transfer written anyway", err)
+	}
+
+	if err := c.Put(testData, 1); err != nil {
+		t.Fatal("unable to reuse deleted ID:", err)
+	}
+}
+
+func TestDeleteTombstone(t *testing.T) {
+	c, err := NewElementStore(0, testDir, WithStandby(testDir+"-standby"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Put(testData2, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Sync()
+
+	// a crash after the tombstone was created, with a copy left on the
+	// standby
+	os.Remove(elFile(testDir, 1))
+	if err := createEmpty(elFile(testDir, 1)+tombstoneSuffix, defaultFileMode); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Close()
+	c, err = NewElementStore(0, testDir, WithStandby(testDir+"-standby"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	if c.Has(1) {
+		t.Fatal("deleted element resurrected from standby")
+	}
+
+	c.Sync()
+
+	for _, path := range []string{elFile(testDir+"-standby", 1), elFile(testDir, 1) + tombstoneSuffix} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Fatal("file left after delete was finished:", path)
+		}
+	}
+}
+
+func TestDeleteQueue(t *testing.T) {
+	c, err := NewElementStore(0, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	for id := uint64(1); id <= 100; id++ {
+		if err := c.Put(testData2, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c.Sync()
+	for id := uint64(1); id <= 100; id++ {
+		if err := c.Delete(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the new element is written once the old files are gone
+	if err := c.Put(testData, 100); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Sync()
+	for id := uint64(1); id < 100; id++ {
+		if _, err := os.Stat(elFile(testDir, id)); !os.IsNotExist(err) {
+			t.Fatal("element file left", id, err)
+		}
+	}
+
+	if _, err := os.Stat(c.tombstone(100)); !os.IsNotExist(err) {
+		t.Fatal("tombstone left", err)
+	}
+
+	data, err := c.GetWith(100, GetOpts{NoCache: true})
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(data, testData) {
+		t.Fatalf("expected\n%v\n\ngot\n%v\n\n", testData, data)
+	}
+}
+
+func TestDeleteWhere(t *testing.T) {
+	c, err := NewElementStore(0, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	for id := uint64(1); id <= 10; id++ {
+		if err := c.Put(make([]byte, id), id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c.Sync()
+	if err := c.Alias(11, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	var seen []uint64
+	start := time.Now().Add(-time.Hour)
+	n, err := c.DeleteWhere(func(id uint64, info ElementInfo) bool {
+		if info.Size != int64(id) || info.ModTime.Before(start) {
+			t.Fatal("unexpected info", id, info)
+		}
+
+		seen = append(seen, id)
+		return info.Size%2 == 0
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	} else if n != 5 || len(seen) != 10 || seen[0] != 1 || seen[9] != 10 {
+		t.Fatal("unexpected deletes", n, seen)
+	}
+
+	for id := uint64(1); id <= 11; id++ {
+		if expected := id%2 == 1 && id != 11; c.Has(id) != expected {
+			t.Fatal("unexpected presence of", id)
+		}
+	}
+}
+
+// the metadata passed to DeleteWhere is that returned by Info
+func TestDeleteWhereInfo(t *testing.T) {
+	c, err := NewElementStore(10, testDir, WithAccessTracking())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	for id := uint64(1); id <= 2; id++ {
+		if err := c.Put(testData2, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c.Sync()
+	c.Get(1)
+	c.Get(1)
+	c.waitAdmissions()
+
+	infos := make(map[uint64]ElementInfo)
+	if _, err := c.DeleteWhere(func(id uint64, info ElementInfo) bool {
+		infos[id] = info
+		return false
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for id, expected := range map[uint64]ElementInfo{
+		1: {Reads: 2, OnDisk: true, Cached: true},
+		2: {Reads: 0, OnDisk: true, Cached: false},
+	} {
+		info, err := c.Info(id)
+		if err != nil {
+			t.Fatal(err)
+		} else if infos[id] != info {
+			t.Fatalf("expected\n%+v\n\ngot\n%+v\n\n", info, infos[id])
+		} else if info.Reads != expected.Reads || !info.OnDisk ||
+			info.Cached != expected.Cached {
+			t.Fatal("unexpected info", id, info)
+		}
+	}
+}
+
+func TestDeleteWhereDryRun(t *testing.T) {
+	c, err := NewElementStore(0, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	for id := uint64(1); id <= 3; id++ {
+		if err := c.Put(testData2, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := c.Alias(4, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Sync()
+	c.SetReadOnly(true)
+	others := func(id uint64, info ElementInfo) bool { return id != 2 }
+	two := func(id uint64, info ElementInfo) bool { return id == 2 }
+	report, err := c.DeleteWhereWith(others, DeleteOpts{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(report.IDs) != 2 || report.IDs[0] != 1 || report.IDs[1] != 3 ||
+		len(report.Aliases) != 0 || report.Bytes != int64(2*len(testData2)) {
+		t.Fatal("unexpected report", report)
+	}
+
+	if report, err := c.DeleteWhereWith(two, DeleteOpts{DryRun: true}); err != nil {
+		t.Fatal(err)
+	} else if len(report.Aliases) != 1 || report.Aliases[0] != 4 {
+		t.Fatal("unexpected aliases", report.Aliases)
+	}
+
+	if len(c.IDs()) != 4 {
+		t.Fatal("dry run deleted elements", c.IDs())
+	}
+
+	c.SetReadOnly(false)
+	if n, err := c.DeleteWhere(others); err != nil || n != 2 {
+		t.Fatal("unexpected result", n, err)
+	}
+}
+
+// an element read from disk right before it's deleted and put again must
+// not be cached once the admitter gets to it
+func TestDeleteStaleAdmission(t *testing.T) {
+	tier := &mapTier{els: make(map[uint64][]byte)}
+	for _, opts := range [][]Option{nil, {WithMemoryTier(tier)}} {
+		c, err := NewElementStore(10, testDir, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c.Put(testData, 1)
+		c.Sync()
+		gen := c.cacheGeneration()
+		stale, err := c.read(context.Background(), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := c.Delete(1); err != nil {
+			t.Fatal(err)
+		} else if err := c.Put(testData2, 1); err != nil {
+			t.Fatal(err)
+		}
+
+		c.Sync()
+		c.admit(stale, 1, gen)
+		c.waitAdmissions()
+		data, err := c.Get(1)
+		if err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(data, testData2) {
+			c.Remove()
+			t.Fatalf("expected\n%v\n\ngot\n%v\n\n", testData2, data)
+		}
+
+		if err := c.Remove(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
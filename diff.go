@@ -0,0 +1,83 @@
+package elstore
+
+import (
+	"hash/crc32"
+	"sort"
+)
+
+// An ElementStorer is a store elements can be enumerated and read from
+type ElementStorer interface {
+	IDs() []uint64
+	Get(id uint64) ([]byte, error)
+}
+
+// Differences between two stores, as reported by Diff. All ID lists are
+// sorted in ascending order
+type DiffReport struct {
+	OnlyInA   []uint64 // IDs only present in the first store
+	OnlyInB   []uint64 // IDs only present in the second store
+	Differing []uint64 // IDs present in both, with differing checksums
+}
+
+// Returns true if the stores had the same contents
+func (r DiffReport) Equal() bool {
+	return len(r.OnlyInA) == 0 && len(r.OnlyInB) == 0 && len(r.Differing) == 0
+}
+
+// Compares the contents of two stores, e.g. a store and its mirror, by
+// listing IDs present in only one of them and comparing the CRC-32
+// checksums of elements present in both. Elements of an *ElementStore are
+// read past its cache
+func Diff(a, b ElementStorer) (DiffReport, error) {
+	var report DiffReport
+
+	inB := make(map[uint64]struct{})
+	for _, id := range b.IDs() {
+		inB[id] = struct{}{}
+	}
+
+	for _, id := range a.IDs() {
+		if _, ok := inB[id]; !ok {
+			report.OnlyInA = append(report.OnlyInA, id)
+			continue
+		}
+
+		delete(inB, id)
+		elA, err := getUncached(a, id)
+		if err != nil {
+			return report, err
+		}
+
+		elB, err := getUncached(b, id)
+		if err != nil {
+			return report, err
+		}
+
+		if crc32.ChecksumIEEE(elA) != crc32.ChecksumIEEE(elB) {
+			report.Differing = append(report.Differing, id)
+		}
+	}
+
+	for id := range inB {
+		report.OnlyInB = append(report.OnlyInB, id)
+	}
+
+	sortIDs(report.OnlyInA)
+	sortIDs(report.OnlyInB)
+	sortIDs(report.Differing)
+	return report, nil
+}
+
+// reads an element without caching it, so that comparing a store doesn't
+// evict its hot elements
+func getUncached(s ElementStorer, id uint64) ([]byte, error) {
+	if c, ok := s.(*ElementStore); ok {
+		return c.GetWith(id, GetOpts{NoCache: true})
+	}
+
+	return s.Get(id)
+}
+
+func sortIDs(ids []uint64) {
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+}
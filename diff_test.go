@@ -0,0 +1,85 @@
+package elstore
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	a, err := NewElementStore(0, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer a.Remove()
+	b, err := NewElementStore(1, testDir+"-b")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer b.Remove()
+
+	a.Put(testData, 1)
+	a.Put(testData, 2)
+	a.Put(testData, 3)
+	b.Put(testData, 2)
+	b.Put(testData2, 3)
+	b.Put(testData2, 4)
+	a.Sync()
+	b.Sync()
+
+	report, err := Diff(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := DiffReport{
+		OnlyInA:   []uint64{1},
+		OnlyInB:   []uint64{4},
+		Differing: []uint64{3},
+	}
+
+	if !reflect.DeepEqual(report, expected) || report.Equal() {
+		t.Fatal("expected", expected, "got", report)
+	}
+
+	report, err = Diff(a, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !report.Equal() {
+		t.Fatal("store differs from itself", report)
+	}
+}
+
+func TestDiffUncached(t *testing.T) {
+	a, err := NewElementStore(0, testDir, WithAccessTracking())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer a.Remove()
+	b, err := NewElementStore(0, testDir+"-b")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer b.Remove()
+	for _, c := range []*ElementStore{a, b} {
+		if err := c.Put(testData2, 1); err != nil {
+			t.Fatal(err)
+		}
+
+		c.Sync()
+	}
+
+	if report, err := Diff(a, b); err != nil || !report.Equal() {
+		t.Fatal("unexpected report", report, err)
+	}
+
+	// reads for comparing don't count as accesses
+	if stats := a.AccessStats(); len(stats) != 1 || stats[0].Reads != 0 {
+		t.Fatal("unexpected stats", stats)
+	}
+}
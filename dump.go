@@ -0,0 +1,131 @@
+package elstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// version of the StateDump format, incremented on incompatible changes
+const stateDumpVersion = 1
+
+// A snapshot of the bookkeeping of a store, as written by DumpState. It
+// holds IDs, sizes, counters and configuration, but no element contents,
+// keys or mirror credentials
+type StateDump struct {
+	Version      int               `json:"version"`
+	Time         time.Time         `json:"time"`
+	Workdir      string            `json:"workdir"`
+	Health       string            `json:"health"`
+	Config       map[string]string `json:"config"`
+	OnDisk       map[uint64]int64  `json:"on_disk"` // ID -> element size
+	InTransfer   []uint64          `json:"in_transfer"`
+	Cancelled    []uint64          `json:"cancelled"`
+	Staged       []uint64          `json:"staged"`
+	Deleting     []uint64          `json:"deleting"`
+	Streaming    []uint64          `json:"streaming"`
+	Aliases      map[uint64]uint64 `json:"aliases"` // alias ID -> target ID
+	Cached       []uint64          `json:"cached"`
+	FailedWrites map[uint64]string `json:"failed_writes"`
+	WriteFailure string            `json:"write_failure,omitempty"`
+	Stats        Stats             `json:"stats"`
+}
+
+// Writes a snapshot of the bookkeeping of the store to 'w' as JSON, for
+// attaching to bug reports. The snapshot can be read back with LoadState
+func (c *ElementStore) DumpState(w io.Writer) error {
+	dump := StateDump{
+		Version:      stateDumpVersion,
+		Time:         time.Now(),
+		Workdir:      c.workdir,
+		Health:       c.Health().String(),
+		Config:       c.runtimeSettings(),
+		OnDisk:       make(map[uint64]int64),
+		Aliases:      make(map[uint64]uint64),
+		FailedWrites: make(map[uint64]string),
+		Stats:        c.Stats(),
+	}
+
+	c.keys.RLock()
+	encrypted := len(c.keys.aeads) > 0 && c.keys.aeads[0] != nil
+	c.keys.RUnlock()
+
+	for k, v := range map[string]string{
+		"compression":     strconv.Itoa(int(c.compression)),
+		"durability":      strconv.Itoa(int(c.durability)),
+		"encrypted":       strconv.FormatBool(encrypted),
+		"shards":          strconv.Itoa(len(c.shards)),
+		"standby":         strconv.FormatBool(c.standby != ""),
+		"staging":         strconv.FormatBool(c.staging != ""),
+		"segments":        strconv.FormatBool(c.segments != nil),
+		"shared_reader":   strconv.FormatBool(c.sharedReader),
+		"shared_writer":   strconv.FormatBool(c.sharedWriter),
+		"nfs_safe":        strconv.FormatBool(c.nfsSafe),
+		"read_only":       strconv.FormatBool(c.IsReadOnly()),
+		"mirrors":         strconv.Itoa(len(c.mirrors)),
+		"access_tracking": strconv.FormatBool(c.accessTracking),
+		"max_disk_bytes":  strconv.FormatInt(c.maxDiskBytes, 10),
+		"max_elements":    strconv.Itoa(c.maxElements),
+	} {
+		dump.Config[k] = v
+	}
+
+	c.storeMutex.RLock()
+	for id, size := range c.onDisk {
+		dump.OnDisk[id] = size
+	}
+
+	for id, target := range c.aliases {
+		dump.Aliases[id] = target
+	}
+
+	for id, err := range c.failedWrites {
+		dump.FailedWrites[id] = err.Error()
+	}
+
+	if err := c.WriteError(); err != nil {
+		dump.WriteFailure = err.Error()
+	}
+
+	dump.InTransfer = keysOf(c.inTransfer)
+	dump.Cancelled = keysOf(c.cancelled)
+	dump.Staged = keysOf(c.staged)
+	dump.Deleting = keysOf(c.deleting)
+	dump.Streaming = keysOf(c.streaming)
+	c.storeMutex.RUnlock()
+
+	c.inMemIDMap.Range(func(id, _ interface{}) bool {
+		dump.Cached = append(dump.Cached, id.(uint64))
+		return true
+	})
+
+	sortIDs(dump.Cached)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(dump)
+}
+
+// Reads a snapshot written by DumpState
+func LoadState(r io.Reader) (StateDump, error) {
+	var dump StateDump
+	if err := json.NewDecoder(r).Decode(&dump); err != nil {
+		return dump, err
+	} else if dump.Version != stateDumpVersion {
+		return dump, fmt.Errorf("unsupported state dump version %v", dump.Version)
+	}
+
+	return dump, nil
+}
+
+// returns the IDs of a map in ascending order
+func keysOf[V any](m map[uint64]V) []uint64 {
+	ids := make([]uint64, 0, len(m))
+	for id := range m {
+		ids = append(ids, id)
+	}
+
+	sortIDs(ids)
+	return ids
+}
@@ -0,0 +1,48 @@
+package elstore
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDumpState(t *testing.T) {
+	c, err := NewElementStore(1, testDir, WithEncryption(make([]byte, 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	c.Put(testData2, 1)
+	c.Put(testData2, 2)
+	c.Alias(3, 1)
+	c.Sync()
+	c.Get(1)
+	c.waitAdmissions()
+
+	var buf bytes.Buffer
+	if err := c.DumpState(&buf); err != nil {
+		t.Fatal(err)
+	} else if strings.Contains(buf.String(), string(testData2)) {
+		t.Fatal("element contents in state dump")
+	}
+
+	dump, err := LoadState(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantOnDisk := map[uint64]int64{1: int64(len(testData2)), 2: int64(len(testData2))}
+	if !reflect.DeepEqual(dump.OnDisk, wantOnDisk) {
+		t.Fatalf("expected\n%v\n\ngot\n%v\n\n", wantOnDisk, dump.OnDisk)
+	} else if dump.Aliases[3] != 1 || !reflect.DeepEqual(dump.Cached, []uint64{1}) ||
+		dump.Config["encrypted"] != "true" || dump.Health != "healthy" ||
+		dump.Stats.Elements != 2 {
+		t.Fatal("unexpected state dump", dump)
+	}
+
+	if _, err := LoadState(strings.NewReader(`{"version": 0}`)); err == nil {
+		t.Fatal("expected error for unsupported version")
+	}
+}
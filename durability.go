@@ -0,0 +1,144 @@
+package elstore
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// How element files are synced to stable storage
+type Durability int
+
+const (
+	// Files are synced by the operating system in its own time, and
+	// elements may be lost on power loss even after Sync has returned
+	NoSync Durability = iota
+
+	// Files written or removed since the last Flush are synced, along with
+	// their directories, by Flush
+	SyncOnFlush
+
+	// Every file is synced, along with its directory, before its write is
+	// completed. Flush syncs the directories of removed files
+	SyncEveryWrite
+)
+
+// Sets how element files are synced to stable storage. The default is
+// NoSync
+func WithDurability(durability Durability) Option {
+	if durability < NoSync || durability > SyncEveryWrite {
+		return Option{err: fmt.Errorf("%w: durability %v", ErrInvalidOption, durability)}
+	}
+
+	return option(func(c *ElementStore) {
+		c.durability = durability
+	})
+}
+
+// paths of element files written or removed since the last Flush
+type dirtyFiles struct {
+	sync.Mutex
+	paths map[string]string // path -> base
+}
+
+func syncPath(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// called when the element file 'path' in 'base' has been written or
+// removed. With SyncEveryWrite, a written file has already been synced by
+// writeData
+func (c *ElementStore) fileChanged(base, path string, removed bool) error {
+	c.indexChanged()
+	switch {
+	case c.durability == SyncEveryWrite && !removed:
+		return c.syncDirs(base, path)
+	case c.durability != NoSync:
+		c.dirty.Lock()
+		if c.dirty.paths == nil {
+			c.dirty.paths = make(map[string]string)
+		}
+
+		c.dirty.paths[path] = base
+		c.dirty.Unlock()
+	}
+
+	return nil
+}
+
+// syncs the directory of 'path', and the entry of that directory in 'base'
+// the first time it's synced
+func (c *ElementStore) syncDirs(base, path string) error {
+	dir := filepath.Dir(path)
+	if err := syncPath(dir); err != nil {
+		return err
+	}
+
+	if _, ok := c.syncedDirs.Load(dir); ok {
+		return nil
+	}
+
+	if err := syncPath(base); err != nil {
+		return err
+	}
+
+	c.syncedDirs.Store(dir, struct{}{})
+	return nil
+}
+
+// Waits for all writes and deletes to complete, like Sync, and syncs the
+// files written or removed since the last Flush, and their directories, to
+// stable storage as set by WithDurability. With NoSync, Flush is the same
+// as Sync
+//
+// Files that failed to sync stay queued for the next Flush
+func (c *ElementStore) Flush() error {
+	if err := c.Sync(); err != nil {
+		return err
+	}
+
+	c.dirty.Lock()
+	paths := c.dirty.paths
+	c.dirty.paths = nil
+	c.dirty.Unlock()
+
+	type file struct{ base, path string }
+	var ret error
+	dirs := make(map[string]file)
+	for path, base := range paths {
+		if c.durability == SyncOnFlush {
+			if err := syncPath(path); err != nil && !os.IsNotExist(err) {
+				c.fileChanged(base, path, false)
+				if ret == nil {
+					ret = err
+				}
+
+				continue
+			}
+		}
+
+		dirs[filepath.Dir(path)] = file{base, path}
+	}
+
+	for _, f := range dirs {
+		if err := c.syncDirs(f.base, f.path); err != nil {
+			c.fileChanged(f.base, f.path, true)
+			if ret == nil {
+				ret = err
+			}
+		}
+	}
+
+	return ret
+}
@@ -0,0 +1,49 @@
+package elstore
+
+import (
+	"testing"
+)
+
+func TestDurability(t *testing.T) {
+	if _, err := NewElementStore(0, testDir, WithDurability(SyncEveryWrite+1)); err == nil {
+		t.Fatal("expected error for unknown durability")
+	}
+
+	for _, durability := range []Durability{NoSync, SyncOnFlush, SyncEveryWrite} {
+		c, err := NewElementStore(0, testDir, WithDurability(durability))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := c.Put(testData2, 1); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := c.Put(testData2, 2); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := c.Delete(2); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := c.Flush(); err != nil {
+			t.Fatal(durability, err)
+		}
+
+		c.dirty.Lock()
+		left := len(c.dirty.paths)
+		c.dirty.Unlock()
+		if left != 0 {
+			t.Fatal("files left to sync after Flush", durability, left)
+		}
+
+		if _, err := c.Get(1); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := c.Remove(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -2,8 +2,8 @@
 Implementation of a file-system backed element store. All inserted
 elements are written to disk. The N most accessed elements are kept in memory
 
-"Elements" in this case are byte slices. A wrapper around ElementStore
-can achieve type safety and use encoding/* for T->[]byte transformation
+"Elements" in this case are byte slices. TypedStore wraps an ElementStore
+for type safety, using encoding/* for T->[]byte transformation
 
 Use case
 
@@ -21,56 +21,247 @@ write error since writes are asyncronous
 package elstore
 
 import (
+	"bytes"
+	"context"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
-	"sort"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 var ErrAlreadyExists = errors.New("Element already exists in store")
 var ErrDoesNotExist = errors.New("Element does not exist in store")
 var ErrSyncTimeout = errors.New("Syncronization timeout")
+var ErrSelfTestFailed = errors.New("Self-test failed")
+var ErrReadTimeout = errors.New("Read timeout")
+var ErrUnavailable = errors.New("Disk layer unavailable")
+var ErrEpochConflict = errors.New("Workdir taken over by another store instance")
+var ErrReadOnly = errors.New("Store is read-only")
 
-type cacheElement struct {
-	Element     []byte
-	ID          uint64
-	accessCount uint64 // used for caching the read count
+// internal signals that a write was cancelled or superseded while in
+// progress
+var errCancelled = errors.New("Write cancelled")
+var errSuperseded = errors.New("Write superseded")
 
+// name of the probe file used by SelfTest. Not a valid hex name, so it's
+// never mistaken for an element by the startup walk
+const selfTestFile = ".selftest"
+
+// an element on its way to disk
+type pendingWrite struct {
+	elem      []byte
+	version   int // incremented when elem is superseded
+	cancelled bool
+	done      chan struct{} // closed when the write goroutine is done
+
+	// a cancelled write of the same ID that must finish before this one
+	// starts, so the two never touch the same file at once
+	prev *pendingWrite
+
+	// closed once the files of a deleted element with the same ID are
+	// removed, if there was one
+	unlinked <-chan struct{}
+
+	accepted time.Time
 }
 
-type elCache []*cacheElement
+// XXX: Assumes a storeMutex-lock is held
+func (c *ElementStore) newPendingWrite(elem []byte, id uint64) *pendingWrite {
+	return &pendingWrite{
+		elem:     elem,
+		done:     make(chan struct{}),
+		prev:     c.cancelled[id],
+		unlinked: c.deleting[id],
+		accepted: time.Now(),
+	}
+}
 
-func (c elCache) Len() int           { return len(c) }
-func (c elCache) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
-func (c elCache) Less(i, j int) bool { return c[i].accessCount < c[j].accessCount }
+// waits for a cancelled write of an ID and for the files of a deleted
+// element with the same ID to be removed, as captured under storeMutex when
+// the ID was reserved. Either may be nil
+func (c *ElementStore) awaitPrevious(id uint64, prev *pendingWrite, unlinked <-chan struct{}) {
+	if prev != nil {
+		<-prev.done
+	}
+
+	if unlinked != nil {
+		<-unlinked
+		// left behind if the delete was given up on
+		os.Remove(c.tombstone(id))
+	}
+}
 
 type ElementStore struct {
-	maxInMem int
-	workdir  string
+	maxInMem      int64 // accessed atomically
+	maxCacheBytes int64 // accessed atomically, 0 for no limit
+	cacheBytes    int64 // total size of the cached elements
+	workdir       string
+	shards        []string
+	compression   Compression
+	shardErrs     shardErrors
+
+	failedWrites      map[uint64]error
+	writeErrorHandler func(id uint64, err error)
+
+	idWaiters map[uint64][]chan struct{} // see WaitForID
 
 	storeMutex   sync.RWMutex
-	inMem        elCache
-	inMemIDMap   map[uint64][]byte
-	inTransfer   map[uint64][]byte
-	onDisk       map[uint64]struct{}
+	inMem        CachePolicy
+	inMemIDMap   sync.Map // ID -> []byte, read without storeMutex
+	inTransfer   map[uint64]*pendingWrite
+	cancelled    map[uint64]*pendingWrite
+	onDisk       map[uint64]int64  // ID -> element size
+	aliases      map[uint64]uint64 // alias ID -> target ID
 	readCounters map[uint64]uint64
+	readBufs     [readBufShards]readBuffer
+
+	// deleted elements whose files are being removed, see queueDelete
+	deleting       map[uint64]chan struct{} // closed when the files are removed
+	deleteQueue    []uint64
+	deletes        sync.WaitGroup
+	deleterRunning bool
 
 	activeWrites sync.WaitGroup
-	writeFailure error
+	writeFailure atomic.Value // writeFailure, see WriteError
+
+	getHitLatency  histogram
+	getDiskLatency histogram
+	putLatency     histogram
+	writeLatency   histogram
+	io             ioCounters
+	writePool      *writePool
+	openReport     OpenReport
+
+	slowOp              atomic.Value // *slowOpConfig
+	configChangeHandler func(ConfigChange)
+
+	readTimeout int64 // time.Duration, accessed atomically
+	degraded    int32
+	breaker     *circuitBreaker
+
+	standby       string
+	failedOver    int32
+	standbyFailed int32
+
+	mirrors     []*mirror
+	mirrorRetry time.Duration
+	remote      *mirror // also the last of the mirrors
+
+	epoch uint64
+
+	sharedWriter bool
+	sharedReader bool
+
+	nfsSafe bool
+
+	staging string
+	staged  map[uint64]*pendingWrite
+
+	cacheOff int32
+
+	// cache admission candidates, handled by a background goroutine
+	admissions    chan admission
+	admitWg       sync.WaitGroup
+	admitterStart sync.Once
+
+	// bumped whenever cached elements are forgotten. An element read
+	// before a bump may have been deleted, and possibly replaced, since,
+	// and is not cached
+	cacheGen uint64
+
+	quit     chan struct{} // closed when the store is shut down
+	quitOnce sync.Once
+
+	registryKey string
+	workdirLock *os.File // nil unless the workdir is locked
+
+	keys keyring
+
+	durability Durability
+	dirty      dirtyFiles
+	syncedDirs sync.Map // directories known to be synced in their parent
+
+	sched      scheduler
+	ioThrottle ioThrottle
+
+	segmentMaxElement int
+	segments          *segmentStore
+
+	readOnly int32 // set by SetReadOnly
+
+	streamThreshold int64
+	streaming       map[uint64]bool // IDs written by PutReader or synchronous Puts, true if deleted
+
+	autoIDs autoIDs
+
+	accessTracking bool
+	accessSince    time.Time
+	access         [accessShards]accessShard
+	accessDirty    int32 // accessed atomically
+	accessFlushMu  sync.Mutex
+
+	inserted timeLog
+
+	expiries timeLog
+	ttlUsed  int32 // accessed atomically
+	janitor  sync.Once
+
+	warmupReadiness bool
+	warming         int32 // accessed atomically
+	warmupDone      chan struct{}
+
+	maxDiskBytes int64
+	maxElements  int
+	evictorStart sync.Once
+	evictSignal  chan struct{}
+	evictions    uint64
+
+	memTier MemoryTier
+
+	dirPerm  os.FileMode // zero for defaultDirMode
+	filePerm os.FileMode // zero for defaultFileMode
+
+	negativeTTL  int64 // time.Duration, accessed atomically
+	negativeHits uint64
+	negative     negativeCache
+
+	degradation *degradationTracker
+
+	indexDirs []string // nil unless indexes are written
+	indexMu   sync.Mutex
+	indexer   sync.Once
+
+	closed      int32 // set by Close
+	synchronous bool
+	naming      fileNaming
+	namingSet   bool       // WithFileNaming was given
+	prevNaming  fileNaming // as recorded in the workdir
+}
+
+// an element read from disk, to be considered for caching
+type admission struct {
+	id  uint64
+	el  []byte
+	gen uint64 // cacheGen when the element was read
 }
 
+// size of the admission candidate queue. Candidates are dropped when it's
+// full; they'll be considered again on their next read from disk
+const admissionQueueSize = 256
+
 func elDir(base string, id uint64) string {
 	subdir := strconv.FormatUint(id&0x3f, 16)
 	return filepath.Join(base, subdir)
 }
 
-func elFile(base string, id uint64) string {
-	file := strconv.FormatUint(id, 16)
-	return filepath.Join(elDir(base, id), file)
+func (c *ElementStore) elFile(base string, id uint64) string {
+	return filepath.Join(elDir(base, id), c.naming.name(id))
 }
 
 // Returns a new ElementStore
@@ -84,45 +275,191 @@ func elFile(base string, id uint64) string {
 //
 // If 'workdir' is prevously used, the new ElementStore will be initiated using
 // the old values, though no cache is initially set
-func NewElementStore(maxInMem int, workdir string) (c *ElementStore, err error) {
+//
+// Returns ErrWorkdirInUse if another store in the process uses 'workdir'.
+// That store must be closed or removed before 'workdir' is opened again
+//
+// Additional behaviour can be configured by passing options
+func NewElementStore(maxInMem int, workdir string, opts ...Option) (c *ElementStore, err error) {
+	start := time.Now()
+	store := &ElementStore{
+		maxInMem:     int64(maxInMem),
+		workdir:      workdir,
+		inTransfer:   make(map[uint64]*pendingWrite),
+		cancelled:    make(map[uint64]*pendingWrite),
+		deleting:     make(map[uint64]chan struct{}),
+		failedWrites: make(map[uint64]error),
+		idWaiters:    make(map[uint64][]chan struct{}),
+		streaming:    make(map[uint64]bool),
+		onDisk:       make(map[uint64]int64),
+		aliases:      make(map[uint64]uint64),
+		readCounters: make(map[uint64]uint64),
+		inMem:        NewLFUPolicy(),
+		staged:       make(map[uint64]*pendingWrite),
+		admissions:   make(chan admission, admissionQueueSize),
+		quit:         make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		if opt.err != nil {
+			return nil, opt.err
+		}
+
+		opt.apply(store)
+	}
 
-	if err := os.MkdirAll(workdir, 0700); err != nil {
+	if err := os.MkdirAll(workdir, store.dirMode()); err != nil {
 		return nil, err
 	}
 
-	store := &ElementStore{
-		maxInMem:     maxInMem,
-		workdir:      workdir,
-		inMemIDMap:   make(map[uint64][]byte),
-		inTransfer:   make(map[uint64][]byte),
-		onDisk:       make(map[uint64]struct{}),
-		readCounters: make(map[uint64]uint64),
+	if err := store.register(); err != nil {
+		return nil, err
 	}
 
+	defer func() {
+		if err != nil {
+			store.release()
+		}
+	}()
+
 	// load IDs from disk
-	walker := func(path string, info os.FileInfo, err error) error {
-		if err == nil && info.Mode()&os.ModeType == 0 {
-			id, err := strconv.ParseUint(info.Name(), 16, 64)
-			if err == nil {
-				// no error, regular file, hexname ~= elem on disk
-				var x struct{}
-				store.onDisk[id] = x
+	incomplete := make(map[uint64]string)
+	tombstones := make(map[uint64]struct{})
+	var tmpFiles []string
+	report := &store.openReport
+
+	// 'size' is the size of the element, if the file is one
+	visit := func(path string, size int64) {
+		name := filepath.Base(path)
+		if id, ok := store.parseName(name); ok {
+			// regular file, hexname ~= elem on disk
+			store.renameLegacy(path, id, "")
+			store.onDisk[id] = size
+		} else if id, ok := store.parseMarker(name); ok {
+			path = store.renameLegacy(path, id, markerSuffix)
+			incomplete[id] = strings.TrimSuffix(path, markerSuffix)
+		} else if id, ok := store.parseAlias(name); ok {
+			path = store.renameLegacy(path, id, aliasSuffix)
+			if target, err := readAlias(path); err == nil {
+				store.aliases[id] = target
+			} else {
+				report.Corrupt = append(report.Corrupt, path)
 			}
+		} else if id, ok := store.parseTombstone(name); ok {
+			store.renameLegacy(path, id, tombstoneSuffix)
+			tombstones[id] = struct{}{}
+		} else if store.isElementTmp(name) {
+			tmpFiles = append(tmpFiles, path)
+		} else if !isHousekeeping(name) {
+			report.Ignored = append(report.Ignored, path)
+		}
+	}
+
+	walker := func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.IsDir() && info.Name() == segmentsDir {
+			return filepath.SkipDir
+		} else if err == nil && info.Mode()&os.ModeType == 0 {
+			visit(path, fileElementSize(path, info.Size()))
 		}
 
 		return nil
 	}
 
-	if err := filepath.Walk(workdir, walker); err != nil {
+	if err := store.loadNaming(); err != nil {
+		return nil, err
+	}
+
+	if err := store.loadTree(workdir, walker, visit); err != nil {
+		return nil, err
+	}
+
+	shards, err := store.openShards()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, dir := range shards {
+		if err := store.loadTree(dir, walker, visit); err != nil {
+			return nil, err
+		}
+	}
+
+	store.startIndexing(shards)
+
+	if !store.sharedReader {
+		if err := store.claimOwnership(); err != nil {
+			return nil, err
+		}
+	}
+
+	report.Incomplete = len(incomplete)
+	store.dropIncomplete(incomplete)
+	if err := store.openSegments(); err != nil {
+		return nil, err
+	}
+
+	if store.standby != "" {
+		if err := os.MkdirAll(store.standby, store.dirMode()); err != nil {
+			return nil, err
+		}
+
+		if err := filepath.Walk(store.standby, walker); err != nil {
+			return nil, err
+		}
+	}
+
+	if store.staging != "" {
+		if err := store.recoverStaged(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := store.recordNaming(); err != nil {
+		return nil, err
+	}
+
+	// left by writes interrupted by a crash. Other writers sharing the
+	// workdir may be writing theirs right now
+	report.Incomplete += len(tmpFiles)
+	if !store.sharedReader && !store.sharedWriter {
+		for _, path := range tmpFiles {
+			os.Remove(path)
+		}
+	}
+
+	report.Deletes = len(tombstones)
+	store.finishDeletes(tombstones)
+	if err := store.openTimeLog(&store.inserted, insertedFile); err != nil {
+		return nil, err
+	} else if err := store.loadAccess(); err != nil {
+		return nil, err
+	} else if err := store.loadExpiries(); err != nil {
+		return nil, err
+	}
+
+	if store.remote != nil {
+		store.mirrors = append(store.mirrors, store.remote)
+	}
+
+	if err := store.startMirrorRetry(); err != nil {
+		return nil, err
+	} else if err := store.loadRemote(); err != nil {
 		return nil, err
 	}
 
+	store.startWarmup()
+
+	report.Elements = len(store.onDisk)
+	report.Aliases = len(store.aliases)
+	report.Duration = time.Since(start)
 	return store, nil
 }
 
-// Returns when all writes are completed
+// Returns when all writes and deletes are completed
 func (c *ElementStore) Sync() error {
+	defer c.checkSlowOp("sync", 0, time.Now())
 	c.activeWrites.Wait()
+	c.deletes.Wait()
 	return nil
 }
 
@@ -144,25 +481,115 @@ func (c *ElementStore) SyncFor(timeout time.Duration) error {
 }
 
 // Remove the ElementStore from the file system permanently
+//
+// Returns a *RemoveError listing what's left if the store could only be
+// partially removed
 func (c *ElementStore) Remove() error {
 	if err := c.Sync(); err != nil {
 		return err
 	}
 
-	return os.RemoveAll(c.workdir)
+	c.shutdown()
+	c.unlockWorkdir()
+	if err := c.removeDirs(os.RemoveAll); err != nil {
+		return err
+	}
+
+	c.unregister()
+	return nil
+}
+
+// Latencies measured by SelfTest
+type SelfTestReport struct {
+	Write  time.Duration // writing the probe file and renaming it into place
+	Read   time.Duration
+	Delete time.Duration
+}
+
+// Performs a write-read-delete cycle on a probe file in the workdir to
+// verify that the file system behaves the way the store expects it to:
+// written data can be read back, rename replaces the source and removed
+// files are gone. The probe is written and read like an element, with the
+// durability, compression and encryption of the store. Returns how long
+// each step of the cycle took
+//
+// Returns an error wrapping ErrSelfTestFailed if the file system misbehaves
+func (c *ElementStore) SelfTest() (SelfTestReport, error) {
+	var report SelfTestReport
+	probe := filepath.Join(c.workdir, selfTestFile)
+	tmp := probe + tmpSuffix
+	// compressible, so that compression is exercised as well
+	stamp := strconv.FormatInt(time.Now().UnixNano(), 16) + " "
+	data := bytes.Repeat([]byte(stamp), 64)
+
+	defer os.Remove(tmp)
+	defer os.Remove(probe)
+
+	start := time.Now()
+	hdr, body := c.encryptElement(c.encodeElement(data))
+	err := c.retryStale(func() error {
+		_, err := writeData(probe, c.fileMode(), c.durability == SyncEveryWrite, hdr, body)
+		return err
+	})
+	if err != nil {
+		return report, err
+	}
+
+	report.Write = time.Since(start)
+	if _, err := os.Stat(tmp); !os.IsNotExist(err) {
+		return report, fmt.Errorf("%w: rename left source file behind", ErrSelfTestFailed)
+	}
+
+	start = time.Now()
+	var ret []byte
+	err = c.retryStale(func() error {
+		var err error
+		ret, err = readData(probe, nil)
+		return err
+	})
+	if err != nil {
+		return report, err
+	}
+
+	if ret, err = c.decryptElement(ret); err == nil {
+		ret, _, err = decodeElement(ret)
+	}
+
+	report.Read = time.Since(start)
+	if err != nil || !bytes.Equal(ret, data) {
+		return report, fmt.Errorf("%w: read back data differs from written data",
+			ErrSelfTestFailed)
+	}
+
+	start = time.Now()
+	if err := os.Remove(probe); err != nil {
+		return report, err
+	}
+
+	report.Delete = time.Since(start)
+	if _, err := os.Stat(probe); !os.IsNotExist(err) {
+		return report, fmt.Errorf("%w: removed file still exists", ErrSelfTestFailed)
+	}
+
+	return report, nil
 }
 
 // XXX: Assumes a storeMutex-lock is held
 func (c *ElementStore) has(id uint64) bool {
-	if _, ok := c.inMemIDMap[id]; ok {
+	// cached elements are always on disk as well
+	if _, ok := c.inTransfer[id]; ok {
 		return true
 	}
 
-	if _, ok := c.inTransfer[id]; ok {
+	if _, ok := c.onDisk[id]; ok {
 		return true
 	}
 
-	if _, ok := c.onDisk[id]; ok {
+	if _, ok := c.aliases[id]; ok {
+		return true
+	}
+
+	if _, ok := c.streaming[id]; ok {
 		return true
 	}
 
@@ -171,78 +598,381 @@ func (c *ElementStore) has(id uint64) bool {
 
 // Returns true if an ID exists in the store
 func (c *ElementStore) Has(id uint64) bool {
+	c.storeMutex.RLock()
+	has, expired := c.has(id), c.resolvedExpired(id)
+	c.storeMutex.RUnlock()
+
+	if expired {
+		return false
+	} else if !has && c.sharedReader {
+		return c.discoverCached(id)
+	}
+
+	return has
+}
+
+// suffix of the temporary file an element is written to before it's
+// renamed into place, so that a crash never leaves a truncated element
+// behind
+const tmpSuffix = ".tmp"
+
+// returns true for the temporary file of an element write
+func (c *ElementStore) isElementTmp(name string) bool {
+	_, ok := c.parseSuffixed(name, tmpSuffix)
+	return ok
+}
+
+// writes 'parts' to a file, synced before it's moved into place if
+// 'durable' is set. Returns the number of bytes written, which may be
+// non-zero on error
+func writeData(path string, mode os.FileMode, durable bool, parts ...[]byte) (int, error) {
+	tmp := path + tmpSuffix
+	f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return 0, err
+	}
+
+	var n int
+	for _, part := range parts {
+		var m int
+		m, err = f.Write(part)
+		n += m
+		if err != nil {
+			break
+		}
+	}
+
+	if err == nil && durable {
+		err = f.Sync()
+	}
+
+	if err != nil {
+		f.Close()
+	} else if err = f.Close(); err == nil {
+		err = os.Rename(tmp, path)
+	}
+
+	if err != nil {
+		os.Remove(tmp)
+	}
+
+	return n, err
+}
+
+func (c *ElementStore) writeFile(base string, elem []byte, id uint64) error {
+	dir := elDir(base, id)
+	err := c.retryStale(func() error { return os.MkdirAll(dir, c.dirMode()) })
+	if err != nil {
+		return err
+	}
+
+	path := c.elFile(base, id)
+	if c.sharedWriter {
+		if err := createMarker(path, c.fileMode(), c.nfsSafe); err != nil {
+			return err
+		}
+	}
+
+	hdr, body := c.encryptElement(c.encodeElement(elem))
+	err = c.retryStale(func() error {
+		n, err := writeData(path, c.fileMode(), c.durability == SyncEveryWrite, hdr, body)
+		atomic.AddUint64(&c.io.written, uint64(n))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := c.fileChanged(base, path, false); err != nil {
+		return err
+	}
+
+	if c.sharedWriter {
+		return removeMarker(path)
+	}
+
+	return nil
+}
+
+func (c *ElementStore) writeElement(elem []byte, id uint64) error {
+	if c.segments != nil {
+		return c.writeSegmented(elem, id)
+	}
+
+	if c.standby != "" {
+		return c.writeWithStandby(elem, id)
+	}
+
+	return c.writeFile(c.root(id), elem, id)
+}
+
+// Returns the IDs of all elements in the store, in ascending order
+func (c *ElementStore) IDs() []uint64 {
 	c.storeMutex.RLock()
 	defer c.storeMutex.RUnlock()
-	return c.has(id)
+
+	ids := make([]uint64, 0, len(c.onDisk)+len(c.inTransfer)+len(c.aliases))
+	for id := range c.onDisk {
+		ids = append(ids, id)
+	}
+
+	for id := range c.inTransfer {
+		if _, ok := c.onDisk[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+
+	for id := range c.aliases {
+		ids = append(ids, id)
+	}
+
+	ids = c.dropExpired(ids)
+	sortIDs(ids)
+	return ids
 }
 
-// NB: signals error by setting c.writeFailure
+// NB: signals error by setting c.writeFailure, or the error of the shard,
 //     to prevent future writes
-func (c *ElementStore) write(elem []byte, id uint64) {
+func (c *ElementStore) write(pw *pendingWrite, id uint64) {
+	start := time.Now()
 	defer func() {
 		c.storeMutex.Lock()
-		delete(c.inTransfer, id)
+		if c.inTransfer[id] == pw {
+			delete(c.inTransfer, id)
+		}
+
+		if c.cancelled[id] == pw {
+			delete(c.cancelled, id)
+		}
+
 		c.storeMutex.Unlock()
+		close(pw.done)
+		c.writeLatency.since(start)
+		c.checkSlowOp("write", id, start)
 		c.activeWrites.Done()
 	}()
 
-	dir := elDir(c.workdir, id)
-	if err := os.MkdirAll(dir, 0700); err != nil {
-		c.writeFailure = err
-		return
+	c.awaitPrevious(id, pw.prev, pw.unlinked)
+
+	// loops until the latest version of the element is written
+	for {
+		c.storeMutex.RLock()
+		cancelled, elem, version := pw.cancelled, pw.elem, pw.version
+		c.storeMutex.RUnlock()
+		if cancelled {
+			return
+		}
+
+		// refuse to write if another instance has taken over the workdir
+		err := c.checkOwnership()
+		if err != nil {
+			c.setWriteFailure(err)
+			c.recordFailedWrite(id, err)
+			return
+		}
+
+		if c.staging != "" {
+			err = c.writeStaged(pw, elem, version, id)
+			if err == errCancelled {
+				return
+			} else if err == errSuperseded {
+				continue
+			}
+		} else {
+			err = c.writeElement(elem, id)
+		}
+
+		c.breaker.record(err)
+		if err != nil && c.staging == "" {
+			c.elementWriteFailed(id, err)
+			c.recordFailedWrite(id, err)
+			return
+		} else if err != nil {
+			c.setWriteFailure(err)
+			c.recordFailedWrite(id, err)
+			return
+		}
+
+		c.inserted.set(id, pw.accepted)
+		if c.writeDone(pw, version, id, int64(len(elem))) {
+			return
+		}
 	}
+}
 
-	f, err := os.Create(elFile(c.workdir, id))
-	if err != nil {
-		c.writeFailure = err
-		return
+// records a finished element write. Returns false if the element was
+// superseded while being written and needs to be written again
+func (c *ElementStore) writeDone(pw *pendingWrite, version int, id uint64, size int64) bool {
+	c.storeMutex.Lock()
+	defer c.storeMutex.Unlock()
+	if pw.cancelled {
+		// cancelled while being written
+		c.removeElementFiles(id)
+		return true
 	}
 
-	defer f.Close()
-	_, err = f.Write(elem)
-	if err != nil {
-		c.writeFailure = err
-		return
+	if pw.version != version {
+		return false
 	}
 
-	var x struct{}
-	c.storeMutex.Lock()
-	c.onDisk[id] = x
-	c.storeMutex.Unlock()
+	// leave inTransfer under the same lock, or a CancelPut in between
+	// would report success for an element that stays on disk
+	if c.inTransfer[id] == pw {
+		delete(c.inTransfer, id)
+	}
+
+	c.onDisk[id] = size
+	return true
 }
 
-// Check to see if a write error has occurred
+// removes the files of an element from the workdir and standby
+func (c *ElementStore) removeElementFiles(id uint64) {
+	if c.segments != nil {
+		c.removeSegmented(id)
+	}
+
+	os.Remove(c.elFile(c.root(id), id))
+	if c.standby != "" {
+		os.Remove(c.elFile(c.standby, id))
+	}
+
+	c.inserted.forget(id)
+	c.expiries.forget(id)
+}
+
+// Check to see if a write error has occurred. See FailedWrites for the
+// elements that failed
 func (c *ElementStore) WriteError() error {
-	return c.writeFailure
+	failure, _ := c.writeFailure.Load().(writeFailure)
+	return failure.err
 }
 
 // Insert an element into the element store
 //
 // Returns ErrAlreadyExists if the ID is already in use
 func (c *ElementStore) Put(elem []byte, id uint64) error {
-	if c.writeFailure != nil {
-		return c.writeFailure
+	return c.PutCtx(context.Background(), elem, id)
+}
+
+// Like Put, passing the values of 'ctx', such as tracing metadata, on to
+// mirrors implementing ContextBackend. Mirror writes outlive the call, so
+// they are not bound by the cancellation or deadline of 'ctx'. Waiting for
+// room in the write queue of WithWriteConcurrency is, however
+func (c *ElementStore) PutCtx(ctx context.Context, elem []byte, id uint64) error {
+	return c.put(ctx, elem, id, time.Time{})
+}
+
+// inserts an element, expiring at 'expires' unless it's zero
+func (c *ElementStore) put(ctx context.Context, elem []byte, id uint64, expires time.Time) error {
+	defer c.putLatency.since(time.Now())
+	if c.IsReadOnly() {
+		return ErrReadOnly
+	}
+
+	if err := c.writeErr(id); err != nil {
+		return err
+	} else if c.synchronous {
+		return c.putSync(ctx, elem, id, expires)
+	}
+
+	if err := c.reserveWrite(ctx); err != nil {
+		return err
 	}
 
 	c.storeMutex.Lock()
 	defer c.storeMutex.Unlock()
 
-	if c.has(id) {
+	if c.isClosed() {
+		c.cancelWrite()
+		return ErrClosed
+	} else if c.has(id) {
+		c.cancelWrite()
 		return ErrAlreadyExists
 	}
 
-	c.inTransfer[id] = elem
+	if !expires.IsZero() {
+		c.setExpiry(id, expires)
+	}
+
+	pw := c.newPendingWrite(elem, id)
+	c.inTransfer[id] = pw
+	c.notifyID(id)
+	atomic.AddUint64(&c.io.accepted, uint64(len(elem)))
 	c.activeWrites.Add(1)
-	go c.write(elem, id)
+	c.scheduleWrite(pw, id)
+	c.mirror(ctx, elem, id)
+	c.checkCapacity()
+	return nil
+}
+
+// Like Put, but if the ID belongs to an element that has not reached disk
+// yet, its payload is replaced with 'elem' and the replacement is written
+// instead. Mirrors implementing PutSupersede are sent the replacement
+// through it. A mirror left with the original, e.g. because it had already
+// stored it, counts as a failed mirror write
+//
+// Returns ErrAlreadyExists if the element has already been written
+func (c *ElementStore) PutSupersede(elem []byte, id uint64) error {
+	if c.IsReadOnly() {
+		return ErrReadOnly
+	}
+
+	c.storeMutex.Lock()
+	pw, ok := c.inTransfer[id]
+	if !ok || c.isClosed() {
+		c.storeMutex.Unlock()
+		return c.Put(elem, id)
+	}
+
+	pw.elem = elem
+	pw.version++
+
+	// under the lock, so that Close waits for the mirror writes
+	c.mirrorSupersede(elem, id)
+	c.storeMutex.Unlock()
+	atomic.AddUint64(&c.io.accepted, uint64(len(elem)))
+	return nil
+}
+
+// Cancels a pending write, provided it hasn't reached disk yet. The
+// element is removed from the store as if it was never inserted. Writes to
+// mirrors are not cancelled, while remote storage deletes the element
+//
+// Returns ErrAlreadyExists if the element has already been written, and
+// ErrDoesNotExist if the ID is not recognized
+func (c *ElementStore) CancelPut(id uint64) error {
+	c.storeMutex.Lock()
+	defer c.storeMutex.Unlock()
+
+	pw, ok := c.inTransfer[id]
+	if !ok {
+		if c.has(id) {
+			return ErrAlreadyExists
+		}
+
+		return ErrDoesNotExist
+	}
+
+	pw.cancelled = true
+	delete(c.inTransfer, id)
+	c.cancelled[id] = pw
+	c.deleteRemote(id)
 	return nil
 }
 
-func (c *ElementStore) read(id uint64) ([]byte, error) {
-	f, err := os.Open(elFile(c.workdir, id))
+func readData(path string, opened chan<- *os.File) ([]byte, error) {
+	f, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
 
+	if opened != nil {
+		select {
+		case opened <- f:
+		default:
+		}
+	}
+
 	defer f.Close()
 	var ret []byte
 	if ret, err = ioutil.ReadAll(f); err != nil {
@@ -252,58 +982,249 @@ func (c *ElementStore) read(id uint64) ([]byte, error) {
 	return ret, nil
 }
 
-func (c *ElementStore) incrReadCounter(id uint64) {
-	c.storeMutex.Lock()
-	defer c.storeMutex.Unlock()
+func (c *ElementStore) readFile(base string, id uint64, opened chan<- *os.File) ([]byte, error) {
+	var ret []byte
+	err := c.retryStale(func() error {
+		var err error
+		ret, err = readData(c.elFile(base, id), opened)
+		atomic.AddUint64(&c.io.read, uint64(len(ret)))
+		return err
+	})
 
-	val, ok := c.readCounters[id]
-	if ok {
-		val += 1
-		if val == 0 { //overflow check
-			val -= 1
+	if err != nil {
+		return nil, err
+	}
+
+	if ret, err = c.decryptElement(ret); err != nil {
+		return nil, err
+	}
+
+	ret, _, err = decodeElement(ret)
+	return ret, err
+}
+
+func (c *ElementStore) readElement(id uint64, opened chan<- *os.File) ([]byte, error) {
+	if c.segments != nil {
+		el, err := c.readSegmented(id)
+		if !os.IsNotExist(err) {
+			return el, err
+		}
+	}
+
+	if c.isStaged(id) {
+		el, err := c.readFile(c.staging, id, opened)
+		if !os.IsNotExist(err) {
+			return el, err
 		}
+
+		// committed to the workdir while we were reading
+	}
+
+	if c.standby != "" {
+		return c.readWithStandby(id, opened)
+	}
+
+	return c.readFile(c.root(id), id, opened)
+}
+
+func (c *ElementStore) read(ctx context.Context, id uint64) ([]byte, error) {
+	if !c.breaker.allow() {
+		return nil, ErrUnavailable
+	}
+
+	el, err := c.readTimed(ctx, id)
+	if os.IsNotExist(err) && c.remote != nil {
+		c.breaker.record(nil)
+		return c.readRemote(ctx, id, err)
+	} else if err != nil && err == ctx.Err() {
+		// an abandoned read says nothing about the disk
+		c.breaker.abandon()
 	} else {
-		val = 1
+		c.breaker.record(err)
 	}
 
-	c.readCounters[id] = val
+	return el, err
 }
 
-func (c *ElementStore) maybeCacheElement(el []byte, id uint64) {
+func (c *ElementStore) readTimed(ctx context.Context, id uint64) ([]byte, error) {
+	defer c.checkSlowOpCtx(ctx, "read", id, time.Now())
+	timeout := c.loadReadTimeout()
+	if timeout <= 0 && ctx.Done() == nil {
+		return c.readElement(id, nil)
+	}
+
+	type result struct {
+		el  []byte
+		err error
+	}
+
+	// the read is done in a separate goroutine and opened files are handed
+	// over to us, so that a hung read can be aborted by closing the file.
+	// With a standby, up to two files are opened
+	opened := make(chan *os.File, 2)
+	done := make(chan result, 1)
+	go func() {
+		el, err := c.readElement(id, opened)
+		done <- result{el, err}
+	}()
+
+	abort := func() {
+		for {
+			select {
+			case f := <-opened:
+				f.Close()
+			default:
+				return
+			}
+		}
+	}
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case res := <-done:
+		if res.err == nil {
+			c.setDegraded(false)
+		}
+
+		return res.el, res.err
+	case <-expired:
+		abort()
+		c.setDegraded(true)
+		return nil, ErrReadTimeout
+	case <-ctx.Done():
+		abort()
+		return nil, ctx.Err()
+	}
+}
+
+// read counters are only tracked while caching, so with caching disabled
+// the store is a pass-through to disk
+func (c *ElementStore) cacheEnabled() bool {
+	return c.cacheSize() > 0 && atomic.LoadInt32(&c.cacheOff) == 0
+}
+
+// Disables the in-memory cache at runtime, dropping all cached elements and
+// read counters. Elements are still served from memory while in transfer
+// to disk
+func (c *ElementStore) DisableCache() {
+	c.storeMutex.Lock()
+	defer c.storeMutex.Unlock()
+
+	atomic.StoreInt32(&c.cacheOff, 1)
+	c.dropCache()
+}
 
-	if c.maxInMem < 1 {
+// XXX: Assumes a storeMutex-lock is held
+func (c *ElementStore) dropCache() {
+	c.inMem.Reset()
+	c.inMemIDMap.Range(func(id, _ interface{}) bool {
+		c.inMemIDMap.Delete(id)
+		return true
+	})
+
+	c.cacheBytes = 0
+
+	c.readCounters = make(map[uint64]uint64)
+	c.dropReadCounts()
+}
+
+// Enables the in-memory cache after DisableCache. The cache starts out
+// empty
+func (c *ElementStore) EnableCache() {
+	atomic.StoreInt32(&c.cacheOff, 0)
+}
+
+func (c *ElementStore) maybeCacheElement(el []byte, id uint64, gen uint64) {
+
+	if !c.cacheEnabled() {
 		return
 	}
 
 	c.storeMutex.Lock()
 	defer c.storeMutex.Unlock()
 
-	newElem := &cacheElement{
-		Element:     el,
-		ID:          id,
-		accessCount: c.readCounters[id]}
+	// the cache may have been disabled while we waited for the lock, and
+	// the element deleted since it was read
+	if !c.cacheEnabled() || gen != c.cacheGen {
+		return
+	}
 
-	// always cache if cache is not full
-	if len(c.inMem) < c.maxInMem {
-		c.inMem = append(c.inMem, newElem)
-		c.inMemIDMap[id] = el
+	// the same element may have been queued more than once
+	if c.inMem.Has(id) {
 		return
 	}
 
-	// prepare c.inMem for sorting
-	for _, inMemEl := range c.inMem {
-		inMemEl.accessCount = c.readCounters[inMemEl.ID]
+	// the eviction decision is based on read counts up until now
+	c.mergeReadCounts()
+	count := c.readCounters[id]
+
+	// elements larger than the whole cache are never cached
+	if limit := c.cacheByteLimit(); limit > 0 && int64(len(el)) > limit {
+		return
+	}
+
+	// always cache if cache is not full
+	if !c.cacheFits(len(el)) {
+		if c.inMem.Len() == 0 || !c.inMem.Admit(id, count) {
+			return
+		}
+
+		// a large element may need room made by more than one eviction
+		for c.inMem.Len() > 0 && !c.cacheFits(len(el)) {
+			c.evictCached()
+		}
+	}
+
+	c.inMem.Add(id, count)
+	c.inMemIDMap.Store(id, el)
+	c.cacheBytes += int64(len(el))
+}
+
+// returns true if an element of size 'n' can be added to the cache without
+// exceeding its size in elements or bytes
+//
+// XXX: Assumes a storeMutex-lock is held
+func (c *ElementStore) cacheFits(n int) bool {
+	limit := c.cacheByteLimit()
+	return c.inMem.Len() < c.cacheSize() &&
+		(limit == 0 || c.cacheBytes+int64(n) <= limit)
+}
+
+// XXX: Assumes a storeMutex-lock is held
+func (c *ElementStore) evictCached() {
+	c.uncache(c.inMem.Evict())
+}
+
+// removes an element from the cache map, which the policy no longer tracks
+//
+// XXX: Assumes a storeMutex-lock is held
+func (c *ElementStore) uncache(id uint64) {
+	if el, ok := c.inMemIDMap.Load(id); ok {
+		c.cacheBytes -= int64(len(el.([]byte)))
+		c.inMemIDMap.Delete(id)
 	}
+}
 
-	// sort cache so that higher read count is to the left
-	sort.Sort(sort.Reverse(c.inMem))
+// evicts elements until the cache fits its size
+//
+// XXX: Assumes a storeMutex-lock is held
+func (c *ElementStore) shrinkCache() {
+	size := c.cacheSize()
+	if size == 0 {
+		c.dropCache()
+		return
+	}
 
-	lastIx := len(c.inMem) - 1
-	lowestEl := c.inMem[lastIx]
-	if lowestEl.accessCount < newElem.accessCount {
-		c.inMem[lastIx] = newElem
-		delete(c.inMemIDMap, lowestEl.ID)
-		c.inMemIDMap[newElem.ID] = newElem.Element
+	c.mergeReadCounts()
+	limit := c.cacheByteLimit()
+	for c.inMem.Len() > size || (limit > 0 && c.cacheBytes > limit) {
+		c.evictCached()
 	}
 }
 
@@ -311,32 +1232,214 @@ func (c *ElementStore) maybeCacheElement(el []byte, id uint64) {
 //
 // returns ErrDoesNotExist if the ID is not recognized
 func (c *ElementStore) Get(id uint64) ([]byte, error) {
+	return c.GetCtx(context.Background(), id)
+}
+
+// Like Get, but a disk read is abandoned when 'ctx' is done, returning
+// ctx.Err(). 'ctx' is passed on to the slow operation handler
+func (c *ElementStore) GetCtx(ctx context.Context, id uint64) ([]byte, error) {
+	return c.get(ctx, id, GetOpts{})
+}
+
+// Priority of a disk read, see GetOpts
+type Priority int
+
+const (
+	// Read right away, like Get
+	Foreground Priority = iota
+
+	// Read within the limits of WithBackgroundIOLimit, shared with the
+	// background work of the store. Meant for scans and exports that
+	// shouldn't compete with foreground reads
+	Background
+)
+
+// Per-call options for GetWith. The zero value behaves like Get
+type GetOpts struct {
+	// Read the element from disk even if it's cached, and don't consider
+	// it for caching. Meant for scans that would otherwise evict hot
+	// elements
+	NoCache bool
+
+	// Wait for an element in transfer to reach disk before returning it
+	RequireDurable bool
+
+	// Abandon disk reads and waits at this time, returning
+	// context.DeadlineExceeded
+	Deadline time.Time
+
+	// Priority of a read from disk. Cache hits are never throttled
+	Priority Priority
+}
+
+// Get an element from the element store, with per-call options
+//
+// returns ErrDoesNotExist if the ID is not recognized
+func (c *ElementStore) GetWith(id uint64, opts GetOpts) ([]byte, error) {
+	ctx := context.Background()
+	if !opts.Deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, opts.Deadline)
+		defer cancel()
+	}
+
+	return c.get(ctx, id, opts)
+}
+
+func (c *ElementStore) get(ctx context.Context, id uint64, opts GetOpts) ([]byte, error) {
+	start := time.Now()
+	if c.isClosed() {
+		return nil, ErrClosed
+	} else if c.expired(id) {
+		return nil, ErrDoesNotExist
+	}
+
+	// cache hits don't touch storeMutex
+	if !opts.NoCache && c.memTier != nil {
+		if el, ok := c.memTier.Get(id); ok {
+			c.countRead(id)
+			c.getHitLatency.since(start)
+			return el, nil
+		}
+	} else if !opts.NoCache {
+		if el, ok := c.inMemIDMap.Load(id); ok {
+			c.countRead(id)
+			c.getHitLatency.since(start)
+			return el.([]byte), nil
+		}
+	}
 
 	c.storeMutex.RLock()
-	if el, ok := c.inMemIDMap[id]; ok {
+	if pw, ok := c.inTransfer[id]; ok {
+		// PutSupersede replaces the payload under storeMutex
+		el := pw.elem
 		c.storeMutex.RUnlock()
-		c.incrReadCounter(id)
-		return el, nil
-	} else if el, ok := c.inTransfer[id]; ok {
-		c.storeMutex.RUnlock()
-		c.incrReadCounter(id)
-		return el, nil
-	} else if _, ok := c.onDisk[id]; ok {
-		c.storeMutex.RUnlock()
-		// It's key that we don't hold a lock at this point
-		el, err := c.read(id)
-		if err != nil {
-			return nil, err
+		if opts.RequireDurable {
+			select {
+			case <-pw.done:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+
+			// written, cancelled or superseded by another write by now
+			return c.get(ctx, id, opts)
 		}
 
-		// important to increment the read counter  *before* caching
-		// to ensure that the ID exists in the access counter map
-		c.incrReadCounter(id)
+		if !opts.NoCache {
+			c.countRead(id)
+		}
 
-		c.maybeCacheElement(el, id)
+		c.getHitLatency.since(start)
 		return el, nil
+	} else if _, ok := c.onDisk[id]; ok {
+		gen := c.cacheGen
+		c.storeMutex.RUnlock()
+		return c.getFromDisk(ctx, id, start, opts, gen)
+	} else if target, ok := c.aliases[id]; ok {
+		// targets are never aliases themselves
+		c.storeMutex.RUnlock()
+		return c.get(ctx, target, opts)
 	}
 
+	gen := c.cacheGen
 	c.storeMutex.RUnlock()
+	if c.sharedReader && c.discoverCached(id) {
+		return c.getFromDisk(ctx, id, start, opts, gen)
+	}
+
 	return nil, ErrDoesNotExist
 }
+
+// 'gen' is cacheGen from before the element was known to be on disk
+func (c *ElementStore) getFromDisk(ctx context.Context, id uint64, start time.Time, opts GetOpts,
+	gen uint64) ([]byte, error) {
+	// It's key that we don't hold a lock at this point
+	el, err := c.read(ctx, id)
+	if err != nil {
+		c.recordReadError(err)
+		return nil, err
+	}
+
+	// important to count the read *before* caching, so that it's part of
+	// the admission decision
+	if !opts.NoCache {
+		c.countRead(id)
+		c.admit(el, id, gen)
+	}
+
+	c.getDiskLatency.since(start)
+	if opts.Priority == Background {
+		c.ioThrottle.wait(len(el), 1)
+	}
+
+	return el, nil
+}
+
+// stops background goroutines
+func (c *ElementStore) shutdown() {
+	c.quitOnce.Do(func() {
+		close(c.quit)
+		if c.segments != nil {
+			c.segments.close()
+		}
+
+		c.inserted.close()
+		c.expiries.close()
+		if c.accessTracking && !c.sharedReader {
+			c.flushAccess()
+		}
+	})
+}
+
+// returns true once the store has been shut down
+func (c *ElementStore) isShutdown() bool {
+	select {
+	case <-c.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+// queues an element for the cache admission goroutine, keeping cache
+// maintenance off the read path
+func (c *ElementStore) admit(el []byte, id uint64, gen uint64) {
+	if c.memTier != nil {
+		c.setMemTier(el, id, gen)
+		return
+	} else if !c.cacheEnabled() {
+		return
+	}
+
+	if c.isShutdown() {
+		return
+	}
+
+	c.admitterStart.Do(func() {
+		go c.admitter()
+	})
+
+	c.admitWg.Add(1)
+	select {
+	case c.admissions <- admission{id, el, gen}:
+	default:
+		c.admitWg.Done()
+	}
+}
+
+func (c *ElementStore) admitter() {
+	for {
+		select {
+		case a := <-c.admissions:
+			c.maybeCacheElement(a.el, a.id, a.gen)
+			c.admitWg.Done()
+		case <-c.quit:
+			return
+		}
+	}
+}
+
+// waits for queued admission candidates to be handled
+func (c *ElementStore) waitAdmissions() {
+	c.admitWg.Wait()
+}
@@ -2,12 +2,28 @@ package elstore
 
 import (
 	"bytes"
+	"io/ioutil"
+	"math"
+	"math/rand"
 	"os"
+	"path/filepath"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"testing"
 )
 
 var testDir = "n0n3x1s73n7d1r"
+
+// paths of the element and alias files of a store with the default naming
+func elFile(base string, id uint64) string {
+	return filepath.Join(elDir(base, id), strconv.FormatUint(id, 16))
+}
+
+func aliasFile(base string, id uint64) string {
+	return elFile(base, id) + aliasSuffix
+}
+
 var testData = []byte(`
 AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA
 BBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBB
@@ -101,6 +117,7 @@ func TestPersistentInsertRetrieve(t *testing.T) {
 		}
 
 		c.Sync()
+		c.Close()
 		c, err = NewElementStore(cacheSize, testDir)
 		if err != nil {
 			t.Fatal("Unable to create element store", err)
@@ -115,6 +132,7 @@ func TestPersistentInsertRetrieve(t *testing.T) {
 			t.Fatal("expected", testEl, "got", ret)
 		}
 
+		c.Remove()
 	}
 }
 
@@ -322,3 +340,466 @@ func BenchmarkDiskReads(b *testing.B) {
 		}
 	}
 }
+
+// Runs a parallel workload where 'writeRatio' of the operations are Puts of
+// new elements of 'size' bytes and the rest are Gets, of which 'hitRatio'
+// are for cached elements
+func benchmarkParallelMixed(b *testing.B, size int, writeRatio, hitRatio float64) {
+	const hot, cold = 64, 1024
+	c, err := NewElementStore(hot, testDir)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	defer c.Remove()
+	data := bytes.Repeat([]byte{'A'}, size)
+	for id := uint64(0); id < hot+cold; id++ {
+		if err := c.Put(data, id); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	c.Sync()
+	for id := uint64(0); id < hot; id++ {
+		c.Get(id)
+		c.Get(id)
+	}
+
+	c.waitAdmissions()
+
+	nextID := uint64(hot + cold)
+	var seed int64
+	b.SetBytes(int64(size))
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		r := rand.New(rand.NewSource(atomic.AddInt64(&seed, 1)))
+		for pb.Next() {
+			if r.Float64() < writeRatio {
+				id := atomic.AddUint64(&nextID, 1)
+				if err := c.Put(data, id); err != nil {
+					b.Error(err)
+					return
+				}
+
+				continue
+			}
+
+			id := uint64(r.Intn(hot))
+			if r.Float64() >= hitRatio {
+				id = uint64(hot + r.Intn(cold))
+			}
+
+			if _, err := c.Get(id); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+
+	b.StopTimer()
+	c.Sync()
+}
+
+func BenchmarkParallelReadsHit100(b *testing.B) {
+	benchmarkParallelMixed(b, len(testData2), 0, 1)
+}
+
+func BenchmarkParallelReadsHit50(b *testing.B) {
+	benchmarkParallelMixed(b, len(testData2), 0, 0.5)
+}
+
+func BenchmarkParallelMixedSmallData(b *testing.B) {
+	benchmarkParallelMixed(b, len(testData2), 0.1, 0.9)
+}
+
+func BenchmarkParallelMixedMediumData(b *testing.B) {
+	benchmarkParallelMixed(b, len(testData), 0.1, 0.9)
+}
+
+func BenchmarkParallelMixedLargeData(b *testing.B) {
+	benchmarkParallelMixed(b, 64<<10, 0.1, 0.9)
+}
+
+func BenchmarkParallelWriteHeavy(b *testing.B) {
+	benchmarkParallelMixed(b, len(testData2), 0.5, 0.9)
+}
+
+func TestSelfTest(t *testing.T) {
+	// the probe is written like an element, whatever the settings
+	for _, opts := range [][]Option{
+		nil,
+		{WithCompression(Gzip), WithEncryption(make([]byte, 32)),
+			WithDurability(SyncEveryWrite)},
+	} {
+		c, err := NewElementStore(0, testDir, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// fast file systems may take less than the clock resolution
+		report, err := c.SelfTest()
+		if err != nil {
+			t.Fatal(err)
+		} else if report.Write < 0 || report.Read < 0 || report.Delete < 0 {
+			t.Fatal("latencies not measured", report)
+		}
+
+		if c.Has(0) {
+			t.Fatal("self-test probe visible as element")
+		}
+
+		if err := c.Remove(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCancelPut(t *testing.T) {
+	c, err := NewElementStore(0, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// removes the store in use when the test ends, as it's reopened below
+	defer func() { c.Remove() }()
+	if err := c.CancelPut(1); err != ErrDoesNotExist {
+		t.Fatal("expected ErrDoesNotExist, got", err)
+	}
+
+	for i := 0; i < 100; i++ {
+		if err := c.Put(testData, 1); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := c.CancelPut(1); err == ErrAlreadyExists {
+			// the write won the race; start over
+			c.Sync()
+			if err := c.Remove(); err != nil {
+				t.Fatal(err)
+			}
+
+			reopened, err := NewElementStore(0, testDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			c = reopened
+			continue
+		} else if err != nil {
+			t.Fatal(err)
+		}
+
+		if c.Has(1) {
+			t.Fatal("cancelled element still in store")
+		}
+	}
+
+	// a put after cancellation replaces the cancelled element
+	if err := c.Put(testData2, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Sync()
+	c.Close()
+	reopened, err := NewElementStore(0, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c = reopened
+	data, err := c.Get(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Compare(testData2, data) != 0 {
+		t.Fatalf("expected\n%v\n\ngot\n%v\n\n", testData2, data)
+	}
+
+	if err := c.CancelPut(1); err != ErrAlreadyExists {
+		t.Fatal("expected ErrAlreadyExists, got", err)
+	}
+}
+
+func TestPutSupersede(t *testing.T) {
+	c, err := NewElementStore(0, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	if err := c.PutSupersede(testData, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.PutSupersede(testData2, 1); err != nil && err != ErrAlreadyExists {
+		t.Fatal(err)
+	}
+
+	superseded := err == nil
+	c.Sync()
+	c.Close()
+	c, err = NewElementStore(0, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	expected := testData
+	if superseded {
+		expected = testData2
+	}
+
+	data, err := c.Get(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Compare(expected, data) != 0 {
+		t.Fatalf("expected\n%v\n\ngot\n%v\n\n", expected, data)
+	}
+
+	if err := c.PutSupersede(testData, 1); err != ErrAlreadyExists {
+		t.Fatal("expected ErrAlreadyExists, got", err)
+	}
+}
+
+// run with -race: Get returns the payload of an element in transfer while
+// PutSupersede replaces it. A single writer keeps elements in transfer
+func TestPutSupersedeConcurrentGet(t *testing.T) {
+	c, err := NewElementStore(0, testDir, WithWriteConcurrency(1, 100, true),
+		WithDurability(SyncEveryWrite))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	for id := uint64(0); id < 100; id++ {
+		if err := c.Put(testData, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for id := uint64(0); id < 100; id++ {
+		done := make(chan struct{})
+		go func() {
+			for i := 0; i < 10; i++ {
+				c.PutSupersede(testData2, id)
+			}
+
+			close(done)
+		}()
+
+		for i := 0; i < 10; i++ {
+			data, err := c.Get(id)
+			if err != nil {
+				t.Fatal(err)
+			} else if !bytes.Equal(data, testData) && !bytes.Equal(data, testData2) {
+				t.Fatal("unexpected payload", data)
+			}
+		}
+
+		<-done
+	}
+}
+
+func TestGetWith(t *testing.T) {
+	c, err := NewElementStore(1, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	if err := c.Put(testData, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := c.GetWith(1, GetOpts{RequireDurable: true})
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(data, testData) {
+		t.Fatalf("expected\n%v\n\ngot\n%v\n\n", testData, data)
+	}
+
+	c.storeMutex.RLock()
+	_, onDisk := c.onDisk[1]
+	c.storeMutex.RUnlock()
+	if !onDisk {
+		t.Fatal("durable read returned before the element reached disk")
+	}
+
+	c.Get(1)
+	c.waitAdmissions()
+
+	// changed behind the store's back, only visible when bypassing the cache
+	if err := os.WriteFile(elFile(testDir, 1), testData2, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if data, _ := c.GetWith(1, GetOpts{NoCache: true}); !bytes.Equal(data, testData2) {
+		t.Fatalf("expected\n%v\n\ngot\n%v\n\n", testData2, data)
+	}
+
+	if data, _ := c.Get(1); !bytes.Equal(data, testData) {
+		t.Fatalf("expected\n%v\n\ngot\n%v\n\n", testData, data)
+	}
+}
+
+func TestCacheToggle(t *testing.T) {
+	c, err := NewElementStore(0, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	c.Put(testData2, 1)
+	c.Sync()
+	c.Get(1)
+	if len(c.readCounters) != 0 {
+		t.Fatal("read counters tracked without a cache")
+	}
+
+	c.Close()
+	c, err = NewElementStore(1, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	c.Get(1)
+	c.waitAdmissions()
+	if c.inMem.Len() != 1 {
+		t.Fatal("element not cached")
+	}
+
+	c.DisableCache()
+	c.Get(1)
+	if c.inMem.Len() != 0 || len(c.readCounters) != 0 {
+		t.Fatal("cache in use while disabled")
+	}
+
+	c.EnableCache()
+	c.Get(1)
+	c.waitAdmissions()
+	if c.inMem.Len() != 1 {
+		t.Fatal("element not cached after enabling cache")
+	}
+}
+
+func TestConcurrentReadCounting(t *testing.T) {
+	c, err := NewElementStore(1, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	c.Put(testData2, 1)
+	c.Sync()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				c.Get(1)
+			}
+		}()
+	}
+
+	wg.Wait()
+	c.storeMutex.Lock()
+	defer c.storeMutex.Unlock()
+	c.mergeReadCounts()
+	if n := c.readCounters[1]; n != 8000 {
+		t.Fatal("expected 8000 reads, got", n)
+	}
+
+	c.readCounters[1] = math.MaxUint64 - 1
+	c.storeMutex.Unlock()
+	c.countRead(1)
+	c.countRead(1)
+	c.storeMutex.Lock()
+	c.mergeReadCounts()
+	if n := c.readCounters[1]; n != math.MaxUint64 {
+		t.Fatal("read counter overflowed")
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	c, err := NewElementStore(2, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	for id := uint64(1); id <= 3; id++ {
+		c.Put(testData2, id)
+	}
+
+	c.Sync()
+
+	// 1 and 2 fill the cache, 3 is read more than 2 and replaces it
+	reads := map[uint64]int{1: 3, 2: 1, 3: 2}
+	for _, id := range []uint64{1, 2} {
+		for i := 0; i < reads[id]; i++ {
+			c.Get(id)
+		}
+
+		c.waitAdmissions()
+	}
+
+	// reads of cached elements don't cause admissions; read 3 from disk
+	for i := 0; i < reads[3]; i++ {
+		c.Get(3)
+		c.waitAdmissions()
+	}
+
+	cached := func(id uint64) bool {
+		_, ok := c.inMemIDMap.Load(id)
+		return ok
+	}
+
+	if !cached(1) || cached(2) || !cached(3) || c.inMem.Len() != 2 {
+		t.Fatal("unexpected cache contents", cached(1), cached(2), cached(3))
+	}
+}
+
+func TestInterruptedWrite(t *testing.T) {
+	c, err := NewElementStore(0, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Put(testData2, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Sync()
+	if _, err := os.Stat(elFile(testDir, 1) + tmpSuffix); !os.IsNotExist(err) {
+		t.Fatal("temporary file left after write", err)
+	}
+
+	c.Close()
+
+	// a crash while writing 2 leaves a truncated temporary file
+	tmp := elFile(testDir, 2) + tmpSuffix
+	os.MkdirAll(elDir(testDir, 2), 0700)
+	if err := ioutil.WriteFile(tmp, testData2[:1], 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err = NewElementStore(0, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	if c.Has(2) || !c.Has(1) {
+		t.Fatal("unexpected elements", c.IDs())
+	} else if _, err := os.Stat(tmp); !os.IsNotExist(err) {
+		t.Fatal("temporary file not removed", err)
+	} else if r := c.OpenReport(); r.Incomplete != 1 {
+		t.Fatal("expected 1 incomplete element, got", r.Incomplete)
+	}
+}
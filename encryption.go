@@ -0,0 +1,255 @@
+package elstore
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+var ErrEncrypted = errors.New("Element is encrypted with an unknown key")
+
+// Encrypted element files start with a magic string, the size of the
+// element, big endian, and a nonce, followed by the contents of the file
+// as it would be written unencrypted, sealed with AES-GCM. The magic string
+// and the size are authenticated along with the contents
+const encryptedMagic = "\x89ELE\r\n\x1a\n"
+const encryptedSizeEnd = len(encryptedMagic) + 8
+
+type keyring struct {
+	sync.RWMutex
+	aeads []cipher.AEAD // the first one encrypts, all of them decrypt
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// Encrypts elements with AES-GCM before writing them to disk, and decrypts
+// them on read. 'key' must be 16, 24 or 32 bytes long, selecting AES-128,
+// AES-192 or AES-256. Elements written without encryption stay readable,
+// and can be encrypted with Rekey
+//
+// Elements are only encrypted at rest: mirrors, frozen stores and the
+// cache get them in the clear
+func WithEncryption(key []byte) Option {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return Option{err: fmt.Errorf("%w: %v", ErrInvalidOption, err)}
+	}
+
+	return option(func(c *ElementStore) {
+		c.keys.aeads = []cipher.AEAD{aead}
+	})
+}
+
+// returns the header and body of an element file, encrypted if a key is set
+func (c *ElementStore) encryptElement(hdr, body []byte) ([]byte, []byte) {
+	c.keys.RLock()
+	defer c.keys.RUnlock()
+	if len(c.keys.aeads) == 0 || c.keys.aeads[0] == nil {
+		return hdr, body
+	}
+
+	aead := c.keys.aeads[0]
+	encHdr := make([]byte, encryptedSizeEnd+aead.NonceSize())
+	copy(encHdr, encryptedMagic)
+	size := elementSize(hdr, int64(len(hdr)+len(body)))
+	binary.BigEndian.PutUint64(encHdr[len(encryptedMagic):], uint64(size))
+	if _, err := rand.Read(encHdr[encryptedSizeEnd:]); err != nil {
+		panic(err)
+	}
+
+	plain := make([]byte, 0, len(hdr)+len(body))
+	plain = append(append(plain, hdr...), body...)
+	return encHdr, aead.Seal(nil, encHdr[encryptedSizeEnd:], plain,
+		encHdr[:encryptedSizeEnd])
+}
+
+// returns the contents of an element file as it would have been written
+// unencrypted. Returns ErrEncrypted if none of the keys decrypts it
+func (c *ElementStore) decryptElement(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, []byte(encryptedMagic)) {
+		return data, nil
+	}
+
+	c.keys.RLock()
+	defer c.keys.RUnlock()
+	for _, aead := range c.keys.aeads {
+		if aead == nil || len(data) < encryptedSizeEnd+aead.NonceSize() {
+			continue
+		}
+
+		nonce := data[encryptedSizeEnd : encryptedSizeEnd+aead.NonceSize()]
+		sealed := data[encryptedSizeEnd+len(nonce):]
+		if plain, err := aead.Open(nil, nonce, sealed, data[:encryptedSizeEnd]); err == nil {
+			return plain, nil
+		}
+	}
+
+	return nil, ErrEncrypted
+}
+
+// Rewrites the files of all elements encrypted with 'oldKey' to be
+// encrypted with 'newKey', and uses 'newKey' for elements written from
+// then on. A nil 'oldKey' encrypts elements written without encryption,
+// and a nil 'newKey' decrypts the store. Pending writes are synced first
+//
+// The store stays usable while it's rekeyed, as each element is only
+// locked while its own files are rewritten. If rekeying fails, both keys
+// stay in use for reading, and Rekey can be called again to finish the job
+func (c *ElementStore) Rekey(oldKey, newKey []byte) error {
+	if c.IsReadOnly() {
+		return ErrReadOnly
+	}
+
+	var oldCipher, newCipher cipher.AEAD
+	var err error
+	if oldKey != nil {
+		if oldCipher, err = newAEAD(oldKey); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidOption, err)
+		}
+	}
+
+	if newKey != nil {
+		if newCipher, err = newAEAD(newKey); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidOption, err)
+		}
+	}
+
+	if err := c.Sync(); err != nil {
+		return err
+	}
+
+	c.keys.Lock()
+	c.keys.aeads = []cipher.AEAD{newCipher, oldCipher}
+	c.keys.Unlock()
+
+	// elements written from here on use the new key, so only the ones
+	// already on disk are rewritten
+	c.storeMutex.RLock()
+	ids := make([]uint64, 0, len(c.onDisk))
+	for id := range c.onDisk {
+		ids = append(ids, id)
+	}
+
+	c.storeMutex.RUnlock()
+	for _, id := range ids {
+		if err := c.rekeyElement(id, oldCipher != nil); err != nil {
+			return err
+		}
+	}
+
+	c.keys.Lock()
+	c.keys.aeads = c.keys.aeads[:1]
+	c.keys.Unlock()
+	return nil
+}
+
+// rewrites the files of an element with the current key, if they're
+// encrypted as told by 'encrypted'. Elements deleted since Rekey started
+// are skipped, as are elements in transfer, which are written with the
+// current key
+func (c *ElementStore) rekeyElement(id uint64, encrypted bool) error {
+	c.storeMutex.RLock()
+	defer c.storeMutex.RUnlock()
+	if _, ok := c.onDisk[id]; !ok {
+		return nil
+	} else if _, ok := c.deleting[id]; ok {
+		return nil
+	} else if _, ok := c.inTransfer[id]; ok {
+		return nil
+	}
+
+	if c.segments != nil {
+		if err := c.rekeySegment(id, encrypted); err != nil {
+			return err
+		}
+	}
+
+	bases := []string{c.root(id), c.standby}
+	if _, ok := c.staged[id]; ok {
+		bases = append(bases, c.staging)
+	}
+
+	for _, base := range bases {
+		if base == "" {
+			continue
+		}
+
+		if err := c.rekeyFile(base, c.elFile(base, id), encrypted); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rewrites the segment record of an element with the current key, if it's
+// encrypted as told by 'encrypted'
+func (c *ElementStore) rekeySegment(id uint64, encrypted bool) error {
+	data, err := c.segments.read(id)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if bytes.HasPrefix(data, []byte(encryptedMagic)) != encrypted {
+		return nil
+	}
+
+	c.ioThrottle.wait(2*len(data), 2)
+	if data, err = c.decryptElement(data); err != nil {
+		return err
+	}
+
+	hdr, body := c.encryptElement(data, nil)
+	return c.writeSegment(id, append(hdr, body...))
+}
+
+// rewrites an element file with the current key, if it's encrypted as
+// told by 'encrypted'
+func (c *ElementStore) rekeyFile(base, path string, encrypted bool) error {
+	data, err := readData(path, nil)
+	if os.IsNotExist(err) {
+		// written while failed over
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if bytes.HasPrefix(data, []byte(encryptedMagic)) != encrypted {
+		return nil
+	}
+
+	// read and rewritten
+	c.ioThrottle.wait(2*len(data), 2)
+
+	if data, err = c.decryptElement(data); err != nil {
+		return err
+	}
+
+	hdr, body := c.encryptElement(data, nil)
+	err = c.retryStale(func() error {
+		n, err := writeData(path, c.fileMode(), c.durability == SyncEveryWrite, hdr, body)
+		atomic.AddUint64(&c.io.written, uint64(n))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.fileChanged(base, path, false)
+}
@@ -0,0 +1,148 @@
+package elstore
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"testing"
+)
+
+func TestEncryption(t *testing.T) {
+	key1 := bytes.Repeat([]byte{1}, 32)
+	key2 := bytes.Repeat([]byte{2}, 16)
+	c, err := NewElementStore(0, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	if err := c.Put(testData2, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Sync()
+	if err := c.Rekey(nil, key1); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Put(testData2, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Sync()
+	for _, id := range []uint64{1, 2} {
+		data, err := ioutil.ReadFile(elFile(testDir, id))
+		if err != nil {
+			t.Fatal(err)
+		} else if bytes.Contains(data, testData2) {
+			t.Fatal("element not encrypted", id)
+		}
+	}
+
+	// a store opened without the key can't read the elements
+	c.Close()
+	c, err = NewElementStore(0, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.Get(1); !errors.Is(err, ErrEncrypted) {
+		t.Fatal("expected ErrEncrypted, got", err)
+	}
+
+	c.Close()
+	c, err = NewElementStore(0, testDir, WithEncryption(key1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Rekey(key1, key2); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Close()
+	c, err = NewElementStore(0, testDir, WithEncryption(key2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+
+	for _, id := range []uint64{1, 2} {
+		if data, err := c.Get(id); err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(data, testData2) {
+			t.Fatalf("expected\n%v\n\ngot\n%v\n\n", testData2, data)
+		}
+	}
+
+	if report, err := c.Verify(); err != nil || report.Verified != 2 {
+		t.Fatal("unexpected report", report, err)
+	}
+
+	if _, err := NewElementStore(0, testDir, WithEncryption([]byte("short"))); err == nil {
+		t.Fatal("expected error for invalid key")
+	}
+}
+
+func TestRekeyConcurrent(t *testing.T) {
+	key1 := bytes.Repeat([]byte{1}, 32)
+	key2 := bytes.Repeat([]byte{2}, 32)
+	c, err := NewElementStore(0, testDir, WithEncryption(key1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	for id := uint64(0); id < 100; id++ {
+		c.Put(testData2, id)
+	}
+
+	c.Sync()
+
+	// the store is used while it's rekeyed
+	done := make(chan error)
+	go func() {
+		for id := uint64(0); id < 100; id++ {
+			if _, err := c.Get(id); err != nil {
+				done <- err
+				return
+			} else if err := c.Put(testData2, 100+id); err != nil {
+				done <- err
+				return
+			} else if id%10 == 0 {
+				if err := c.Delete(id); err != nil {
+					done <- err
+					return
+				}
+			}
+		}
+
+		done <- nil
+	}()
+
+	if err := c.Rekey(key1, key2); err != nil {
+		t.Fatal(err)
+	} else if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	c.Close()
+	c, err = NewElementStore(0, testDir, WithEncryption(key2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	for _, id := range c.IDs() {
+		if data, err := c.Get(id); err != nil {
+			t.Fatal(id, err)
+		} else if !bytes.Equal(data, testData2) {
+			t.Fatalf("expected\n%v\n\ngot\n%v\n\n", testData2, data)
+		}
+	}
+
+	if n := len(c.IDs()); n != 190 {
+		t.Fatal("expected 190 elements, got", n)
+	}
+}
@@ -0,0 +1,82 @@
+package elstore
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// name of the ownership record in the workdir. Every store instance writes
+// its own epoch to it when opened, and verifies that it's still the owner
+// before writing elements
+const ownerFile = ".owner"
+
+func newEpoch() (uint64, error) {
+	var buf [8]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return 0, err
+	}
+
+	return binary.LittleEndian.Uint64(buf[:]), nil
+}
+
+// takes ownership of the workdir by writing a new epoch to the ownership
+// record
+func (c *ElementStore) claimOwnership() error {
+	epoch, err := newEpoch()
+	if err != nil {
+		return err
+	}
+
+	host, _ := os.Hostname()
+	record := fmt.Sprintf("%x %d %s\n", epoch, os.Getpid(), host)
+	path := filepath.Join(c.workdir, ownerFile)
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(record), c.fileMode()); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+
+	c.epoch = epoch
+	return nil
+}
+
+// returns an error wrapping ErrEpochConflict if another store instance has
+// taken over the workdir
+func (c *ElementStore) checkOwnership() error {
+	var data []byte
+	err := c.retryStale(func() error {
+		var err error
+		data, err = ioutil.ReadFile(filepath.Join(c.workdir, ownerFile))
+		return err
+	})
+
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%w: ownership record removed", ErrEpochConflict)
+	} else if err != nil {
+		// ownership can't be verified, but a broken disk is handled by the
+		// element write itself (and possibly a standby)
+		return nil
+	}
+
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return fmt.Errorf("%w: empty ownership record", ErrEpochConflict)
+	}
+
+	epoch, err := strconv.ParseUint(fields[0], 16, 64)
+	if err != nil || epoch != c.epoch {
+		return fmt.Errorf("%w: workdir owned by %s", ErrEpochConflict,
+			strings.TrimSpace(string(data)))
+	}
+
+	return nil
+}
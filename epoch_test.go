@@ -0,0 +1,49 @@
+package elstore
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEpochConflict(t *testing.T) {
+	a, err := NewElementStore(0, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer a.Remove()
+	if err := a.Put(testData2, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	a.Sync()
+	if err := a.WriteError(); err != nil {
+		t.Fatal(err)
+	}
+
+	// another instance takes over the workdir, as if from another process
+	// that didn't see the lock
+	record := []byte("1 1 elsewhere\n")
+	if err := os.WriteFile(filepath.Join(testDir, ownerFile), record, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.Put(testData2, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	a.Sync()
+	if err := a.WriteError(); !errors.Is(err, ErrEpochConflict) {
+		t.Fatal("expected ErrEpochConflict, got", err)
+	}
+
+	if err := a.Put(testData2, 3); !errors.Is(err, ErrEpochConflict) {
+		t.Fatal("expected ErrEpochConflict, got", err)
+	}
+
+	if _, err := os.Stat(elFile(testDir, 2)); !os.IsNotExist(err) {
+		t.Fatal("element written by stale instance")
+	}
+}
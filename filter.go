@@ -0,0 +1,120 @@
+package elstore
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// A serialized ID filter is laid out as
+//
+//	magic      8 bytes
+//	hashes     uint32
+//	reserved   uint32
+//	bits       uint64
+//	bitset     (bits+7)/8 bytes
+//
+// with all integers little endian
+const (
+	filterMagic      = "ELSBLOOM"
+	filterHeaderSize = 24
+
+	// about 1% false positives
+	filterBitsPerID = 10
+	filterHashes    = 7
+)
+
+var ErrCorruptFilter = errors.New("Corrupt ID filter")
+
+// A Bloom filter of the IDs of a store, as returned by MembershipFilter.
+// MayContain never returns false for an ID that was in the store when the
+// filter was made, and returns true for about 1% of the IDs that weren't
+type IDFilter struct {
+	hashes uint32
+	bits   uint64
+	bitset []byte
+}
+
+// Parses a filter returned by MembershipFilter
+func ParseIDFilter(data []byte) (*IDFilter, error) {
+	if len(data) < filterHeaderSize || string(data[:8]) != filterMagic {
+		return nil, ErrCorruptFilter
+	}
+
+	f := &IDFilter{
+		hashes: binary.LittleEndian.Uint32(data[8:]),
+		bits:   binary.LittleEndian.Uint64(data[16:]),
+		bitset: data[filterHeaderSize:],
+	}
+
+	if f.bits == 0 || uint64(len(f.bitset)) != (f.bits+7)/8 {
+		return nil, ErrCorruptFilter
+	}
+
+	return f, nil
+}
+
+func newIDFilter(n int) *IDFilter {
+	bits := uint64(n) * filterBitsPerID
+	if bits < 64 {
+		bits = 64
+	}
+
+	return &IDFilter{
+		hashes: filterHashes,
+		bits:   bits,
+		bitset: make([]byte, (bits+7)/8),
+	}
+}
+
+// the bits of an ID, by double hashing of a 64-bit mix of the ID
+func (f *IDFilter) each(id uint64, fn func(bit uint64) bool) bool {
+	// splitmix64 finalizer
+	h := id + 0x9e3779b97f4a7c15
+	h = (h ^ h>>30) * 0xbf58476d1ce4e5b9
+	h = (h ^ h>>27) * 0x94d049bb133111eb
+	h ^= h >> 31
+
+	h1, h2 := h&0xffffffff, h>>32|1
+	for i := uint64(0); i < uint64(f.hashes); i++ {
+		if !fn((h1 + i*h2) % f.bits) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (f *IDFilter) add(id uint64) {
+	f.each(id, func(bit uint64) bool {
+		f.bitset[bit/8] |= 1 << (bit % 8)
+		return true
+	})
+}
+
+// Returns false if the ID was not in the store when the filter was made
+func (f *IDFilter) MayContain(id uint64) bool {
+	return f.each(id, func(bit uint64) bool {
+		return f.bitset[bit/8]&(1<<(bit%8)) != 0
+	})
+}
+
+func (f *IDFilter) marshal() []byte {
+	data := make([]byte, filterHeaderSize, filterHeaderSize+len(f.bitset))
+	copy(data, filterMagic)
+	binary.LittleEndian.PutUint32(data[8:], f.hashes)
+	binary.LittleEndian.PutUint64(data[16:], f.bits)
+	return append(data, f.bitset...)
+}
+
+// Returns a serialized Bloom filter of the IDs in the store, including
+// aliases, e.g. for producers to skip sending elements the store already
+// has. The filter is parsed by ParseIDFilter
+func (c *ElementStore) MembershipFilter() ([]byte, error) {
+	ids := c.IDs()
+	f := newIDFilter(len(ids))
+	for _, id := range ids {
+		f.add(id)
+	}
+
+	return f.marshal(), nil
+}
@@ -0,0 +1,46 @@
+package elstore
+
+import (
+	"testing"
+)
+
+func TestMembershipFilter(t *testing.T) {
+	c, err := NewElementStore(0, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	for id := uint64(0); id < 1000; id++ {
+		if err := c.Put(testData2, id*3); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := c.MembershipFilter()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := ParseIDFilter(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	falsePositives := 0
+	for id := uint64(0); id < 3000; id++ {
+		if id%3 == 0 && !f.MayContain(id) {
+			t.Fatal("stored ID not in filter", id)
+		} else if id%3 != 0 && f.MayContain(id) {
+			falsePositives++
+		}
+	}
+
+	if falsePositives > 60 {
+		t.Fatal("too many false positives", falsePositives)
+	}
+
+	if _, err := ParseIDFilter(data[:len(data)-1]); err != ErrCorruptFilter {
+		t.Fatal("expected ErrCorruptFilter, got", err)
+	}
+}
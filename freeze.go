@@ -0,0 +1,336 @@
+package elstore
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+	"time"
+)
+
+// A frozen store is a single file laid out as
+//
+//	magic      8 bytes
+//	count      uint64
+//	index      count * (ID uint64, offset uint64, length uint64,
+//	                    CRC-32 uint32, reserved uint32), by ID
+//	payloads
+//
+// with all integers little endian and offsets counted from the start of
+// the file. Aliases are index entries sharing the payload of their target
+const (
+	frozenMagic      = "ELSFROZ1"
+	frozenHeaderSize = 16
+	frozenEntrySize  = 32
+)
+
+var ErrCorruptArchive = errors.New("Corrupt frozen store")
+
+// Returned, possibly wrapped, when an element can't be read because its
+// data is damaged
+var ErrCorrupted = errors.New("Element corrupted")
+
+// The checksum stored with an element doesn't match its data
+var ErrChecksumMismatch = fmt.Errorf("%w: checksum mismatch", ErrCorrupted)
+
+// Writes the content of the store to 'dst' as a single read-optimized
+// file, which can be opened with OpenReadOnly. Pending writes are synced
+// first; elements Put while freezing may or may not be included
+func (c *ElementStore) Freeze(dst string) error {
+	if err := c.Sync(); err != nil {
+		return err
+	}
+
+	c.storeMutex.RLock()
+	aliases := make(map[uint64]uint64, len(c.aliases))
+	for id, target := range c.aliases {
+		aliases[id] = target
+	}
+
+	c.storeMutex.RUnlock()
+
+	// dangling aliases, left by cancelled Puts, are not included
+	ids := c.IDs()
+	present := make(map[uint64]bool, len(ids))
+	for _, id := range ids {
+		present[id] = true
+	}
+
+	live := ids[:0]
+	for _, id := range ids {
+		if target, ok := aliases[id]; !ok || present[target] {
+			live = append(live, id)
+		}
+	}
+
+	ids = live
+	tmp := dst + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp)
+	defer f.Close()
+
+	index := make([]byte, frozenHeaderSize+frozenEntrySize*len(ids))
+	copy(index, frozenMagic)
+	binary.LittleEndian.PutUint64(index[8:], uint64(len(ids)))
+	if _, err := f.Seek(int64(len(index)), io.SeekStart); err != nil {
+		return err
+	}
+
+	// payloads are written in ID order, followed by the index up front
+	type extent struct {
+		off, len uint64
+		sum      uint32
+	}
+	extents := make(map[uint64]extent, len(ids))
+	w := bufio.NewWriter(f)
+	off := uint64(len(index))
+	for _, id := range ids {
+		if _, ok := aliases[id]; ok {
+			continue
+		}
+
+		// read past the cache, so that freezing doesn't evict hot elements
+		el, err := c.GetWith(id, GetOpts{NoCache: true})
+		if err == ErrDoesNotExist {
+			// deleted while freezing
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		if _, err := w.Write(el); err != nil {
+			return err
+		}
+
+		extents[id] = extent{off, uint64(len(el)), crc32.ChecksumIEEE(el)}
+		off += uint64(len(el))
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	// the index has room for the elements deleted while freezing, which
+	// is left unused
+	frozen := ids[:0]
+	for _, id := range ids {
+		target, ok := aliases[id]
+		if !ok {
+			target = id
+		}
+
+		if _, ok := extents[target]; ok {
+			frozen = append(frozen, id)
+		}
+	}
+
+	binary.LittleEndian.PutUint64(index[8:], uint64(len(frozen)))
+	for i, id := range frozen {
+		ext, ok := extents[id]
+		if !ok {
+			ext = extents[aliases[id]]
+		}
+
+		entry := index[frozenHeaderSize+i*frozenEntrySize:]
+		binary.LittleEndian.PutUint64(entry, id)
+		binary.LittleEndian.PutUint64(entry[8:], ext.off)
+		binary.LittleEndian.PutUint64(entry[16:], ext.len)
+		binary.LittleEndian.PutUint32(entry[24:], ext.sum)
+	}
+
+	if _, err := f.WriteAt(index, 0); err != nil {
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, dst)
+}
+
+// A read-only store opened from a file written by Freeze
+type FrozenStore struct {
+	data    []byte
+	count   int
+	release func() error
+}
+
+// Opens a file written by Freeze. Where supported, the file is mapped into
+// memory rather than read
+func OpenReadOnly(path string) (*FrozenStore, error) {
+	data, release, err := mapFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := newFrozenStore(data, release)
+	if err != nil {
+		release()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Opens a file written by Freeze from a file system, such as an embed.FS,
+// so that frozen stores can be shipped inside a binary:
+//
+//	//go:embed countries.frozen
+//	var assets embed.FS
+//
+//	countries, err := elstore.OpenReadOnlyFS(assets, "countries.frozen")
+//
+// The file is read into memory
+func OpenReadOnlyFS(fsys fs.FS, name string) (*FrozenStore, error) {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return newFrozenStore(data, nil)
+}
+
+func newFrozenStore(data []byte, release func() error) (*FrozenStore, error) {
+	if len(data) < frozenHeaderSize || string(data[:8]) != frozenMagic {
+		return nil, ErrCorruptArchive
+	}
+
+	count := binary.LittleEndian.Uint64(data[8:])
+	if count > uint64(len(data)-frozenHeaderSize)/frozenEntrySize {
+		return nil, ErrCorruptArchive
+	}
+
+	s := &FrozenStore{data: data, count: int(count), release: release}
+	for i := 0; i < s.count; i++ {
+		_, off, n, _ := s.entry(i)
+		if off > uint64(len(data)) || n > uint64(len(data))-off {
+			return nil, ErrCorruptArchive
+		}
+	}
+
+	return s, nil
+}
+
+func (s *FrozenStore) entry(i int) (id, off, n uint64, sum uint32) {
+	e := s.data[frozenHeaderSize+i*frozenEntrySize:]
+	return binary.LittleEndian.Uint64(e), binary.LittleEndian.Uint64(e[8:]),
+		binary.LittleEndian.Uint64(e[16:]), binary.LittleEndian.Uint32(e[24:])
+}
+
+func (s *FrozenStore) find(id uint64) (int, bool) {
+	i := sort.Search(s.count, func(i int) bool {
+		eid, _, _, _ := s.entry(i)
+		return eid >= id
+	})
+
+	if i < s.count {
+		eid, _, _, _ := s.entry(i)
+		return i, eid == id
+	}
+
+	return i, false
+}
+
+// Returns true if an ID exists in the store
+func (s *FrozenStore) Has(id uint64) bool {
+	_, ok := s.find(id)
+	return ok
+}
+
+// Returns the IDs of all elements in the store, in ascending order
+func (s *FrozenStore) IDs() []uint64 {
+	ids := make([]uint64, s.count)
+	for i := range ids {
+		ids[i], _, _, _ = s.entry(i)
+	}
+
+	return ids
+}
+
+// Get a copy of an element from the store
+//
+// returns ErrDoesNotExist if the ID is not recognized, and
+// ErrChecksumMismatch if the element is corrupt
+func (s *FrozenStore) Get(id uint64) ([]byte, error) {
+	i, ok := s.find(id)
+	if !ok {
+		return nil, ErrDoesNotExist
+	}
+
+	_, off, n, sum := s.entry(i)
+	el := make([]byte, n)
+	copy(el, s.data[off:off+n])
+	if crc32.ChecksumIEEE(el) != sum {
+		return nil, ErrChecksumMismatch
+	}
+
+	return el, nil
+}
+
+// Result of FrozenStore.Verify and ElementStore.Verify
+type VerifyReport struct {
+	Verified   int      // number of elements with a matching checksum
+	Mismatched []uint64 // IDs of corrupt elements, in ascending order
+	Bytes      int64    // bytes read
+	Duration   time.Duration
+}
+
+// Returns the report in the form shared by maintenance operations
+func (r VerifyReport) Report() Report {
+	report := Report{
+		Operation: "verify",
+		Duration:  r.Duration,
+		Elements:  r.Verified + len(r.Mismatched),
+		Bytes:     r.Bytes,
+	}
+
+	for _, id := range r.Mismatched {
+		report.addError(id, ErrChecksumMismatch)
+	}
+
+	return report
+}
+
+// Checks the checksums of all elements in the store, e.g. after copying
+// it to another machine
+func (s *FrozenStore) Verify() VerifyReport {
+	var report VerifyReport
+	start := time.Now()
+	for i := 0; i < s.count; i++ {
+		id, off, n, sum := s.entry(i)
+		report.Bytes += int64(n)
+		if crc32.ChecksumIEEE(s.data[off:off+n]) == sum {
+			report.Verified++
+		} else {
+			report.Mismatched = append(report.Mismatched, id)
+		}
+	}
+
+	report.Duration = time.Since(start)
+	return report
+}
+
+// Releases the file backing the store. The store can't be used afterwards
+func (s *FrozenStore) Close() error {
+	s.data = nil
+	s.count = 0
+	if s.release == nil {
+		return nil
+	}
+
+	return s.release()
+}
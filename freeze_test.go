@@ -0,0 +1,194 @@
+package elstore
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func TestFreeze(t *testing.T) {
+	frozen := testDir + ".frozen"
+	defer os.Remove(frozen)
+
+	c, err := NewElementStore(0, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	for id := uint64(1); id <= 3; id++ {
+		if err := c.Put(testData[:id*10], id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := c.Alias(4, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Freeze(frozen); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := OpenReadOnly(frozen)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer s.Close()
+	if report, err := Diff(c, s); err != nil {
+		t.Fatal(err)
+	} else if !report.Equal() {
+		t.Fatal("frozen store differs", report)
+	}
+
+	if s.Has(5) {
+		t.Fatal("unexpected ID")
+	}
+
+	if _, err := s.Get(5); err != ErrDoesNotExist {
+		t.Fatal("expected ErrDoesNotExist, got", err)
+	}
+
+	// the alias shares the payload of its target
+	if fi, _ := os.Stat(frozen); fi.Size() != frozenHeaderSize+4*frozenEntrySize+60 {
+		t.Fatal("unexpected archive size", fi.Size())
+	}
+
+	if ids := s.IDs(); !reflect.DeepEqual(ids, []uint64{1, 2, 3, 4}) {
+		t.Fatal("unexpected IDs", ids)
+	}
+}
+
+func TestOpenReadOnlyFS(t *testing.T) {
+	frozen := testDir + ".frozen"
+	defer os.Remove(frozen)
+
+	c, err := NewElementStore(0, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	if err := c.Put(testData2, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Freeze(frozen); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(frozen)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fsys := fstest.MapFS{"assets/store.frozen": &fstest.MapFile{Data: data}}
+	s, err := OpenReadOnlyFS(fsys, "assets/store.frozen")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer s.Close()
+	if report, err := Diff(c, s); err != nil {
+		t.Fatal(err)
+	} else if !report.Equal() {
+		t.Fatal("frozen store differs", report)
+	}
+
+	if _, err := OpenReadOnlyFS(fsys, "missing.frozen"); err == nil {
+		t.Fatal("expected an error for a missing file")
+	}
+}
+
+func TestFrozenChecksums(t *testing.T) {
+	frozen := testDir + ".frozen"
+	defer os.Remove(frozen)
+
+	c, err := NewElementStore(0, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	for id := uint64(1); id <= 2; id++ {
+		if err := c.Put(testData2, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := c.Freeze(frozen); err != nil {
+		t.Fatal(err)
+	}
+
+	// flip a bit in the last payload, which belongs to element 2
+	data, err := ioutil.ReadFile(frozen)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data[len(data)-1] ^= 1
+	if err := ioutil.WriteFile(frozen, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := OpenReadOnly(frozen)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer s.Close()
+	if _, err := s.Get(2); err != ErrChecksumMismatch {
+		t.Fatal("expected ErrChecksumMismatch, got", err)
+	}
+
+	report := s.Verify()
+	if report.Verified != 1 || !reflect.DeepEqual(report.Mismatched, []uint64{2}) {
+		t.Fatal("unexpected report", report)
+	}
+}
+
+func TestOpenCorruptArchive(t *testing.T) {
+	frozen := testDir + ".frozen"
+	defer os.Remove(frozen)
+
+	// an index entry pointing past the end of the file
+	data := []byte(frozenMagic + "\x01\x00\x00\x00\x00\x00\x00\x00" +
+		"\x01\x00\x00\x00\x00\x00\x00\x00" +
+		"\x28\x00\x00\x00\x00\x00\x00\x00" +
+		"\xff\x00\x00\x00\x00\x00\x00\x00" +
+		"\x00\x00\x00\x00\x00\x00\x00\x00")
+	if err := ioutil.WriteFile(frozen, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := OpenReadOnly(frozen); err != ErrCorruptArchive {
+		t.Fatal("expected ErrCorruptArchive, got", err)
+	}
+}
+
+func TestFreezeUncached(t *testing.T) {
+	frozen := testDir + ".frozen"
+	defer os.Remove(frozen)
+
+	c, err := NewElementStore(0, testDir, WithAccessTracking())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	if err := c.Put(testData2, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Freeze(frozen); err != nil {
+		t.Fatal(err)
+	}
+
+	// reads for freezing don't count as accesses
+	if stats := c.AccessStats(); len(stats) != 1 || stats[0].Reads != 0 {
+		t.Fatal("unexpected stats", stats)
+	}
+}
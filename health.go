@@ -0,0 +1,66 @@
+package elstore
+
+import "sync/atomic"
+
+// Overall condition of a store
+type HealthStatus int
+
+const (
+	// Reads and writes work
+	Healthy HealthStatus = iota
+
+	// Elements can be read and written, but the disk layer misbehaves, e.g.
+	// recent disk reads timed out, the circuit breaker is open, the store
+	// has failed over to its standby or writes to a shard have failed
+	Degraded
+
+	// A write error has occurred and writes are prevented
+	Failed
+
+	// The elements of the warmup list are being loaded into the cache, see
+	// WithWarmupReadiness
+	WarmingUp
+)
+
+func (h HealthStatus) String() string {
+	switch h {
+	case Healthy:
+		return "healthy"
+	case Degraded:
+		return "degraded"
+	case Failed:
+		return "failed"
+	case WarmingUp:
+		return "warming up"
+	default:
+		return "unknown"
+	}
+}
+
+// Returns the current health of the store
+func (c *ElementStore) Health() HealthStatus {
+	if c.WriteError() != nil {
+		return Failed
+	}
+
+	if c.warmupReadiness && atomic.LoadInt32(&c.warming) != 0 {
+		return WarmingUp
+	}
+
+	if atomic.LoadInt32(&c.degraded) != 0 || c.breaker.isOpen() ||
+		c.isFailedOver() || atomic.LoadInt32(&c.standbyFailed) != 0 ||
+		c.shardFailed() {
+		return Degraded
+	}
+
+	return Healthy
+}
+
+func (c *ElementStore) setDegraded(degraded bool) {
+	var val int32
+	if degraded {
+		val = 1
+	}
+
+	atomic.StoreInt32(&c.degraded, val)
+}
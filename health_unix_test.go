@@ -0,0 +1,79 @@
+//go:build unix
+
+package elstore
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestReadTimeout(t *testing.T) {
+	c, err := NewElementStore(0, testDir, WithReadTimeout(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	if err := c.Put(testData2, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Sync()
+
+	// replace the element with a FIFO; opening it blocks like a hung disk
+	path := elFile(testDir, 1)
+	os.Remove(path)
+	if err := syscall.Mkfifo(path, 0600); err != nil {
+		t.Skip("unable to create FIFO:", err)
+	}
+
+	if _, err := c.Get(1); err != ErrReadTimeout {
+		t.Fatal("expected ErrReadTimeout, got", err)
+	}
+
+	if h := c.Health(); h != Degraded {
+		t.Fatal("expected degraded store, got", h)
+	}
+
+	// unblock the abandoned reader
+	if w, err := os.OpenFile(path, os.O_WRONLY|syscall.O_NONBLOCK, 0); err == nil {
+		w.Close()
+	}
+}
+
+func TestGetCtxCancelled(t *testing.T) {
+	c, err := NewElementStore(0, testDir, WithCircuitBreaker(1, time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	if err := c.Put(testData2, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Sync()
+	path := elFile(testDir, 1)
+	os.Remove(path)
+	if err := syscall.Mkfifo(path, 0600); err != nil {
+		t.Skip("unable to create FIFO:", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.GetCtx(ctx, 1); err != context.DeadlineExceeded {
+		t.Fatal("expected context.DeadlineExceeded, got", err)
+	}
+
+	// the caller giving up is no disk failure
+	if h := c.Health(); h != Healthy || c.breaker.isOpen() {
+		t.Fatal("abandoned read counted against the disk", h)
+	}
+
+	if w, err := os.OpenFile(path, os.O_WRONLY|syscall.O_NONBLOCK, 0); err == nil {
+		w.Close()
+	}
+}
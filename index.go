@@ -0,0 +1,244 @@
+package elstore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// The files of a directory tree are listed in indexFile, so that opening
+// the store only reads the subdirectories that changed since the index was
+// written rather than walking the whole tree. A subdirectory is read if its
+// modification time differs from the one in the index, or is too close to
+// the time the index was written for a change to be told apart. The index
+// is rewritten every indexInterval and when the store is closed, reading
+// only the subdirectories that changed since the previous index
+//
+// The index is gob encoded, followed by its CRC-32, little endian
+const (
+	indexFile       = ".index"
+	indexRacyWindow = 2 * time.Second
+)
+
+var indexInterval = 10 * time.Minute
+
+var errCorruptIndex = errors.New("Corrupt index")
+
+type treeIndex struct {
+	Written int64                 // Unix nanoseconds
+	Dirs    map[string]indexedDir // by name of the subdirectory
+}
+
+type indexedDir struct {
+	ModTime int64 // Unix nanoseconds
+	Entries []indexEntry
+}
+
+type indexEntry struct {
+	Path string // relative to the subdirectory
+	Size int64  // of the element, if the file is one
+}
+
+// returns the index of a subdirectory if it's up to date
+func (idx *treeIndex) fresh(fi os.FileInfo) (indexedDir, bool) {
+	if idx == nil {
+		return indexedDir{}, false
+	}
+
+	d, ok := idx.Dirs[fi.Name()]
+	mtime := fi.ModTime().UnixNano()
+	return d, ok && d.ModTime == mtime && mtime < idx.Written-int64(indexRacyWindow)
+}
+
+// returns nil if there's no usable index
+func readIndex(base string) *treeIndex {
+	data, err := ioutil.ReadFile(filepath.Join(base, indexFile))
+	if err != nil || len(data) < 4 {
+		return nil
+	}
+
+	body, sum := data[:len(data)-4], data[len(data)-4:]
+	if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(sum) {
+		return nil
+	}
+
+	var idx treeIndex
+	if err := gob.NewDecoder(bytes.NewReader(body)).Decode(&idx); err != nil {
+		return nil
+	}
+
+	return &idx
+}
+
+// loads a directory tree into the store, from the index where it's up to
+// date and by walking it elsewhere
+func (c *ElementStore) loadTree(base string, walker filepath.WalkFunc,
+	visit func(path string, size int64)) error {
+	idx := readIndex(base)
+	entries, err := ioutil.ReadDir(base)
+	if err != nil {
+		return err
+	}
+
+	for _, fi := range entries {
+		path := filepath.Join(base, fi.Name())
+		if !fi.IsDir() {
+			walker(path, fi, nil)
+		} else if fi.Name() == segmentsDir {
+			continue
+		} else if d, ok := idx.fresh(fi); ok {
+			c.openReport.Indexed++
+			for _, e := range d.Entries {
+				visit(filepath.Join(path, e.Path), e.Size)
+			}
+		} else if err := filepath.Walk(path, walker); err != nil {
+			return err
+		} else {
+			c.openReport.Walked++
+		}
+	}
+
+	return nil
+}
+
+// enables writing the indexes of the workdir and shards
+func (c *ElementStore) startIndexing(shards []string) {
+	if !c.sharedReader {
+		c.indexDirs = append([]string{c.workdir}, shards...)
+	}
+}
+
+// starts rewriting the indexes periodically once the store has changed
+func (c *ElementStore) indexChanged() {
+	if c.indexDirs != nil {
+		c.indexer.Do(func() {
+			c.schedule("index", indexInterval, func() {
+				c.writeIndexes(false)
+			})
+		})
+	}
+}
+
+// Rewrites the indexes of the workdir and shards from scratch, reading
+// every subdirectory, e.g. after files were changed while the store was
+// closed without changing the modification times of their directories
+func (c *ElementStore) Reindex() error {
+	if c.IsReadOnly() {
+		return ErrReadOnly
+	}
+
+	return c.writeIndexes(true)
+}
+
+func (c *ElementStore) writeIndexes(full bool) error {
+	c.indexMu.Lock()
+	defer c.indexMu.Unlock()
+
+	// element sizes are taken from memory rather than from the files
+	c.storeMutex.RLock()
+	sizes := make(map[uint64]int64, len(c.onDisk))
+	for id, size := range c.onDisk {
+		sizes[id] = size
+	}
+
+	c.storeMutex.RUnlock()
+
+	for _, base := range c.indexDirs {
+		if err := c.writeIndex(base, sizes, full); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writes the index of a directory tree, reading the subdirectories that
+// changed since the previous index, or all of them if 'full' is set
+func (c *ElementStore) writeIndex(base string, sizes map[uint64]int64, full bool) error {
+	var prev *treeIndex
+	if !full {
+		prev = readIndex(base)
+	}
+
+	idx := treeIndex{Written: time.Now().UnixNano(), Dirs: make(map[string]indexedDir)}
+	entries, err := ioutil.ReadDir(base)
+	if err != nil {
+		return err
+	}
+
+	for _, fi := range entries {
+		if !fi.IsDir() || fi.Name() == segmentsDir {
+			continue
+		} else if d, ok := prev.fresh(fi); ok {
+			idx.Dirs[fi.Name()] = d
+			continue
+		}
+
+		// the modification time is from before the directory is read, so
+		// that changes made while reading it are noticed
+		d := indexedDir{ModTime: fi.ModTime().UnixNano()}
+		dir := filepath.Join(base, fi.Name())
+		if err := listFiles(dir, "", c.parseName, sizes, &d.Entries); err != nil {
+			return err
+		}
+
+		idx.Dirs[fi.Name()] = d
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&idx); err != nil {
+		return err
+	}
+
+	var sum [4]byte
+	binary.LittleEndian.PutUint32(sum[:], crc32.ChecksumIEEE(buf.Bytes()))
+	_, err = writeData(filepath.Join(base, indexFile), c.fileMode(), false,
+		buf.Bytes(), sum[:])
+	return err
+}
+
+// appends the regular files below 'dir' to 'entries'. Files of elements in
+// 'sizes' are not stat'ed
+func listFiles(dir, rel string, parse func(string) (uint64, bool), sizes map[uint64]int64,
+	entries *[]indexEntry) error {
+	des, err := os.ReadDir(filepath.Join(dir, rel))
+	if err != nil {
+		return err
+	}
+
+	for _, de := range des {
+		path := filepath.Join(rel, de.Name())
+		if de.IsDir() {
+			if err := listFiles(dir, path, parse, sizes, entries); err != nil {
+				return err
+			}
+
+			continue
+		} else if !de.Type().IsRegular() {
+			continue
+		}
+
+		id, ok := parse(de.Name())
+		size, known := sizes[id]
+		if !ok || !known {
+			fi, err := de.Info()
+			if os.IsNotExist(err) {
+				continue
+			} else if err != nil {
+				return err
+			}
+
+			size = fileElementSize(filepath.Join(dir, path), fi.Size())
+		}
+
+		*entries = append(*entries, indexEntry{path, size})
+	}
+
+	return nil
+}
@@ -0,0 +1,121 @@
+package elstore
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// moves the modification times of the workdir subdirectories out of the
+// racy window of an index written now
+func ageDirs(t *testing.T, dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old := time.Now().Add(-time.Minute)
+	for _, e := range entries {
+		if e.IsDir() {
+			if err := os.Chtimes(filepath.Join(dir, e.Name()), old, old); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestIndex(t *testing.T) {
+	c, err := NewElementStore(0, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	for id := uint64(1); id <= 3; id++ {
+		if err := c.Put(testData2, id<<56); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c.Sync()
+	ageDirs(t, testDir)
+	c.Close()
+	c, err = NewElementStore(0, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	if r := c.OpenReport(); r.Indexed == 0 || r.Walked != 0 {
+		t.Fatal("expected the workdir to be loaded from the index", r.Indexed, r.Walked)
+	}
+
+	for id := uint64(1); id <= 3; id++ {
+		data, err := c.Get(id << 56)
+		if err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(data, testData2) {
+			t.Fatalf("expected\n%v\n\ngot\n%v\n\n", testData2, data)
+		}
+	}
+
+	// a directory changed behind the store's back is walked
+	path := elFile(c.root(1<<56), 1<<56)
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Close()
+	c, err = NewElementStore(0, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	if r := c.OpenReport(); r.Walked == 0 {
+		t.Fatal("expected the changed directory to be walked")
+	} else if c.Has(1 << 56) {
+		t.Fatal("removed element loaded from the index")
+	}
+}
+
+func TestReindex(t *testing.T) {
+	c, err := NewElementStore(0, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	if err := c.Put(testData2, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Sync()
+	ageDirs(t, testDir)
+	if err := c.Reindex(); err != nil {
+		t.Fatal(err)
+	}
+
+	idx := readIndex(testDir)
+	if idx == nil || len(idx.Dirs) == 0 {
+		t.Fatal("index not written")
+	}
+
+	// a corrupt index is ignored
+	c.Close()
+	if err := os.WriteFile(filepath.Join(testDir, indexFile), []byte("junk"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err = NewElementStore(0, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	if r := c.OpenReport(); r.Indexed != 0 || r.Walked == 0 || !c.Has(1) {
+		t.Fatal("expected the workdir to be walked", r.Indexed, r.Walked)
+	}
+}
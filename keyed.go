@@ -0,0 +1,138 @@
+package elstore
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"sort"
+)
+
+var ErrKeyCollision = errors.New("Key hashes to the ID of another key")
+var ErrNotKeyed = errors.New("Element not stored by key")
+
+// A wrapper around an ElementStore accepting string keys, such as URLs or
+// UUIDs. Keys are hashed to IDs, which decide the directory layout, and the
+// key is stored in front of each element so that collisions are detected
+// and keys can be listed. A store should only be used through a
+// KeyedStore, or not at all
+type KeyedStore struct {
+	store *ElementStore
+}
+
+// Returns a KeyedStore storing elements in 'store'
+func NewKeyedStore(store *ElementStore) *KeyedStore {
+	return &KeyedStore{store: store}
+}
+
+// Returns the underlying ElementStore, e.g. for Sync or Remove
+func (s *KeyedStore) Store() *ElementStore {
+	return s.store
+}
+
+// Returns the ID a key is stored under
+func KeyID(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return h.Sum64()
+}
+
+// elements are stored as the length of the key as a uvarint, the key and
+// the element
+func encodeKeyed(key string, elem []byte) []byte {
+	data := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+len(key)+len(elem))
+	data = data[:binary.PutUvarint(data, uint64(len(key)))]
+	data = append(data, key...)
+	return append(data, elem...)
+}
+
+func decodeKeyed(data []byte) (string, []byte, error) {
+	n, l := binary.Uvarint(data)
+	if l <= 0 || n > uint64(len(data)-l) {
+		return "", nil, ErrNotKeyed
+	}
+
+	return string(data[l : l+int(n)]), data[l+int(n):], nil
+}
+
+// Inserts an element into the store under 'key'
+//
+// Returns ErrAlreadyExists if the key is already in use, and
+// ErrKeyCollision if another key hashes to the same ID
+func (s *KeyedStore) Put(elem []byte, key string) error {
+	err := s.store.Put(encodeKeyed(key, elem), KeyID(key))
+	if err != ErrAlreadyExists {
+		return err
+	}
+
+	if _, err := s.Get(key); err == ErrKeyCollision {
+		return err
+	}
+
+	return ErrAlreadyExists
+}
+
+// Gets an element from the store
+//
+// returns ErrDoesNotExist if the key is not recognized, and
+// ErrKeyCollision if its ID holds the element of another key
+func (s *KeyedStore) Get(key string) ([]byte, error) {
+	data, err := s.store.Get(KeyID(key))
+	if err != nil {
+		return nil, err
+	}
+
+	stored, elem, err := decodeKeyed(data)
+	if err != nil {
+		return nil, err
+	} else if stored != key {
+		return nil, ErrKeyCollision
+	}
+
+	return elem, nil
+}
+
+// Returns true if a key exists in the store. The element is read to check
+// its key
+func (s *KeyedStore) Has(key string) bool {
+	_, err := s.Get(key)
+	return err == nil
+}
+
+// Deletes an element from the store
+//
+// returns ErrDoesNotExist if the key is not recognized
+func (s *KeyedStore) Delete(key string) error {
+	if _, err := s.Get(key); err == ErrKeyCollision {
+		return ErrDoesNotExist
+	} else if err != nil {
+		return err
+	}
+
+	return s.store.Delete(KeyID(key))
+}
+
+// Returns the keys of all elements in the store, in ascending order.
+// Elements are read to recover their keys
+func (s *KeyedStore) Keys() ([]string, error) {
+	var keys []string
+	var decodeErr error
+	err := s.store.Range(func(id uint64, data []byte) bool {
+		key, _, err := decodeKeyed(data)
+		if err != nil {
+			decodeErr = err
+			return false
+		}
+
+		keys = append(keys, key)
+		return true
+	})
+
+	if err != nil {
+		return nil, err
+	} else if decodeErr != nil {
+		return nil, decodeErr
+	}
+
+	sort.Strings(keys)
+	return keys, nil
+}
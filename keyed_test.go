@@ -0,0 +1,62 @@
+package elstore
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestKeyedStore(t *testing.T) {
+	c, err := NewElementStore(0, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	s := NewKeyedStore(c)
+	keys := []string{"https://example.com/", "", "3f2504e0-4f89-11d3-9a0c-0305e82c3301"}
+	for _, key := range keys {
+		if err := s.Put([]byte(key+"!"), key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := s.Put(testData2, keys[0]); err != ErrAlreadyExists {
+		t.Fatal("expected ErrAlreadyExists, got", err)
+	}
+
+	c.Sync()
+	for _, key := range keys {
+		el, err := s.Get(key)
+		if err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(el, []byte(key+"!")) {
+			t.Fatalf("expected\n%v\n\ngot\n%v\n\n", key+"!", string(el))
+		}
+	}
+
+	got, err := s.Keys()
+	if err != nil {
+		t.Fatal(err)
+	} else if want := []string{"", keys[2], keys[0]}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected\n%v\n\ngot\n%v\n\n", want, got)
+	}
+
+	if err := s.Delete(keys[0]); err != nil {
+		t.Fatal(err)
+	} else if s.Has(keys[0]) || !s.Has(keys[1]) {
+		t.Fatal("unexpected keys after delete")
+	} else if err := s.Delete(keys[0]); err != ErrDoesNotExist {
+		t.Fatal("expected ErrDoesNotExist, got", err)
+	}
+
+	// another key stored under the ID of "colliding"
+	c.Put(encodeKeyed("other", testData2), KeyID("colliding"))
+	if err := s.Put(testData2, "colliding"); err != ErrKeyCollision {
+		t.Fatal("expected ErrKeyCollision on Put, got", err)
+	} else if _, err := s.Get("colliding"); err != ErrKeyCollision {
+		t.Fatal("expected ErrKeyCollision on Get, got", err)
+	} else if err := s.Delete("colliding"); err != ErrDoesNotExist {
+		t.Fatal("expected ErrDoesNotExist on Delete, got", err)
+	}
+}
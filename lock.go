@@ -0,0 +1,43 @@
+package elstore
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+var ErrLocked = errors.New("Workdir locked by another process")
+
+// name of the lock file in the workdir. A store holds an exclusive lock on
+// it from when it's opened until it's closed, so that two processes never
+// share bookkeeping of the same workdir. The file is left in place, as
+// removing it would let a process lock a file that is no longer there
+//
+// Locks are taken with flock on Unix and LockFileEx on Windows, and not at
+// all on other platforms
+const lockName = ".lock"
+
+// locks the workdir, returning ErrLocked if it's locked by another process
+func (c *ElementStore) lockWorkdir() error {
+	f, err := os.OpenFile(filepath.Join(c.workdir, lockName),
+		os.O_RDWR|os.O_CREATE, c.fileMode())
+	if err != nil {
+		return err
+	}
+
+	if err := lockExclusive(f); err != nil {
+		f.Close()
+		return err
+	}
+
+	c.workdirLock = f
+	return nil
+}
+
+// releases the lock on the workdir, if held. Closing the file releases it
+func (c *ElementStore) unlockWorkdir() {
+	if c.workdirLock != nil {
+		c.workdirLock.Close()
+		c.workdirLock = nil
+	}
+}
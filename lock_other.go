@@ -0,0 +1,12 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd || windows)
+
+package elstore
+
+import (
+	"os"
+)
+
+// workdirs are not locked where flock isn't available
+func lockExclusive(f *os.File) error {
+	return nil
+}
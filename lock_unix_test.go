@@ -0,0 +1,40 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package elstore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWorkdirLock(t *testing.T) {
+	c, err := NewElementStore(0, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+
+	// flock locks conflict between open files in the same process as
+	// well, standing in for another process
+	f, err := os.OpenFile(filepath.Join(testDir, lockName), os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer f.Close()
+	if err := lockExclusive(f); err != ErrLocked {
+		t.Fatal("expected ErrLocked, got", err)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	} else if err := lockExclusive(f); err != nil {
+		t.Fatal("lock not released by Close:", err)
+	}
+
+	if _, err := NewElementStore(0, testDir); err != ErrLocked {
+		t.Fatal("expected ErrLocked, got", err)
+	}
+}
@@ -0,0 +1,32 @@
+//go:build windows
+
+package elstore
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var procLockFileEx = syscall.NewLazyDLL("kernel32.dll").NewProc("LockFileEx")
+
+const (
+	lockfileFailImmediately = 0x1
+	lockfileExclusiveLock   = 0x2
+
+	errLockViolation syscall.Errno = 33 // ERROR_LOCK_VIOLATION
+)
+
+func lockExclusive(f *os.File) error {
+	var ol syscall.Overlapped
+	r, _, err := procLockFileEx.Call(f.Fd(),
+		lockfileExclusiveLock|lockfileFailImmediately, 0, 1, 0,
+		uintptr(unsafe.Pointer(&ol)))
+	if r != 0 {
+		return nil
+	} else if err == errLockViolation {
+		return ErrLocked
+	}
+
+	return err
+}
@@ -0,0 +1,53 @@
+package elstore
+
+import "fmt"
+
+// An external in-memory cache used in place of the built-in one, so that
+// elements share an eviction domain with the rest of an application's
+// cached data. Implementations must be safe for concurrent use, and may
+// drop elements at any time. An adapter for ristretto could look like
+//
+//	type ristrettoTier struct{ c *ristretto.Cache }
+//
+//	func (t ristrettoTier) Get(id uint64) ([]byte, bool) {
+//		v, ok := t.c.Get(id)
+//		if !ok {
+//			return nil, false
+//		}
+//
+//		return v.([]byte), true
+//	}
+//
+//	func (t ristrettoTier) Set(id uint64, el []byte) { t.c.Set(id, el, int64(len(el))) }
+//	func (t ristrettoTier) Delete(id uint64)         { t.c.Del(id) }
+//
+// Elements are passed to Set and returned by Get without copying, and must
+// not be modified
+type MemoryTier interface {
+	Get(id uint64) ([]byte, bool)
+	Set(id uint64, el []byte)
+	Delete(id uint64)
+}
+
+// Caches elements read from disk in 'tier' instead of the built-in cache.
+// The cache size, cache policy and DisableCache don't apply to the tier,
+// which makes its own admission and eviction decisions
+func WithMemoryTier(tier MemoryTier) Option {
+	if tier == nil {
+		return Option{err: fmt.Errorf("%w: nil memory tier", ErrInvalidOption)}
+	}
+
+	return option(func(c *ElementStore) {
+		c.memTier = tier
+	})
+}
+
+// adds an element read at cacheGen 'gen' to the tier, unless it has been
+// deleted since. Deletes remove elements from the tier under storeMutex
+func (c *ElementStore) setMemTier(el []byte, id uint64, gen uint64) {
+	c.storeMutex.RLock()
+	defer c.storeMutex.RUnlock()
+	if gen == c.cacheGen {
+		c.memTier.Set(id, el)
+	}
+}
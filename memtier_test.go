@@ -0,0 +1,67 @@
+package elstore
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+)
+
+type mapTier struct {
+	sync.Mutex
+	els map[uint64][]byte
+}
+
+func (t *mapTier) Get(id uint64) ([]byte, bool) {
+	t.Lock()
+	defer t.Unlock()
+	el, ok := t.els[id]
+	return el, ok
+}
+
+func (t *mapTier) Set(id uint64, el []byte) {
+	t.Lock()
+	defer t.Unlock()
+	t.els[id] = el
+}
+
+func (t *mapTier) Delete(id uint64) {
+	t.Lock()
+	defer t.Unlock()
+	delete(t.els, id)
+}
+
+func TestMemoryTier(t *testing.T) {
+	if _, err := NewElementStore(0, testDir, WithMemoryTier(nil)); err == nil {
+		t.Fatal("expected error for nil memory tier")
+	}
+
+	tier := &mapTier{els: make(map[uint64][]byte)}
+	c, err := NewElementStore(0, testDir, WithMemoryTier(tier))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	c.Put(testData2, 1)
+	c.Sync()
+	if _, err := c.Get(1); err != nil {
+		t.Fatal(err)
+	} else if el, ok := tier.Get(1); !ok || !bytes.Equal(el, testData2) {
+		t.Fatal("element not set in memory tier")
+	} else if c.inMem.Len() != 0 {
+		t.Fatal("built-in cache used with a memory tier")
+	}
+
+	// served from the tier
+	tier.Set(1, testData)
+	if el, err := c.Get(1); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(el, testData) {
+		t.Fatalf("expected\n%v\n\ngot\n%v\n\n", testData, el)
+	}
+
+	c.Delete(1)
+	if _, ok := tier.Get(1); ok {
+		t.Fatal("deleted element left in memory tier")
+	}
+}
@@ -0,0 +1,198 @@
+package elstore
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Insertion times and expiry deadlines are kept in logs of records of an
+// ID and a Unix time in nanoseconds, little endian. A zero time removes the
+// ID. A log is rewritten when the store is opened if most of it is
+// obsolete
+const (
+	insertedFile   = ".inserted"
+	timeRecordSize = 16
+)
+
+type timeLog struct {
+	sync.Mutex
+	f     *os.File // nil for shared readers
+	times map[uint64]int64
+}
+
+func appendIDTime(buf []byte, id uint64, t int64) []byte {
+	var rec [timeRecordSize]byte
+	binary.LittleEndian.PutUint64(rec[:], id)
+	binary.LittleEndian.PutUint64(rec[8:], uint64(t))
+	return append(buf, rec[:]...)
+}
+
+// loads a log from the workdir, dropping the times of elements deleted
+// while the store was closed
+func (c *ElementStore) openTimeLog(l *timeLog, name string) error {
+	path := filepath.Join(c.workdir, name)
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	// a partial record at the end is left by a crash
+	records := len(data) / timeRecordSize
+	times := make(map[uint64]int64)
+	for i := 0; i < records; i++ {
+		rec := data[i*timeRecordSize:]
+		id := binary.LittleEndian.Uint64(rec)
+		if t := int64(binary.LittleEndian.Uint64(rec[8:])); t == 0 {
+			delete(times, id)
+		} else {
+			times[id] = t
+		}
+	}
+
+	for id := range times {
+		if _, ok := c.onDisk[id]; !ok {
+			delete(times, id)
+		}
+	}
+
+	l.times = times
+	if c.sharedReader {
+		return nil
+	}
+
+	// other writers sharing the workdir may be appending to the log
+	if !c.sharedWriter && (records > 2*len(times) || len(data)%timeRecordSize != 0) {
+		buf := make([]byte, 0, len(times)*timeRecordSize)
+		for id, t := range times {
+			buf = appendIDTime(buf, id, t)
+		}
+
+		if _, err := writeData(path, c.fileMode(), c.durability != NoSync, buf); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, c.fileMode())
+	if err != nil {
+		return err
+	}
+
+	l.f = f
+	return nil
+}
+
+// records the time of an element. The time is kept in memory if the log
+// can't be written to, and lost when the store is closed
+func (l *timeLog) set(id uint64, t time.Time) {
+	l.Lock()
+	defer l.Unlock()
+	l.times[id] = t.UnixNano()
+	if l.f != nil {
+		l.f.Write(appendIDTime(nil, id, t.UnixNano()))
+	}
+}
+
+func (l *timeLog) forget(id uint64) {
+	l.Lock()
+	defer l.Unlock()
+	if _, ok := l.times[id]; !ok {
+		return
+	}
+
+	delete(l.times, id)
+	if l.f != nil {
+		l.f.Write(appendIDTime(nil, id, 0))
+	}
+}
+
+func (l *timeLog) get(id uint64) (time.Time, bool) {
+	l.Lock()
+	defer l.Unlock()
+	t, ok := l.times[id]
+	if !ok {
+		return time.Time{}, false
+	}
+
+	return time.Unix(0, t), true
+}
+
+func (l *timeLog) close() {
+	l.Lock()
+	defer l.Unlock()
+	if l.f != nil {
+		l.f.Close()
+		l.f = nil
+	}
+}
+
+// Returns the metadata of an element. The metadata of an alias is that of
+// its target. Read counts are only kept with WithAccessTracking
+//
+// Returns ErrDoesNotExist if the ID is not recognized
+func (c *ElementStore) Info(id uint64) (ElementInfo, error) {
+	c.storeMutex.RLock()
+	if target, ok := c.aliases[id]; ok {
+		id = target
+	}
+
+	c.storeMutex.RUnlock()
+	info, ok := c.elementInfo(id)
+	if !ok {
+		return info, ErrDoesNotExist
+	}
+
+	return info, nil
+}
+
+// returns the metadata of an element other than an alias, and false if
+// there's no such element or it has expired
+func (c *ElementStore) elementInfo(id uint64) (ElementInfo, bool) {
+	c.storeMutex.RLock()
+	if c.expired(id) {
+		c.storeMutex.RUnlock()
+		return ElementInfo{}, false
+	}
+
+	var info ElementInfo
+	size, onDisk := c.onDisk[id]
+	if pw, ok := c.inTransfer[id]; ok {
+		info.Size = int64(len(pw.elem))
+	} else if onDisk {
+		info.Size, info.OnDisk = size, true
+	} else {
+		c.storeMutex.RUnlock()
+		return info, false
+	}
+
+	c.storeMutex.RUnlock()
+
+	_, info.Cached = c.inMemIDMap.Load(id)
+	info.Reads = c.accessOf(id).reads
+	if info.OnDisk {
+		info.ModTime = c.modTime(id)
+	}
+
+	if t, ok := c.inserted.get(id); ok {
+		info.Inserted = t
+	} else {
+		info.Inserted = info.ModTime
+	}
+
+	info.Expires, _ = c.expiries.get(id)
+	return info, true
+}
+
+// returns the insertion time of an element, or its modification time if
+// it was written before insertion times were recorded, or by another
+// writer sharing the workdir
+func (c *ElementStore) insertedOrModified(id uint64) time.Time {
+	if t, ok := c.inserted.get(id); ok {
+		return t
+	}
+
+	return c.modTime(id)
+}
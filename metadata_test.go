@@ -0,0 +1,60 @@
+package elstore
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInfo(t *testing.T) {
+	c, err := NewElementStore(1, testDir, WithAccessTracking())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	before := time.Now()
+	if err := c.Put(testData2, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Put(testData2, 2)
+	c.Alias(3, 1)
+	c.Sync()
+	c.Get(1)
+	c.waitAdmissions()
+
+	info, err := c.Info(3)
+	if err != nil {
+		t.Fatal(err)
+	} else if info.Size != int64(len(testData2)) || !info.OnDisk || !info.Cached ||
+		info.Reads != 1 || info.Inserted.Before(before) || info.ModTime.IsZero() {
+		t.Fatal("unexpected info", info)
+	}
+
+	if err := c.Delete(2); err != nil {
+		t.Fatal(err)
+	} else if _, err := c.Info(2); err != ErrDoesNotExist {
+		t.Fatal("expected ErrDoesNotExist, got", err)
+	}
+
+	// insertion times survive a restart, unlike read counts
+	c.Sync()
+	c.Close()
+	c, err = NewElementStore(1, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	reopened, err := c.Info(1)
+	if err != nil {
+		t.Fatal(err)
+	} else if !reopened.Inserted.Equal(info.Inserted) || reopened.Reads != 0 ||
+		reopened.Cached {
+		t.Fatal("unexpected info after restart", reopened)
+	}
+
+	if _, ok := c.inserted.get(2); ok {
+		t.Fatal("insertion time of deleted element loaded")
+	}
+}
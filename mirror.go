@@ -0,0 +1,374 @@
+package elstore
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A Backend receives copies of inserted elements. *ElementStore implements
+// Backend, so a store can be mirrored to another store
+type Backend interface {
+	Put(elem []byte, id uint64) error
+}
+
+// A ContextBackend is a Backend that is passed the context of PutCtx, e.g.
+// to carry tracing metadata to a remote backend. *ElementStore implements
+// ContextBackend
+type ContextBackend interface {
+	Backend
+	PutCtx(ctx context.Context, elem []byte, id uint64) error
+}
+
+// Write statistics for a mirror
+type MirrorStatus struct {
+	Writes   uint64 // successful writes, and deletes of remote storage
+	Failures uint64 // failed writes and deletes
+	LastErr  error  // most recent write error, if any
+	Queued   int    // elements waiting to be retried, see WithMirrorRetry
+	Reads    uint64 // elements read from remote storage
+
+	// IDs of superseded elements whose earlier payload the mirror kept, as
+	// it refused to replace it. They are not retried
+	Diverged []uint64
+}
+
+type mirror struct {
+	backend Backend
+	queue   string // path of the retry queue file, if retrying
+	mode    os.FileMode
+	sent    uint64 // bytes sent to the backend, accessed atomically
+
+	mu      sync.Mutex
+	status  MirrorStatus
+	pending map[uint64]mirrorOp      // failed operations to retry
+	last    map[uint64]chan struct{} // latest operation on each ID, see begin
+}
+
+// an operation on a mirror
+type mirrorOp int
+
+const (
+	opPut mirrorOp = iota
+	opSupersede
+	opDelete // of remote storage, see WithRemoteStorage
+)
+
+// mark supersedes and deletes in retry queue files, which list one hex ID
+// per line
+const (
+	queuedSupersede = "supersede"
+	queuedDelete    = "delete"
+)
+
+// implemented by backends able to replace elements not yet written
+type superseder interface {
+	PutSupersede(elem []byte, id uint64) error
+}
+
+func (m *mirror) send(ctx context.Context, elem []byte, id uint64, supersede bool) error {
+	atomic.AddUint64(&m.sent, uint64(len(elem)))
+	var err error
+	if s, ok := m.backend.(superseder); ok && supersede {
+		err = s.PutSupersede(elem, id)
+	} else if cb, ok := m.backend.(ContextBackend); ok {
+		err = cb.PutCtx(ctx, elem, id)
+	} else {
+		err = m.backend.Put(elem, id)
+	}
+
+	if err == ErrAlreadyExists && !supersede {
+		// the mirror already has it, which is what we want
+		return nil
+	} else if err == ErrAlreadyExists {
+		return fmt.Errorf("mirror kept the superseded payload of %x: %w", id, err)
+	}
+
+	return err
+}
+
+// orders the operations on an ID, so that e.g. a delete isn't overtaken
+// by a later write of the same ID. Returns a channel to wait on before
+// starting the operation, nil if there's nothing to wait for, and a
+// channel to pass to 'end' when done
+func (m *mirror) begin(id uint64) (prev <-chan struct{}, done chan struct{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.last == nil {
+		m.last = make(map[uint64]chan struct{})
+	}
+
+	prev, done = m.last[id], make(chan struct{})
+	m.last[id] = done
+	return prev, done
+}
+
+func (m *mirror) end(id uint64, done chan struct{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	close(done)
+	if m.last[id] == done {
+		delete(m.last, id)
+	}
+}
+
+func (m *mirror) put(ctx context.Context, elem []byte, id uint64, supersede bool) {
+	op := opPut
+	if supersede {
+		op = opSupersede
+	}
+
+	m.record(id, m.send(ctx, elem, id, supersede), op)
+}
+
+// updates the status of the mirror after an operation, queueing failed
+// ones for a retry. A refused supersede won't succeed on a retry, so it's
+// reported as a divergence instead
+func (m *mirror) record(id uint64, err error, op mirrorOp) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	diverged := op == opSupersede && errors.Is(err, ErrAlreadyExists)
+	if err != nil {
+		m.status.Failures++
+		m.status.LastErr = err
+	} else {
+		m.status.Writes++
+	}
+
+	if diverged {
+		m.diverged(id)
+	}
+
+	if m.queue == "" {
+		return
+	}
+
+	// a queued supersede is only settled by a successful supersede, or
+	// given up on if refused. Deletes and writes settle each other, as the
+	// latest one is what the mirror should end up with
+	prev, queued := m.pending[id]
+	switch {
+	case diverged && queued:
+		delete(m.pending, id)
+	case diverged:
+		return
+	case err != nil && (!queued || prev == opDelete || op == opDelete ||
+		op == opSupersede && prev == opPut):
+		m.pending[id] = op
+	case err == nil && queued && (prev == opDelete || op == opDelete ||
+		op == opSupersede || prev == opPut):
+		delete(m.pending, id)
+	default:
+		return
+	}
+
+	m.status.Queued = len(m.pending)
+	if err := m.saveQueue(); err != nil {
+		m.status.LastErr = err
+	}
+}
+
+// XXX: Assumes m.mu is held
+func (m *mirror) diverged(id uint64) {
+	for _, other := range m.status.Diverged {
+		if other == id {
+			return
+		}
+	}
+
+	m.status.Diverged = append(m.status.Diverged, id)
+}
+
+// XXX: Assumes m.mu is held
+func (m *mirror) saveQueue() error {
+	var b strings.Builder
+	for id, op := range m.pending {
+		switch op {
+		case opSupersede:
+			fmt.Fprintf(&b, "%x %s\n", id, queuedSupersede)
+		case opDelete:
+			fmt.Fprintf(&b, "%x %s\n", id, queuedDelete)
+		default:
+			fmt.Fprintf(&b, "%x\n", id)
+		}
+	}
+
+	tmp := m.queue + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(b.String()), m.mode); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, m.queue)
+}
+
+func (m *mirror) loadQueue() error {
+	f, err := os.Open(m.queue)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	defer f.Close()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		} else if id, err := strconv.ParseUint(fields[0], 16, 64); err == nil {
+			m.pending[id] = opPut
+			if len(fields) > 1 && fields[1] == queuedSupersede {
+				m.pending[id] = opSupersede
+			} else if len(fields) > 1 && fields[1] == queuedDelete {
+				m.pending[id] = opDelete
+			}
+		}
+	}
+
+	m.status.Queued = len(m.pending)
+	return scanner.Err()
+}
+
+// Duplicates every inserted element to 'backends' asynchronously. Mirror
+// write errors do not affect the store itself; they are tracked per mirror
+// and reported by MirrorStatus. Sync waits for mirror writes as well
+func WithMirrors(backends ...Backend) Option {
+	return option(func(c *ElementStore) {
+		for _, b := range backends {
+			c.mirrors = append(c.mirrors, &mirror{backend: b})
+		}
+	})
+}
+
+// Keeps track of failed mirror writes in the workdir and retries them every
+// 'interval' until they succeed, also after the store is reopened. Queues
+// are kept per mirror position in WithMirrors, so mirrors should be given
+// in the same order every time the store is opened
+func WithMirrorRetry(interval time.Duration) Option {
+	return option(func(c *ElementStore) {
+		c.mirrorRetry = interval
+	})
+}
+
+// loads the retry queues of the mirrors and starts retrying
+func (c *ElementStore) startMirrorRetry() error {
+	if c.mirrorRetry <= 0 || len(c.mirrors) == 0 {
+		return nil
+	}
+
+	for i, m := range c.mirrors {
+		m.queue = filepath.Join(c.workdir, fmt.Sprintf(".mirror-%d.queue", i))
+		m.mode = c.fileMode()
+		m.pending = make(map[uint64]mirrorOp)
+		if err := m.loadQueue(); err != nil {
+			return err
+		}
+	}
+
+	c.schedule("mirror-retry", c.mirrorRetry, func() {
+		for _, m := range c.mirrors {
+			c.retryMirror(m)
+		}
+	})
+
+	return nil
+}
+
+// retries the queued operations of a mirror, reading the elements to write
+// back from the store. IDs that fail stay queued for the next round
+func (c *ElementStore) retryMirror(m *mirror) {
+	m.mu.Lock()
+	ids := make([]uint64, 0, len(m.pending))
+	ops := make(map[uint64]mirrorOp, len(m.pending))
+	for id, op := range m.pending {
+		ids = append(ids, id)
+		ops[id] = op
+	}
+
+	m.mu.Unlock()
+	sortIDs(ids)
+	for _, id := range ids {
+		prev, done := m.begin(id)
+		if prev != nil {
+			<-prev
+		}
+
+		c.retryMirrorOp(m, id, ops[id])
+		m.end(id, done)
+	}
+}
+
+func (c *ElementStore) retryMirrorOp(m *mirror, id uint64, op mirrorOp) {
+	if op == opDelete {
+		m.record(id, m.remove(id), op)
+		return
+	}
+
+	el, err := c.GetWith(id, GetOpts{NoCache: true})
+	if err == ErrDoesNotExist {
+		// cancelled; nothing to mirror
+		m.record(id, nil, op)
+		return
+	} else if err != nil {
+		return
+	}
+
+	c.ioThrottle.wait(len(el), 1)
+	m.record(id, m.send(context.Background(), el, id, op == opSupersede), op)
+}
+
+// writes an element to all mirrors in the background
+func (c *ElementStore) mirror(ctx context.Context, elem []byte, id uint64) {
+	c.mirrorWrite(ctx, elem, id, false)
+}
+
+func (c *ElementStore) mirrorSupersede(elem []byte, id uint64) {
+	c.mirrorWrite(context.Background(), elem, id, true)
+}
+
+func (c *ElementStore) mirrorWrite(ctx context.Context, elem []byte, id uint64, supersede bool) {
+	if len(c.mirrors) == 0 {
+		return
+	}
+
+	// the writes outlive the caller's context
+	ctx = context.WithoutCancel(ctx)
+	for _, m := range c.mirrors {
+		c.activeWrites.Add(1)
+		prev, done := m.begin(id)
+		go func(m *mirror) {
+			defer c.activeWrites.Done()
+			if prev != nil {
+				<-prev
+			}
+
+			m.put(ctx, elem, id, supersede)
+			m.end(id, done)
+		}(m)
+	}
+}
+
+// Returns the write status of each mirror, in the order they were given
+// to WithMirrors, followed by the remote storage of WithRemoteStorage
+func (c *ElementStore) MirrorStatus() []MirrorStatus {
+	ret := make([]MirrorStatus, len(c.mirrors))
+	for i, m := range c.mirrors {
+		m.mu.Lock()
+		ret[i] = m.status
+		ret[i].Diverged = append([]uint64(nil), m.status.Diverged...)
+		m.mu.Unlock()
+	}
+
+	return ret
+}
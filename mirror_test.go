@@ -0,0 +1,274 @@
+package elstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+type failingBackend struct{}
+
+func (failingBackend) Put(elem []byte, id uint64) error {
+	return errors.New("mirror unreachable")
+}
+
+func TestMirrors(t *testing.T) {
+	m, err := NewElementStore(0, testDir+"-mirror")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer m.Remove()
+	c, err := NewElementStore(0, testDir, WithMirrors(m, failingBackend{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	if err := c.Put(testData2, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Sync()
+	data, err := m.Get(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(data, testData2) {
+		t.Fatalf("expected\n%v\n\ngot\n%v\n\n", testData2, data)
+	}
+
+	status := c.MirrorStatus()
+	if status[0].Writes != 1 || status[0].Failures != 0 {
+		t.Fatal("unexpected status for working mirror", status[0])
+	}
+
+	if status[1].Writes != 0 || status[1].Failures != 1 || status[1].LastErr == nil {
+		t.Fatal("unexpected status for failing mirror", status[1])
+	}
+
+	if err := c.WriteError(); err != nil {
+		t.Fatal("mirror failure affected store:", err)
+	}
+}
+
+type ctxKey struct{}
+
+type contextBackend struct {
+	got chan interface{}
+}
+
+func (b contextBackend) Put(elem []byte, id uint64) error {
+	return b.PutCtx(context.Background(), elem, id)
+}
+
+func (b contextBackend) PutCtx(ctx context.Context, elem []byte, id uint64) error {
+	b.got <- ctx.Value(ctxKey{})
+	return ctx.Err()
+}
+
+func TestMirrorContext(t *testing.T) {
+	b := contextBackend{make(chan interface{}, 1)}
+	c, err := NewElementStore(0, testDir, WithMirrors(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(),
+		ctxKey{}, "trace-id"))
+	if err := c.PutCtx(ctx, testData2, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	cancel()
+	c.Sync()
+	if v := <-b.got; v != "trace-id" {
+		t.Fatal("context value not passed to mirror, got", v)
+	}
+
+	if status := c.MirrorStatus(); status[0].Failures != 0 {
+		t.Fatal("mirror write bound by caller's cancellation", status[0].LastErr)
+	}
+}
+
+type flakyBackend struct {
+	mu   sync.Mutex
+	up   bool
+	puts map[uint64][]byte
+}
+
+func (b *flakyBackend) Put(elem []byte, id uint64) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.up {
+		return errors.New("mirror unreachable")
+	}
+
+	b.puts[id] = elem
+	return nil
+}
+
+func (b *flakyBackend) setUp(up bool) {
+	b.mu.Lock()
+	b.up = up
+	b.mu.Unlock()
+}
+
+func TestMirrorRetry(t *testing.T) {
+	b := &flakyBackend{puts: make(map[uint64][]byte)}
+	c, err := NewElementStore(0, testDir, WithMirrors(b),
+		WithMirrorRetry(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	if err := c.Put(testData2, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Sync()
+	if status := c.MirrorStatus(); status[0].Queued != 1 {
+		t.Fatal("failed write not queued", status[0])
+	}
+
+	// the queue survives a restart
+	c.Close()
+	c, err = NewElementStore(0, testDir, WithMirrors(b),
+		WithMirrorRetry(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	if status := c.MirrorStatus(); status[0].Queued != 1 {
+		t.Fatal("queue not loaded", status[0])
+	}
+
+	b.setUp(true)
+	for i := 0; c.MirrorStatus()[0].Queued != 0; i++ {
+		if i == 100 {
+			t.Fatal("queue not drained", c.MirrorStatus()[0])
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !bytes.Equal(b.puts[1], testData2) {
+		t.Fatalf("expected\n%v\n\ngot\n%v\n\n", testData2, b.puts[1])
+	}
+}
+
+// a mirror that stored the original payload and can't replace it
+type immutableBackend struct {
+	mu         sync.Mutex
+	supersedes int
+}
+
+func (b *immutableBackend) Put(elem []byte, id uint64) error {
+	return nil
+}
+
+func (b *immutableBackend) PutSupersede(elem []byte, id uint64) error {
+	b.mu.Lock()
+	b.supersedes++
+	b.mu.Unlock()
+	return ErrAlreadyExists
+}
+
+func TestMirrorSupersedeRefused(t *testing.T) {
+	b := &immutableBackend{}
+	c, err := NewElementStore(0, testDir, WithMirrors(b),
+		WithMirrorRetry(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	if err := c.Put(testData, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// superseded after the mirror got the original
+	c.mirrorSupersede(testData2, 1)
+	c.Sync()
+	status := c.MirrorStatus()[0]
+	if status.Failures != 1 || status.Queued != 0 ||
+		!errors.Is(status.LastErr, ErrAlreadyExists) ||
+		!reflect.DeepEqual(status.Diverged, []uint64{1}) {
+		t.Fatal("refused supersede not reported", status)
+	}
+
+	// not retried
+	c.retryMirror(c.mirrors[0])
+	b.mu.Lock()
+	supersedes := b.supersedes
+	b.mu.Unlock()
+	if supersedes != 1 {
+		t.Fatal("refused supersede retried", supersedes)
+	}
+}
+
+// a mirror refusing the elements of some IDs
+type pickyBackend struct {
+	mu      sync.Mutex
+	refused map[uint64]bool
+	puts    map[uint64][]byte
+}
+
+func (b *pickyBackend) Put(elem []byte, id uint64) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.refused[id] {
+		return errors.New("refused")
+	}
+
+	b.puts[id] = elem
+	return nil
+}
+
+func TestMirrorRetryContinues(t *testing.T) {
+	b := &pickyBackend{refused: map[uint64]bool{1: true, 2: true},
+		puts: make(map[uint64][]byte)}
+	c, err := NewElementStore(0, testDir, WithMirrors(b),
+		WithMirrorRetry(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	for id := uint64(1); id <= 2; id++ {
+		if err := c.Put(testData2, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c.Sync()
+
+	// 1 still fails, which doesn't hold up 2
+	b.mu.Lock()
+	delete(b.refused, 2)
+	b.mu.Unlock()
+	c.retryMirror(c.mirrors[0])
+	m := c.mirrors[0]
+	m.mu.Lock()
+	_, queued := m.pending[1]
+	m.mu.Unlock()
+	if status := c.MirrorStatus()[0]; status.Queued != 1 || !queued {
+		t.Fatal("unexpected queue", status)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !bytes.Equal(b.puts[2], testData2) {
+		t.Fatalf("expected\n%v\n\ngot\n%v\n\n", testData2, b.puts[2])
+	}
+}
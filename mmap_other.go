@@ -0,0 +1,17 @@
+//go:build !unix
+
+package elstore
+
+import (
+	"io/ioutil"
+)
+
+// reads a file into memory where mmap isn't available
+func mapFile(path string) ([]byte, func() error, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return data, func() error { return nil }, nil
+}
@@ -0,0 +1,59 @@
+package elstore
+
+import (
+	"errors"
+	"sync"
+)
+
+var ErrUnknownStore = errors.New("No store registered under that name")
+
+// Configuration of a named store, as passed to NewElementStore
+type StoreConfig struct {
+	MaxInMem int
+	Workdir  string
+	Options  []Option
+}
+
+var namedStores = struct {
+	sync.Mutex
+	configs map[string]StoreConfig
+	open    map[string]*ElementStore
+}{
+	configs: make(map[string]StoreConfig),
+	open:    make(map[string]*ElementStore),
+}
+
+// Registers the configuration of a store under 'name', so that it can be
+// opened with Open. Registering a name again replaces its configuration
+// for subsequent opens
+func Register(name string, cfg StoreConfig) {
+	namedStores.Lock()
+	defer namedStores.Unlock()
+	namedStores.configs[name] = cfg
+}
+
+// Returns the store registered under 'name', opening it on first use.
+// Later calls return the same store until it's removed
+//
+// Returns ErrUnknownStore if no store is registered under 'name'
+func Open(name string) (*ElementStore, error) {
+	namedStores.Lock()
+	defer namedStores.Unlock()
+
+	if c, ok := namedStores.open[name]; ok && !c.isShutdown() {
+		return c, nil
+	}
+
+	cfg, ok := namedStores.configs[name]
+	if !ok {
+		return nil, ErrUnknownStore
+	}
+
+	c, err := NewElementStore(cfg.MaxInMem, cfg.Workdir, cfg.Options...)
+	if err != nil {
+		return nil, err
+	}
+
+	namedStores.open[name] = c
+	return c, nil
+}
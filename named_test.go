@@ -0,0 +1,36 @@
+package elstore
+
+import (
+	"testing"
+)
+
+func TestOpenNamed(t *testing.T) {
+	if _, err := Open("unknown"); err != ErrUnknownStore {
+		t.Fatal("expected ErrUnknownStore, got", err)
+	}
+
+	Register("test", StoreConfig{MaxInMem: 1, Workdir: testDir})
+	c, err := Open("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	if other, err := Open("test"); err != nil || other != c {
+		t.Fatal("expected the same store, got", other, err)
+	}
+
+	if err := c.Remove(); err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := Open("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer other.Remove()
+	if other == c {
+		t.Fatal("removed store returned")
+	}
+}
@@ -0,0 +1,195 @@
+package elstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Element files are named by the hex ID of the element, e.g. "2a". With
+// WithFileNaming the ID is wrapped in a prefix and a suffix, and optionally
+// zero-padded to 16 digits, e.g. "el-000000000000002a.el". Alias, marker,
+// tombstone and temporary files add their own suffix to the name of the
+// element file
+//
+// The naming is recorded in namingFile in the workdir. Files named the
+// default way or by the recorded naming are recognized whatever the
+// naming, and are renamed when the store is opened, so that the naming of
+// an existing store can be changed. A file is never renamed over another
+type fileNaming struct {
+	prefix, suffix string
+	padded         bool
+}
+
+const namingFile = ".naming"
+
+// the form of a naming in namingFile
+type namingRecord struct {
+	Prefix string `json:"prefix"`
+	Suffix string `json:"suffix"`
+	Padded bool   `json:"padded"`
+}
+
+// suffixes the store adds to the names of element files
+var reservedSuffixes = []string{tmpSuffix, aliasSuffix, markerSuffix, tombstoneSuffix}
+
+// Names element files '<prefix><hex ID><suffix>', with the ID zero-padded
+// to 16 digits if 'padded' is set, so that external tools can match them
+// with a glob pattern such as "*.el". Existing files named the default way,
+// or by the naming the store had before, are renamed when the store is
+// opened. Stores opened WithSharedReader don't rename files, and must be
+// opened after the writer. WithFileNaming("", "", false) renames the files
+// back to the default naming
+//
+// Once used, the option must be given every time the store is opened, as
+// other stores and tools sharing the workdir may not know the naming. A
+// store opened without it keeps using the naming recorded in the workdir
+//
+// The prefix and suffix may not contain path separators or consist of hex
+// digits only, the prefix may not start with a dot and the suffix may not
+// end with a suffix used by the store, such as ".tmp"
+func WithFileNaming(prefix, suffix string, padded bool) Option {
+	invalid := func(reason string) Option {
+		return Option{err: fmt.Errorf("%w: file naming %q, %q: %s",
+			ErrInvalidOption, prefix, suffix, reason)}
+	}
+
+	for _, affix := range []string{prefix, suffix} {
+		if strings.ContainsAny(affix, `/\`) || strings.ContainsRune(affix, os.PathSeparator) {
+			return invalid("path separator")
+		} else if affix != "" && strings.Trim(affix, "0123456789abcdefABCDEF") == "" {
+			return invalid("hex digits only")
+		}
+	}
+
+	if strings.HasPrefix(prefix, ".") {
+		return invalid("hidden files")
+	}
+
+	for _, reserved := range reservedSuffixes {
+		if strings.HasSuffix(suffix, reserved) {
+			return invalid("reserved suffix")
+		}
+	}
+
+	return option(func(c *ElementStore) {
+		c.naming = fileNaming{prefix, suffix, padded}
+		c.namingSet = true
+	})
+}
+
+// returns the name of the file of an element
+func (n fileNaming) name(id uint64) string {
+	if n.padded {
+		return fmt.Sprintf("%s%016x%s", n.prefix, id, n.suffix)
+	}
+
+	return n.prefix + strconv.FormatUint(id, 16) + n.suffix
+}
+
+// returns the ID of an element file name, named either by 'n' or the
+// default way
+func (n fileNaming) parse(name string) (uint64, bool) {
+	if id, err := strconv.ParseUint(name, 16, 64); err == nil {
+		return id, true
+	}
+
+	if n.prefix == "" && n.suffix == "" ||
+		len(name) <= len(n.prefix)+len(n.suffix) ||
+		!strings.HasPrefix(name, n.prefix) || !strings.HasSuffix(name, n.suffix) {
+		return 0, false
+	}
+
+	id, err := strconv.ParseUint(name[len(n.prefix):len(name)-len(n.suffix)], 16, 64)
+	return id, err == nil
+}
+
+// returns the ID of an element file name, named the default way, by the
+// naming of the store or by the naming recorded in the workdir
+func (c *ElementStore) parseName(name string) (uint64, bool) {
+	if id, ok := c.naming.parse(name); ok {
+		return id, true
+	}
+
+	return c.prevNaming.parse(name)
+}
+
+// returns the ID of the name of an element file with 'suffix' added
+func (c *ElementStore) parseSuffixed(name, suffix string) (uint64, bool) {
+	if !strings.HasSuffix(name, suffix) {
+		return 0, false
+	}
+
+	return c.parseName(strings.TrimSuffix(name, suffix))
+}
+
+// reads the naming recorded in the workdir, which is used unless
+// WithFileNaming is given
+func (c *ElementStore) loadNaming() error {
+	data, err := ioutil.ReadFile(filepath.Join(c.workdir, namingFile))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var r namingRecord
+	if err := json.Unmarshal(data, &r); err != nil {
+		return fmt.Errorf("%s: %w", namingFile, err)
+	}
+
+	c.prevNaming = fileNaming{r.Prefix, r.Suffix, r.Padded}
+	if !c.namingSet {
+		c.naming = c.prevNaming
+	}
+
+	return nil
+}
+
+// records the naming of the store in the workdir once its files have been
+// renamed, removing the record for the default naming
+func (c *ElementStore) recordNaming() error {
+	if c.sharedReader || c.naming == c.prevNaming {
+		return nil
+	}
+
+	path := filepath.Join(c.workdir, namingFile)
+	if c.naming == (fileNaming{}) {
+		return os.Remove(path)
+	}
+
+	data, err := json.Marshal(namingRecord{c.naming.prefix, c.naming.suffix, c.naming.padded})
+	if err != nil {
+		return err
+	}
+
+	_, err = writeData(path, c.fileMode(), true, data)
+	return err
+}
+
+// renames a file of an element, with 'suffix' added to its name, to the
+// naming of the store if it's named another way. Files are not renamed
+// over existing ones. Returns the path of the file
+func (c *ElementStore) renameLegacy(path string, id uint64, suffix string) string {
+	name := c.naming.name(id) + suffix
+	if c.sharedReader || filepath.Base(path) == name {
+		return path
+	}
+
+	renamed := filepath.Join(filepath.Dir(path), name)
+	if _, err := os.Lstat(renamed); err == nil {
+		c.openReport.Conflicts = append(c.openReport.Conflicts, path)
+		return path
+	}
+
+	if err := os.Rename(path, renamed); err != nil {
+		return path
+	}
+
+	c.openReport.Renamed++
+	return renamed
+}
@@ -0,0 +1,152 @@
+package elstore
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileNaming(t *testing.T) {
+	c, err := NewElementStore(0, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	if err := c.Put(testData2, 0x2a); err != nil {
+		t.Fatal(err)
+	} else if err := c.Alias(0x2b, 0x2a); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Sync()
+	c.Close()
+
+	// files named the default way are renamed
+	opt := WithFileNaming("el-", ".el", true)
+	c, err = NewElementStore(0, testDir, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	if r := c.OpenReport(); r.Renamed != 2 || r.Elements != 1 || r.Aliases != 1 {
+		t.Fatal("unexpected open report", r)
+	}
+
+	if err := c.Put(testData2, 0x2c); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Sync()
+	for id, name := range map[uint64]string{
+		0x2a: "el-000000000000002a.el",
+		0x2b: "el-000000000000002b.el" + aliasSuffix,
+		0x2c: "el-000000000000002c.el",
+	} {
+		if _, err := os.Stat(filepath.Join(elDir(testDir, id), name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c.Close()
+	c, err = NewElementStore(0, testDir, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	if r := c.OpenReport(); r.Renamed != 0 || r.Elements != 2 || r.Aliases != 1 {
+		t.Fatal("unexpected open report", r)
+	}
+
+	for _, id := range []uint64{0x2a, 0x2b, 0x2c} {
+		data, err := c.Get(id)
+		if err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(data, testData2) {
+			t.Fatalf("expected\n%v\n\ngot\n%v\n\n", testData2, data)
+		}
+	}
+}
+
+func TestFileNamingRecorded(t *testing.T) {
+	opt := WithFileNaming("el-", ".el", false)
+	c, err := NewElementStore(0, testDir, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	if err := c.Put(testData, 0x2a); err != nil {
+		t.Fatal(err)
+	}
+
+	// the naming is kept when the option is dropped
+	c.Close()
+	c, err = NewElementStore(0, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	if !c.Has(0x2a) {
+		t.Fatal("element named by the recorded naming not found")
+	} else if err := c.Put(testData2, 0x2a); err != ErrAlreadyExists {
+		t.Fatal("expected ErrAlreadyExists, got", err)
+	}
+
+	// a default-named copy with other content is not renamed over the
+	// element
+	named := filepath.Join(elDir(testDir, 0x2a), "el-2a.el")
+	c.Close()
+	if err := os.WriteFile(elFile(testDir, 0x2a), []byte("junk"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err = NewElementStore(0, testDir, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	if r := c.OpenReport(); len(r.Conflicts) != 1 || r.Conflicts[0] != elFile(testDir, 0x2a) {
+		t.Fatal("expected a conflict, got", r.Conflicts)
+	} else if data, err := c.Get(0x2a); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(data, testData) {
+		t.Fatalf("expected\n%v\n\ngot\n%v\n\n", testData, data)
+	}
+
+	// back to the default naming
+	c.Close()
+	if err := os.Remove(elFile(testDir, 0x2a)); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err = NewElementStore(0, testDir, WithFileNaming("", "", false))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	if r := c.OpenReport(); r.Renamed != 1 {
+		t.Fatal("expected the element to be renamed, got", r.Renamed)
+	} else if _, err := os.Stat(named); !os.IsNotExist(err) {
+		t.Fatal("file not renamed", err)
+	} else if _, err := os.Stat(filepath.Join(testDir, namingFile)); !os.IsNotExist(err) {
+		t.Fatal("naming record not removed", err)
+	}
+}
+
+func TestFileNamingInvalid(t *testing.T) {
+	for _, affixes := range [][2]string{
+		{"a/", ""}, {"", "ab"}, {".el-", ""}, {"", ".el.tmp"}, {"", ".alias"},
+	} {
+		if _, err := NewElementStore(0, testDir,
+			WithFileNaming(affixes[0], affixes[1], false)); err == nil {
+			t.Fatal("expected error for", affixes)
+		}
+	}
+}
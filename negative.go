@@ -0,0 +1,79 @@
+package elstore
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// IDs found missing by a shared reader, so that repeated lookups of the
+// same missing ID don't stat the workdir each time. Entries are kept in two
+// generations, and a generation is dropped once all its entries have
+// expired, which bounds the memory used to the IDs probed in two TTLs
+type negativeCache struct {
+	mu      sync.Mutex
+	cur     map[uint64]int64 // ID to expiry, in Unix nanoseconds
+	prev    map[uint64]int64
+	rotated int64
+}
+
+// Makes a store opened WithSharedReader remember IDs it didn't find on
+// disk for 'ttl', so that Has and Get return ErrDoesNotExist for them
+// without looking again. Elements written in the meantime are missed until
+// the entry expires, while WaitForID keeps looking. Zero, the default,
+// disables the cache. This is a runtime option
+func WithNegativeCache(ttl time.Duration) Option {
+	if ttl < 0 {
+		return Option{err: fmt.Errorf("%w: negative cache TTL %v", ErrInvalidOption, ttl)}
+	}
+
+	return runtimeOption(func(c *ElementStore) {
+		atomic.StoreInt64(&c.negativeTTL, int64(ttl))
+		c.negative.mu.Lock()
+		c.negative.cur, c.negative.prev = nil, nil
+		c.negative.mu.Unlock()
+	})
+}
+
+func (c *ElementStore) negativeCacheTTL() time.Duration {
+	return time.Duration(atomic.LoadInt64(&c.negativeTTL))
+}
+
+// like discover, consulting and updating the negative cache
+func (c *ElementStore) discoverCached(id uint64) bool {
+	ttl := c.negativeCacheTTL()
+	if ttl <= 0 {
+		return c.discover(id)
+	}
+
+	n := &c.negative
+	now := time.Now().UnixNano()
+	n.mu.Lock()
+	if now-n.rotated >= int64(ttl) {
+		n.prev, n.cur, n.rotated = n.cur, make(map[uint64]int64), now
+	}
+
+	expiry, ok := n.cur[id]
+	if !ok {
+		expiry, ok = n.prev[id]
+	}
+
+	n.mu.Unlock()
+	if ok && now < expiry {
+		atomic.AddUint64(&c.negativeHits, 1)
+		return false
+	}
+
+	if c.discover(id) {
+		return true
+	}
+
+	n.mu.Lock()
+	if n.cur != nil {
+		n.cur[id] = now + int64(ttl)
+	}
+
+	n.mu.Unlock()
+	return false
+}
@@ -0,0 +1,69 @@
+package elstore
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestNegativeCache(t *testing.T) {
+	if _, err := NewElementStore(0, testDir, WithNegativeCache(-time.Second)); err == nil {
+		t.Fatal("expected error for negative TTL")
+	}
+
+	w, err := NewElementStore(0, testDir, WithSharedWriter())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer w.Remove()
+	r, err := NewElementStore(0, testDir, WithSharedReader(),
+		WithNegativeCache(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer r.Close()
+	if r.Has(1) {
+		t.Fatal("unexpected element")
+	}
+
+	w.Put(testData2, 1)
+	w.Sync()
+
+	// remembered as missing
+	if r.Has(1) {
+		t.Fatal("negative cache not consulted by Has")
+	} else if _, err := r.Get(1); err != ErrDoesNotExist {
+		t.Fatal("expected ErrDoesNotExist, got", err)
+	} else if hits := r.Stats().NegativeCacheHits; hits != 2 {
+		t.Fatal("expected 2 negative cache hits, got", hits)
+	}
+
+	// WaitForID bypasses the cache
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := r.WaitForID(ctx, 1); err != nil {
+		t.Fatal(err)
+	} else if !r.Has(1) {
+		t.Fatal("element not found after WaitForID")
+	}
+
+	// changing the TTL clears the cache
+	r.Has(2)
+	w.Put(testData2, 2)
+	w.Sync()
+	if err := r.ApplyOptions(WithNegativeCache(10 * time.Millisecond)); err != nil {
+		t.Fatal(err)
+	} else if !r.Has(2) {
+		t.Fatal("negative cache not cleared")
+	}
+
+	r.Has(3)
+	w.Put(testData2, 3)
+	w.Sync()
+	time.Sleep(20 * time.Millisecond)
+	if !r.Has(3) {
+		t.Fatal("negative cache entry not expired")
+	}
+}
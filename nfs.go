@@ -0,0 +1,61 @@
+package elstore
+
+import (
+	"os"
+	"time"
+)
+
+// number of times an operation failing with ESTALE is retried in NFS-safe
+// mode, and the initial delay between attempts
+const (
+	nfsStaleRetries = 3
+	nfsStaleBackoff = 10 * time.Millisecond
+)
+
+// Enables behaviour needed when the workdir is on NFS:
+//
+//   - operations failing with ESTALE are retried with a backoff
+//   - marker files of WithSharedWriter are created with O_EXCL
+//   - existence checks of WithSharedReader open files instead of relying on
+//     stat, which may be answered from a stale attribute cache
+func WithNFSSafe() Option {
+	return option(func(c *ElementStore) {
+		c.nfsSafe = true
+	})
+}
+
+// runs fn, retrying it on ESTALE in NFS-safe mode
+func (c *ElementStore) retryStale(fn func() error) error {
+	err := fn()
+	backoff := nfsStaleBackoff
+	for i := 0; c.nfsSafe && i < nfsStaleRetries && isStale(err); i++ {
+		time.Sleep(backoff)
+		backoff *= 2
+		err = fn()
+	}
+
+	return err
+}
+
+// returns the file info of 'path' and true if it's an existing regular file
+func (c *ElementStore) statFile(path string) (os.FileInfo, bool) {
+	if !c.nfsSafe {
+		fi, err := os.Stat(path)
+		return fi, err == nil && fi.Mode().IsRegular()
+	}
+
+	// close-to-open consistency: opening revalidates the attribute cache
+	var fi os.FileInfo
+	err := c.retryStale(func() error {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+
+		defer f.Close()
+		fi, err = f.Stat()
+		return err
+	})
+
+	return fi, err == nil && fi.Mode().IsRegular()
+}
@@ -0,0 +1,6 @@
+package elstore
+
+// Plan 9 has no ESTALE
+func isStale(err error) bool {
+	return false
+}
@@ -0,0 +1,12 @@
+//go:build !plan9
+
+package elstore
+
+import (
+	"errors"
+	"syscall"
+)
+
+func isStale(err error) bool {
+	return errors.Is(err, syscall.ESTALE)
+}
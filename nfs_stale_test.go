@@ -0,0 +1,36 @@
+//go:build !plan9
+
+package elstore
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestRetryStale(t *testing.T) {
+	c := &ElementStore{nfsSafe: true}
+	calls := 0
+	err := c.retryStale(func() error {
+		calls++
+		if calls < 3 {
+			return syscall.ESTALE
+		}
+
+		return nil
+	})
+
+	if err != nil || calls != 3 {
+		t.Fatal("unexpected result", err, calls)
+	}
+
+	c.nfsSafe = false
+	calls = 0
+	err = c.retryStale(func() error {
+		calls++
+		return syscall.ESTALE
+	})
+
+	if err != syscall.ESTALE || calls != 1 {
+		t.Fatal("retried outside of NFS-safe mode", err, calls)
+	}
+}
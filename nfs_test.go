@@ -0,0 +1,37 @@
+package elstore
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestNFSSafeSharedWorkdir(t *testing.T) {
+	w, err := NewElementStore(0, testDir, WithSharedWriter(), WithNFSSafe())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer w.Remove()
+	r, err := NewElementStore(0, testDir, WithSharedReader(), WithNFSSafe())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Put(testData2, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	w.Sync()
+	data, err := r.Get(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(data, testData2) {
+		t.Fatalf("expected\n%v\n\ngot\n%v\n\n", testData2, data)
+	}
+
+	if r.Has(2) {
+		t.Fatal("reader found nonexistent element")
+	}
+}
@@ -0,0 +1,267 @@
+package elstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+var ErrInvalidOption = errors.New("Invalid option value")
+var ErrStartupOption = errors.New("Option can only be set when creating the store")
+
+// An Option configures an ElementStore at creation time. Options documented
+// as runtime options can also be applied to an open store with ApplyOptions
+type Option struct {
+	apply   func(*ElementStore)
+	runtime bool
+	err     error // set if the option was given an invalid value
+}
+
+func option(apply func(*ElementStore)) Option {
+	return Option{apply: apply}
+}
+
+func runtimeOption(apply func(*ElementStore)) Option {
+	return Option{apply: apply, runtime: true}
+}
+
+// Describes a disk operation that exceeded the slow operation threshold
+type SlowOp struct {
+	Op       string // "read", "write" or "sync"
+	ID       uint64 // element ID, zero for "sync"
+	Duration time.Duration
+	Ctx      context.Context // context of GetCtx for "read", otherwise empty
+}
+
+// Invokes 'handler' whenever a disk read, write or sync takes longer than
+// 'threshold'. If 'handler' is nil, slow operations are logged using the
+// standard logger
+//
+// The handler is called from the goroutine performing the operation and
+// should not block. This is a runtime option
+func WithSlowOpThreshold(threshold time.Duration, handler func(SlowOp)) Option {
+	if handler == nil {
+		handler = func(op SlowOp) {
+			log.Printf("elstore: slow %s of element %x took %v", op.Op, op.ID,
+				op.Duration)
+		}
+	}
+
+	return runtimeOption(func(c *ElementStore) {
+		c.slowOp.Store(&slowOpConfig{threshold, handler})
+	})
+}
+
+type slowOpConfig struct {
+	threshold time.Duration
+	handler   func(SlowOp)
+}
+
+func (c *ElementStore) checkSlowOp(op string, id uint64, start time.Time) {
+	c.checkSlowOpCtx(context.Background(), op, id, start)
+}
+
+func (c *ElementStore) checkSlowOpCtx(ctx context.Context, op string, id uint64, start time.Time) {
+	cfg, _ := c.slowOp.Load().(*slowOpConfig)
+	if cfg == nil {
+		return
+	}
+
+	if d := time.Since(start); d > cfg.threshold {
+		cfg.handler(SlowOp{Op: op, ID: id, Duration: d, Ctx: ctx})
+	}
+}
+
+// Aborts disk reads that take longer than 'timeout', returning
+// ErrReadTimeout and marking the store as Degraded until a disk read
+// succeeds again. The default is to wait indefinitely. This is a runtime
+// option
+func WithReadTimeout(timeout time.Duration) Option {
+	return runtimeOption(func(c *ElementStore) {
+		atomic.StoreInt64(&c.readTimeout, int64(timeout))
+	})
+}
+
+func (c *ElementStore) loadReadTimeout() time.Duration {
+	return time.Duration(atomic.LoadInt64(&c.readTimeout))
+}
+
+// Keeps at most 'size' elements in memory, overriding the size given to
+// NewElementStore. Shrinking the cache of an open store evicts elements as
+// chosen by the cache policy. This is a runtime option
+func WithCacheSize(size int) Option {
+	if size < 0 {
+		return Option{err: fmt.Errorf("%w: cache size %v", ErrInvalidOption, size)}
+	}
+
+	return runtimeOption(func(c *ElementStore) {
+		atomic.StoreInt64(&c.maxInMem, int64(size))
+	})
+}
+
+func (c *ElementStore) cacheSize() int {
+	return int(atomic.LoadInt64(&c.maxInMem))
+}
+
+// Keeps at most 'size' bytes of elements in memory, in addition to the
+// element count given to NewElementStore. Elements are evicted as chosen by
+// the cache policy until a new element fits, and elements larger than
+// 'size' are never cached. Zero removes the limit. This is a runtime option
+//
+// To bound the cache by bytes alone, give NewElementStore a large element
+// count
+func WithMaxCacheBytes(size int64) Option {
+	if size < 0 {
+		return Option{err: fmt.Errorf("%w: cache bytes %v", ErrInvalidOption, size)}
+	}
+
+	return runtimeOption(func(c *ElementStore) {
+		atomic.StoreInt64(&c.maxCacheBytes, size)
+	})
+}
+
+func (c *ElementStore) cacheByteLimit() int64 {
+	return atomic.LoadInt64(&c.maxCacheBytes)
+}
+
+// Selects the policy deciding which elements to keep in memory, e.g.
+// NewLRUPolicy() for workloads where recently read elements are likely to
+// be read again. The default is NewLFUPolicy()
+func WithCachePolicy(policy CachePolicy) Option {
+	if policy == nil {
+		return Option{err: fmt.Errorf("%w: nil cache policy", ErrInvalidOption)}
+	}
+
+	return option(func(c *ElementStore) {
+		c.inMem = policy
+	})
+}
+
+// Describes a setting changed by ApplyOptions
+type ConfigChange struct {
+	// named as in OptionsFromJSON, plus "cache_size",
+	// "background_bytes_per_sec", "background_iops" and
+	// "negative_cache_ttl"
+	Setting  string
+	Old, New string
+}
+
+// Invokes 'handler' for every setting changed by ApplyOptions, after the
+// change has taken effect
+func WithConfigChangeHandler(handler func(ConfigChange)) Option {
+	return option(func(c *ElementStore) {
+		c.configChangeHandler = handler
+	})
+}
+
+// returns the settings that can be changed by runtime options
+func (c *ElementStore) runtimeSettings() map[string]string {
+	threshold := "off"
+	if cfg, _ := c.slowOp.Load().(*slowOpConfig); cfg != nil {
+		threshold = cfg.threshold.String()
+	}
+
+	bytesPerSec, iops := c.ioThrottle.limits()
+	return map[string]string{
+		"cache_size":               strconv.Itoa(c.cacheSize()),
+		"cache_bytes":              strconv.FormatInt(c.cacheByteLimit(), 10),
+		"slow_op_threshold":        threshold,
+		"read_timeout":             c.loadReadTimeout().String(),
+		"background_bytes_per_sec": bytesPerSec,
+		"background_iops":          iops,
+		"negative_cache_ttl":       c.negativeCacheTTL().String(),
+	}
+}
+
+// Changes the configuration of an open store. All options are validated
+// before any of them is applied
+//
+// Returns ErrInvalidOption if an option was given an invalid value, and
+// ErrStartupOption if an option is not a runtime option
+func (c *ElementStore) ApplyOptions(opts ...Option) error {
+	for _, opt := range opts {
+		if opt.err != nil {
+			return opt.err
+		} else if !opt.runtime {
+			return ErrStartupOption
+		}
+	}
+
+	c.storeMutex.Lock()
+	before := c.runtimeSettings()
+	for _, opt := range opts {
+		opt.apply(c)
+	}
+
+	c.shrinkCache()
+	after := c.runtimeSettings()
+	c.storeMutex.Unlock()
+
+	if c.configChangeHandler == nil {
+		return nil
+	}
+
+	settings := make([]string, 0, len(after))
+	for setting := range after {
+		settings = append(settings, setting)
+	}
+
+	sort.Strings(settings)
+	for _, setting := range settings {
+		if before[setting] != after[setting] {
+			c.configChangeHandler(ConfigChange{
+				Setting: setting,
+				Old:     before[setting],
+				New:     after[setting],
+			})
+		}
+	}
+
+	return nil
+}
+
+// Opens a circuit breaker around the disk layer after 'threshold'
+// consecutive failed disk operations. While open, reads that would hit the
+// disk fail fast with ErrUnavailable, while elements in memory are still
+// served. Every 'probeInterval' a single read is let through to probe for
+// recovery, and the circuit closes again once a disk operation succeeds
+func WithCircuitBreaker(threshold int, probeInterval time.Duration) Option {
+	return option(func(c *ElementStore) {
+		if threshold < 1 {
+			threshold = 1
+		}
+
+		c.breaker = &circuitBreaker{
+			threshold: threshold,
+			interval:  probeInterval,
+		}
+	})
+}
+
+// Keeps a warm standby copy of all elements in 'dir', preferably on another
+// disk. Writes go to both directories. If the workdir errors, reads and
+// writes transparently fail over to the standby and the store reports
+// itself as Degraded. If the standby errors, it is no longer written to
+func WithStandby(dir string) Option {
+	return option(func(c *ElementStore) {
+		c.standby = dir
+	})
+}
+
+// Stages element writes in 'dir', e.g. on a fast local disk when the
+// workdir is on slow network storage. Elements are first written to the
+// staging directory, after which they no longer occupy memory, and are then
+// moved into the workdir. Sync waits until elements have been moved
+//
+// Elements left in the staging directory by a previous instance are moved
+// into the workdir when the store is opened
+func WithStagingDir(dir string) Option {
+	return option(func(c *ElementStore) {
+		c.staging = dir
+	})
+}
@@ -0,0 +1,103 @@
+package elstore
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSlowOpThreshold(t *testing.T) {
+	var mu sync.Mutex
+	ops := map[string]uint64{}
+	handler := func(op SlowOp) {
+		mu.Lock()
+		ops[op.Op] = op.ID
+		mu.Unlock()
+	}
+
+	c, err := NewElementStore(0, testDir, WithSlowOpThreshold(-1, handler))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	if err := c.Put(testData2, 0x29a); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Sync()
+	if _, err := c.Get(0x29a); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, op := range []string{"read", "write", "sync"} {
+		if _, ok := ops[op]; !ok {
+			t.Fatal("no slow op reported for", op)
+		}
+	}
+
+	if ops["read"] != 0x29a || ops["write"] != 0x29a {
+		t.Fatal("unexpected IDs in slow ops", ops)
+	}
+}
+
+func TestApplyOptions(t *testing.T) {
+	var changes []ConfigChange
+	c, err := NewElementStore(3, testDir, WithConfigChangeHandler(func(ch ConfigChange) {
+		changes = append(changes, ch)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	for id := uint64(1); id <= 3; id++ {
+		c.Put(testData2, id)
+	}
+
+	c.Sync()
+	for id := uint64(1); id <= 3; id++ {
+		for i := uint64(0); i < id; i++ {
+			c.Get(id)
+		}
+	}
+
+	c.waitAdmissions()
+	if c.inMem.Len() != 3 {
+		t.Fatal("elements not cached")
+	}
+
+	if err := c.ApplyOptions(WithCacheSize(1), WithReadTimeout(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := c.inMemIDMap.Load(uint64(3)); c.inMem.Len() != 1 || !ok {
+		t.Fatal("cache not shrunk to the most read element")
+	}
+
+	expected := []ConfigChange{
+		{Setting: "cache_size", Old: "3", New: "1"},
+		{Setting: "read_timeout", Old: "0s", New: "1s"},
+	}
+
+	if !reflect.DeepEqual(changes, expected) {
+		t.Fatal("unexpected changes", changes)
+	}
+
+	if err := c.ApplyOptions(WithStandby(testDir + "-standby")); err != ErrStartupOption {
+		t.Fatal("expected ErrStartupOption, got", err)
+	}
+
+	err = c.ApplyOptions(WithReadTimeout(time.Minute), WithCacheSize(-1))
+	if !errors.Is(err, ErrInvalidOption) {
+		t.Fatal("expected ErrInvalidOption, got", err)
+	}
+
+	if c.loadReadTimeout() != time.Second {
+		t.Fatal("options applied despite an invalid one")
+	}
+}
@@ -0,0 +1,56 @@
+package elstore
+
+import (
+	"fmt"
+	"os"
+)
+
+// Permissions of the directories and files created by the store, unless
+// set by WithDirMode and WithFileMode
+const (
+	defaultDirMode  os.FileMode = 0700
+	defaultFileMode os.FileMode = 0600
+)
+
+// Sets the permissions of the directories created by the store, e.g. 0750
+// to let a backup agent in the owner's group read the workdir. The process
+// umask applies, as for os.MkdirAll. The owner must keep full access.
+// Existing directories are left as they are
+func WithDirMode(mode os.FileMode) Option {
+	if mode&^os.ModePerm != 0 || mode&0700 != 0700 {
+		return Option{err: fmt.Errorf("%w: directory mode %v", ErrInvalidOption, mode)}
+	}
+
+	return option(func(c *ElementStore) {
+		c.dirPerm = mode
+	})
+}
+
+// Sets the permissions of the files created by the store, e.g. 0640. The
+// process umask applies, as for os.OpenFile. The owner must keep read and
+// write access. Existing files keep their permissions until rewritten
+func WithFileMode(mode os.FileMode) Option {
+	if mode&^os.ModePerm != 0 || mode&0600 != 0600 {
+		return Option{err: fmt.Errorf("%w: file mode %v", ErrInvalidOption, mode)}
+	}
+
+	return option(func(c *ElementStore) {
+		c.filePerm = mode
+	})
+}
+
+func (c *ElementStore) dirMode() os.FileMode {
+	if c.dirPerm == 0 {
+		return defaultDirMode
+	}
+
+	return c.dirPerm
+}
+
+func (c *ElementStore) fileMode() os.FileMode {
+	if c.filePerm == 0 {
+		return defaultFileMode
+	}
+
+	return c.filePerm
+}
@@ -0,0 +1,47 @@
+//go:build unix
+
+package elstore
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestPermissions(t *testing.T) {
+	for _, opt := range []Option{WithDirMode(0600), WithDirMode(os.ModeDir | 0700),
+		WithFileMode(0400)} {
+		if _, err := NewElementStore(0, testDir, opt); err == nil {
+			t.Fatal("expected error for invalid mode")
+		}
+	}
+
+	defer syscall.Umask(syscall.Umask(0))
+	c, err := NewElementStore(0, testDir, WithDirMode(0750), WithFileMode(0640))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	c.Put(testData2, 1)
+	c.Sync()
+	if err := c.SetWarmupList([]uint64{1}); err != nil {
+		t.Fatal(err)
+	}
+
+	for path, want := range map[string]os.FileMode{
+		testDir:                              0750,
+		elDir(testDir, 1):                    0750,
+		elFile(testDir, 1):                   0640,
+		filepath.Join(testDir, warmupFile):   0640,
+		filepath.Join(testDir, insertedFile): 0640,
+	} {
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		} else if fi.Mode().Perm() != want {
+			t.Fatalf("%v: expected mode %v, got %v", path, want, fi.Mode().Perm())
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package elstore
+
+// Calls 'fn' for each element in the store in ascending ID order, until
+// 'fn' returns false. Cached elements and elements in transfer are served
+// from memory, others are read from disk without being cached or counted
+// as read. Aliases are visited with the element they refer to
+//
+// Elements inserted during the call may or may not be visited, and
+// elements deleted during the call are skipped. Returns the first read
+// error, if any
+func (c *ElementStore) Range(fn func(id uint64, el []byte) bool) error {
+	for _, id := range c.IDs() {
+		el, err := c.rangeGet(id)
+		if err == ErrDoesNotExist {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		if !fn(id, el) {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+func (c *ElementStore) rangeGet(id uint64) ([]byte, error) {
+	if el, ok := c.inMemIDMap.Load(id); ok {
+		return el.([]byte), nil
+	}
+
+	return c.GetWith(id, GetOpts{NoCache: true})
+}
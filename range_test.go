@@ -0,0 +1,67 @@
+package elstore
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRange(t *testing.T) {
+	c, err := NewElementStore(1, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	for id := uint64(1); id <= 3; id++ {
+		if err := c.Put(bytes.Repeat([]byte{byte(id)}, 10), id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c.Sync()
+	c.Get(2)
+	c.waitAdmissions()
+	if err := c.Alias(4, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// 5 is still in transfer
+	c.inTransfer[5] = &pendingWrite{elem: []byte{5}}
+	defer delete(c.inTransfer, 5)
+
+	var ids []uint64
+	err = c.Range(func(id uint64, el []byte) bool {
+		expected := id
+		if id == 4 {
+			expected = 1
+		}
+
+		if el[0] != byte(expected) {
+			t.Fatalf("element %v: unexpected content %v", id, el)
+		}
+
+		ids = append(ids, id)
+		return true
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	} else if len(ids) != 5 || ids[0] != 1 || ids[4] != 5 {
+		t.Fatal("unexpected IDs", ids)
+	}
+
+	// the scan leaves the cache alone
+	if _, ok := c.inMemIDMap.Load(uint64(2)); !ok || c.inMem.Len() != 1 {
+		t.Fatal("cache changed by Range")
+	}
+
+	ids = nil
+	c.Range(func(id uint64, el []byte) bool {
+		ids = append(ids, id)
+		return len(ids) < 2
+	})
+
+	if len(ids) != 2 {
+		t.Fatal("Range not stopped", ids)
+	}
+}
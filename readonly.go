@@ -0,0 +1,26 @@
+package elstore
+
+import (
+	"sync/atomic"
+)
+
+// Switches the store into read-only mode and back, e.g. during a backup
+// window or a migration. While read-only, calls that would change the
+// store, such as Put, Alias, Delete and CompactSegments, return
+// ErrReadOnly. Writes and deletes accepted before the switch are still
+// carried out; Sync waits for them
+//
+// A store opened as a shared reader stays read-only
+func (c *ElementStore) SetReadOnly(readOnly bool) {
+	var v int32
+	if readOnly {
+		v = 1
+	}
+
+	atomic.StoreInt32(&c.readOnly, v)
+}
+
+// Returns true if the store rejects changes, see SetReadOnly
+func (c *ElementStore) IsReadOnly() bool {
+	return c.sharedReader || atomic.LoadInt32(&c.readOnly) != 0
+}
@@ -0,0 +1,41 @@
+package elstore
+
+import (
+	"testing"
+)
+
+func TestSetReadOnly(t *testing.T) {
+	c, err := NewElementStore(0, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	if err := c.Put(testData2, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	c.SetReadOnly(true)
+	if !c.IsReadOnly() {
+		t.Fatal("expected store to be read-only")
+	}
+
+	if err := c.Put(testData2, 2); err != ErrReadOnly {
+		t.Fatal("expected ErrReadOnly for Put, got", err)
+	} else if err := c.Delete(1); err != ErrReadOnly {
+		t.Fatal("expected ErrReadOnly for Delete, got", err)
+	} else if err := c.Alias(3, 1); err != ErrReadOnly {
+		t.Fatal("expected ErrReadOnly for Alias, got", err)
+	}
+
+	if _, err := c.Get(1); err != nil {
+		t.Fatal(err)
+	}
+
+	c.SetReadOnly(false)
+	if err := c.Put(testData2, 2); err != nil {
+		t.Fatal(err)
+	} else if err := c.Delete(1); err != nil {
+		t.Fatal(err)
+	}
+}
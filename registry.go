@@ -0,0 +1,79 @@
+package elstore
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+)
+
+var ErrWorkdirInUse = errors.New("Workdir in use by another store in this process")
+
+// workdirs of the stores opened by this process, so that two stores never
+// share bookkeeping of the same workdir. Stores in shared mode are made to
+// coexist and are not registered. Registered workdirs are also locked
+// against other processes
+var openStores = struct {
+	sync.Mutex
+	m map[string]*ElementStore
+}{m: make(map[string]*ElementStore)}
+
+// returns the registry key of a workdir, which must exist
+func workdirKey(workdir string) (string, error) {
+	abs, err := filepath.Abs(workdir)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.EvalSymlinks(abs)
+}
+
+func (c *ElementStore) register() error {
+	if c.sharedReader || c.sharedWriter {
+		return nil
+	}
+
+	key, err := workdirKey(c.workdir)
+	if err != nil {
+		return err
+	}
+
+	openStores.Lock()
+	defer openStores.Unlock()
+	if _, ok := openStores.m[key]; ok {
+		return ErrWorkdirInUse
+	} else if err := c.lockWorkdir(); err != nil {
+		return err
+	}
+
+	openStores.m[key] = c
+	c.registryKey = key
+	return nil
+}
+
+// releases the workdir of the store for other stores
+func (c *ElementStore) unregister() {
+	if c.registryKey == "" {
+		return
+	}
+
+	c.unlockWorkdir()
+
+	openStores.Lock()
+	defer openStores.Unlock()
+	if openStores.m[c.registryKey] == c {
+		delete(openStores.m, c.registryKey)
+	}
+}
+
+// stops the background goroutines of the store and releases its workdir,
+// leaving the files in place. Writes must be synced first
+func (c *ElementStore) release() error {
+	var err error
+	if c.indexDirs != nil && !c.isShutdown() {
+		err = c.writeIndexes(false)
+	}
+
+	c.shutdown()
+	c.unregister()
+	return err
+}
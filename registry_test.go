@@ -0,0 +1,41 @@
+package elstore
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestWorkdirInUse(t *testing.T) {
+	c, err := NewElementStore(0, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	for _, dir := range []string{testDir, "./" + testDir, filepath.Join(testDir, "x", "..")} {
+		if _, err := NewElementStore(0, dir); err != ErrWorkdirInUse {
+			t.Fatal("expected ErrWorkdirInUse for", dir, "got", err)
+		}
+	}
+
+	if err := c.Remove(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err = NewElementStore(0, testDir)
+	if err != nil {
+		t.Fatal("workdir not released by Remove:", err)
+	}
+
+	defer c.Remove()
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err = NewElementStore(0, testDir)
+	if err != nil {
+		t.Fatal("workdir not released by Close:", err)
+	}
+
+	defer c.Remove()
+}
@@ -0,0 +1,117 @@
+package elstore
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+// Remote storage holding the elements of a store, e.g. an object store.
+// *S3Backend implements RemoteBackend
+type RemoteBackend interface {
+	ContextBackend
+
+	// Returns ErrDoesNotExist if the backend has no element of the ID
+	GetCtx(ctx context.Context, id uint64) ([]byte, error)
+
+	// Removing an element that doesn't exist is not an error
+	Delete(id uint64) error
+
+	// Returns the IDs of the elements held by the backend, with their sizes
+	List(ctx context.Context) (map[uint64]int64, error)
+}
+
+// Keeps the elements of the store in 'backend', with the workdir as a local
+// copy. Inserted elements are written to the backend in the background, as
+// to a mirror, and deletes, including evictions and expiries, are passed on.
+// The write status of the backend is the last entry of MirrorStatus, and
+// failed writes and deletes are retried given WithMirrorRetry
+//
+// The elements held by the backend are listed when the store is opened.
+// Those missing from the workdir, e.g. on a host without a persistent
+// volume, are read from the backend on a cache miss
+func WithRemoteStorage(backend RemoteBackend) Option {
+	if backend == nil {
+		return Option{err: fmt.Errorf("%w: nil remote storage", ErrInvalidOption)}
+	}
+
+	return option(func(c *ElementStore) {
+		c.remote = &mirror{backend: backend}
+	})
+}
+
+func (m *mirror) remove(id uint64) error {
+	return m.backend.(RemoteBackend).Delete(id)
+}
+
+// adds the elements held by the remote storage to the store, except those
+// with a queued delete
+func (c *ElementStore) loadRemote() error {
+	if c.remote == nil {
+		return nil
+	}
+
+	listed, err := c.remote.backend.(RemoteBackend).List(context.Background())
+	if err != nil {
+		return fmt.Errorf("listing remote storage: %w", err)
+	}
+
+	c.storeMutex.Lock()
+	defer c.storeMutex.Unlock()
+	c.remote.mu.Lock()
+	defer c.remote.mu.Unlock()
+	for id, size := range listed {
+		if op, ok := c.remote.pending[id]; ok && op == opDelete {
+			continue
+		} else if !c.has(id) {
+			c.onDisk[id] = size
+		}
+	}
+
+	return nil
+}
+
+// reads an element missing from the workdir from the remote storage,
+// unless it was deleted while reading. 'err' is the error of the local read
+func (c *ElementStore) readRemote(ctx context.Context, id uint64, err error) ([]byte, error) {
+	c.storeMutex.RLock()
+	_, ok := c.onDisk[id]
+	c.storeMutex.RUnlock()
+	if !ok {
+		return nil, err
+	}
+
+	el, err := c.remote.backend.(RemoteBackend).GetCtx(ctx, id)
+	if err == ErrDoesNotExist {
+		return nil, os.ErrNotExist
+	} else if err != nil {
+		return nil, err
+	}
+
+	c.remote.mu.Lock()
+	c.remote.status.Reads++
+	c.remote.mu.Unlock()
+	return el, nil
+}
+
+// deletes an element from the remote storage in the background, after the
+// writes of it already started
+//
+// XXX: Assumes a storeMutex-lock is held
+func (c *ElementStore) deleteRemote(id uint64) {
+	if c.remote == nil {
+		return
+	}
+
+	c.activeWrites.Add(1)
+	prev, done := c.remote.begin(id)
+	go func() {
+		defer c.activeWrites.Done()
+		if prev != nil {
+			<-prev
+		}
+
+		c.remote.record(id, c.remote.remove(id), opDelete)
+		c.remote.end(id, done)
+	}()
+}
@@ -0,0 +1,214 @@
+package elstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRemoteStorage(t *testing.T) {
+	fake := &fakeS3{objects: make(map[string][]byte)}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	b, err := NewS3Backend(S3Config{Endpoint: srv.URL, Region: "eu-north-1",
+		Bucket: "bucket", Prefix: "els/", AccessKey: "AK", SecretKey: "SK"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := NewElementStore(0, testDir, WithRemoteStorage(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for id := uint64(1); id <= 3; id++ {
+		if err := c.Put(testData2, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c.Sync()
+
+	// a new host, without the workdir
+	if err := c.Remove(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err = NewElementStore(0, testDir, WithRemoteStorage(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	if !c.Has(2) {
+		t.Fatal("element of remote storage not listed")
+	}
+
+	if data, err := c.Get(2); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(data, testData2) {
+		t.Fatalf("expected\n%v\n\ngot\n%v\n\n", testData2, data)
+	}
+
+	if status := c.MirrorStatus(); status[0].Reads != 1 {
+		t.Fatal("expected one remote read, got", status[0])
+	}
+
+	if err := c.Delete(1); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Sync()
+	if _, ok := fake.objects["/bucket/els/1"]; ok {
+		t.Fatal("delete not passed on to remote storage")
+	}
+
+	if _, err := c.Get(1); err != ErrDoesNotExist {
+		t.Fatal("expected ErrDoesNotExist, got", err)
+	}
+}
+
+var errRemoteDown = errors.New("remote down")
+
+// in-memory remote storage. Puts wait for 'gate' if set, and deletes fail
+// while 'down' is set
+type memRemote struct {
+	gate chan struct{}
+
+	mu      sync.Mutex
+	down    bool
+	objects map[uint64][]byte
+}
+
+func (r *memRemote) Put(elem []byte, id uint64) error {
+	return r.PutCtx(context.Background(), elem, id)
+}
+
+func (r *memRemote) PutCtx(ctx context.Context, elem []byte, id uint64) error {
+	if r.gate != nil {
+		<-r.gate
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.objects[id] = elem
+	return nil
+}
+
+func (r *memRemote) GetCtx(ctx context.Context, id uint64) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if elem, ok := r.objects[id]; ok {
+		return elem, nil
+	}
+
+	return nil, ErrDoesNotExist
+}
+
+func (r *memRemote) Delete(id uint64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.down {
+		return errRemoteDown
+	}
+
+	delete(r.objects, id)
+	return nil
+}
+
+func (r *memRemote) List(ctx context.Context) (map[uint64]int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ret := make(map[uint64]int64)
+	for id, elem := range r.objects {
+		ret[id] = int64(len(elem))
+	}
+
+	return ret, nil
+}
+
+func (r *memRemote) has(id uint64) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.objects[id]
+	return ok
+}
+
+func TestRemoteDeleteAfterWrite(t *testing.T) {
+	r := &memRemote{gate: make(chan struct{}), objects: make(map[uint64][]byte)}
+	c, err := NewElementStore(0, testDir, WithRemoteStorage(r))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	if err := c.Put(testData2, 1); err != nil {
+		t.Fatal(err)
+	} else if err := c.Delete(1); err != nil {
+		t.Fatal(err)
+	}
+
+	// the delete must not overtake the write it follows
+	close(r.gate)
+	c.Sync()
+	if r.has(1) {
+		t.Fatal("deleted element left in remote storage")
+	}
+}
+
+func TestRemoteDeleteRetry(t *testing.T) {
+	r := &memRemote{down: true, objects: make(map[uint64][]byte)}
+	c, err := NewElementStore(0, testDir, WithRemoteStorage(r),
+		WithMirrorRetry(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	if err := c.Put(testData2, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Sync()
+	if err := c.Delete(1); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Sync()
+	if status := c.MirrorStatus(); status[0].Queued != 1 {
+		t.Fatal("failed delete not queued", status[0])
+	}
+
+	// the element is still listed, but deleted
+	c.Close()
+	c, err = NewElementStore(0, testDir, WithRemoteStorage(r),
+		WithMirrorRetry(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	if c.Has(1) {
+		t.Fatal("element with a queued delete loaded from remote storage")
+	}
+
+	r.mu.Lock()
+	r.down = false
+	r.mu.Unlock()
+	for i := 0; c.MirrorStatus()[0].Queued != 0; i++ {
+		if i == 100 {
+			t.Fatal("queue not drained", c.MirrorStatus()[0])
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if r.has(1) {
+		t.Fatal("deleted element left in remote storage")
+	}
+}
@@ -0,0 +1,81 @@
+package elstore
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Returned by Remove if parts of the store could not be removed. The store
+// is left consistent with what remains on disk, and Remove can be called
+// again to retry
+type RemoveError struct {
+	Dirs     []string // directories that could not be removed
+	Elements []uint64 // IDs of elements left on disk, in ascending order
+	Err      error    // the first error encountered
+}
+
+func (e *RemoveError) Error() string {
+	return fmt.Sprintf("Store partially removed, %v elements left in %v: %v",
+		len(e.Elements), strings.Join(e.Dirs, ", "), e.Err)
+}
+
+func (e *RemoveError) Unwrap() error {
+	return e.Err
+}
+
+// removes the workdir, shard, standby and staging directories using
+// 'removeAll'. All directories are attempted even if one of them fails
+func (c *ElementStore) removeDirs(removeAll func(string) error) error {
+	rerr := &RemoveError{}
+	dirs := append([]string{c.standby, c.staging}, c.shards...)
+	for _, dir := range append(dirs, c.workdir) {
+		if dir == "" {
+			continue
+		}
+
+		if err := removeAll(dir); err != nil {
+			rerr.Dirs = append(rerr.Dirs, dir)
+			if rerr.Err == nil {
+				rerr.Err = err
+			}
+		}
+	}
+
+	if rerr.Err == nil {
+		return nil
+	}
+
+	survivors := make(map[uint64]struct{})
+	for _, dir := range rerr.Dirs {
+		filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err == nil && info.Mode()&os.ModeType == 0 {
+				if id, ok := c.parseName(info.Name()); ok {
+					survivors[id] = struct{}{}
+				}
+			}
+
+			return nil
+		})
+	}
+
+	c.storeMutex.Lock()
+	defer c.storeMutex.Unlock()
+
+	// forget elements that are gone, so that a retry or a Get doesn't
+	// trip over them. The cache is dropped as it may hold removed elements
+	for id := range c.onDisk {
+		if _, ok := survivors[id]; !ok {
+			delete(c.onDisk, id)
+		}
+	}
+
+	for id := range survivors {
+		rerr.Elements = append(rerr.Elements, id)
+	}
+
+	sortIDs(rerr.Elements)
+	c.dropCache()
+	return rerr
+}
@@ -0,0 +1,64 @@
+package elstore
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestPartialRemove(t *testing.T) {
+	c, err := NewElementStore(10, testDir, WithStandby(testDir+"-standby"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	for id := uint64(1); id <= 3; id++ {
+		if err := c.Put(testData2, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c.Sync()
+
+	// the workdir loses one element before failing
+	failure := errors.New("injected failure")
+	err = c.removeDirs(func(dir string) error {
+		if dir == testDir {
+			os.Remove(elFile(testDir, 1))
+			return failure
+		}
+
+		return os.RemoveAll(dir)
+	})
+
+	var rerr *RemoveError
+	if !errors.As(err, &rerr) || !errors.Is(err, failure) {
+		t.Fatal("expected a RemoveError, got", err)
+	}
+
+	if !reflect.DeepEqual(rerr.Dirs, []string{testDir}) {
+		t.Fatal("unexpected directories left", rerr.Dirs)
+	}
+
+	if !reflect.DeepEqual(rerr.Elements, []uint64{2, 3}) {
+		t.Fatal("unexpected elements left", rerr.Elements)
+	}
+
+	if c.Has(1) || !c.Has(2) {
+		t.Fatal("store not consistent with what's left on disk")
+	}
+
+	if _, err := os.Stat(testDir + "-standby"); !os.IsNotExist(err) {
+		t.Fatal("standby not removed", err)
+	}
+
+	if err := c.Remove(); err != nil {
+		t.Fatal("retry failed", err)
+	}
+
+	if _, err := os.Stat(testDir); !os.IsNotExist(err) {
+		t.Fatal("workdir not removed", err)
+	}
+}
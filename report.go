@@ -0,0 +1,106 @@
+package elstore
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Describes what NewElementStore found when opening the store. Element IDs
+// are found by walking the standby, and the subdirectories of the workdir
+// that changed since its index was written
+type OpenReport struct {
+	Duration   time.Duration // time spent in NewElementStore
+	Elements   int           // elements on disk
+	Aliases    int
+	Incomplete int // partially written elements that were dropped
+	Deletes    int // interrupted deletes that were finished
+	Staged     int // elements moved into the workdir from the staging directory
+	Indexed    int // subdirectories loaded from the index
+	Walked     int // subdirectories walked, the index being missing or stale
+	Renamed    int // files renamed to the naming of WithFileNaming
+
+	// Paths of alias files that could not be read, and of files that are
+	// not part of the store
+	Corrupt []string
+	Ignored []string
+
+	// Paths of files not renamed to the naming of WithFileNaming, as a
+	// file of the same element already has the new name
+	Conflicts []string
+
+	// Paths of segments with a damaged record. The elements after it are
+	// not read, and the segment is left as it is
+	Damaged []string
+}
+
+// Returns the report in the form shared by maintenance operations. Corrupt
+// alias files, naming conflicts and damaged segments are reported as
+// errors by path
+func (r OpenReport) Report() Report {
+	report := Report{
+		Operation: "open",
+		Duration:  r.Duration,
+		Elements:  r.Elements,
+	}
+
+	for _, path := range r.Corrupt {
+		if report.Errors == nil {
+			report.Errors = make(map[string]string)
+		}
+
+		report.Errors[path] = "Corrupt alias file"
+	}
+
+	for _, path := range r.Conflicts {
+		if report.Errors == nil {
+			report.Errors = make(map[string]string)
+		}
+
+		report.Errors[path] = "Not renamed, the new name is taken"
+	}
+
+	for _, path := range r.Damaged {
+		if report.Errors == nil {
+			report.Errors = make(map[string]string)
+		}
+
+		report.Errors[path] = "Damaged segment record, later records not read"
+	}
+
+	return report
+}
+
+// Returns the report of what was found when the store was opened
+func (c *ElementStore) OpenReport() OpenReport {
+	return c.openReport
+}
+
+// The outcome of a maintenance operation in a form shared by all of them,
+// e.g. for automation, as returned by the Report methods of VerifyReport,
+// CompactReport, DeleteReport and OpenReport. Reports marshal to JSON with
+// durations in nanoseconds
+type Report struct {
+	Operation string        `json:"operation"` // "verify", "compact", "delete" or "open"
+	DryRun    bool          `json:"dry_run,omitempty"`
+	Duration  time.Duration `json:"duration_ns"`
+	Elements  int           `json:"elements"` // elements checked, moved, deleted or found
+	Bytes     int64         `json:"bytes"`    // bytes read, reclaimed or deleted
+
+	// Errors by element ID in hex, or by path for files without an ID
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+func (r *Report) addError(id uint64, err error) {
+	if r.Errors == nil {
+		r.Errors = make(map[string]string)
+	}
+
+	r.Errors[strconv.FormatUint(id, 16)] = err.Error()
+}
+
+// returns true if a file of the workdir that isn't an element, alias,
+// marker or tombstone is known to the store, e.g. the owner file
+func isHousekeeping(name string) bool {
+	return strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".tmp")
+}
@@ -0,0 +1,94 @@
+package elstore
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestOpenReport(t *testing.T) {
+	c, err := NewElementStore(0, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	for id := uint64(1); id <= 3; id++ {
+		if err := c.Put(testData2, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := c.Alias(4, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Sync()
+	c.Close()
+
+	// an interrupted write of 2, an interrupted delete of 3, an unreadable
+	// alias and a file that doesn't belong to the store
+	files := map[string]string{
+		elFile(testDir, 2) + markerSuffix:    "",
+		elFile(testDir, 3) + tombstoneSuffix: "",
+		aliasFile(testDir, 5):                "not hex",
+		filepath.Join(testDir, "notes.txt"):  "",
+	}
+
+	for path, data := range files {
+		os.MkdirAll(filepath.Dir(path), 0700)
+		if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c, err = NewElementStore(0, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	r := c.OpenReport()
+	if r.Duration <= 0 {
+		t.Fatal("open duration not recorded")
+	}
+
+	r.Duration = 0
+	expected := OpenReport{
+		Elements:   1,
+		Aliases:    1,
+		Incomplete: 1,
+		Deletes:    1,
+		Walked:     5,
+		Corrupt:    []string{aliasFile(testDir, 5)},
+		Ignored:    []string{filepath.Join(testDir, "notes.txt")},
+	}
+
+	if !reflect.DeepEqual(r, expected) {
+		t.Fatalf("expected\n%+v\n\ngot\n%+v\n\n", expected, r)
+	}
+}
+
+func TestReportJSON(t *testing.T) {
+	r := VerifyReport{
+		Verified:   2,
+		Mismatched: []uint64{0xab},
+		Bytes:      100,
+		Duration:   time.Millisecond,
+	}
+
+	data, err := json.Marshal(r.Report())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"operation":"verify","duration_ns":1000000,"elements":3,` +
+		`"bytes":100,"errors":{"ab":"Element corrupted: checksum mismatch"}}`
+	if string(data) != expected {
+		t.Fatalf("expected\n%v\n\ngot\n%v\n\n", expected, string(data))
+	}
+}
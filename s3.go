@@ -0,0 +1,306 @@
+package elstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Location and credentials of a bucket in an S3 compatible object store
+type S3Config struct {
+	Endpoint  string // e.g. "https://s3.eu-north-1.amazonaws.com"
+	Region    string // e.g. "eu-north-1"
+	Bucket    string
+	Prefix    string // prepended to the names of objects, e.g. "elements/"
+	AccessKey string
+	SecretKey string
+
+	Client *http.Client // http.DefaultClient if nil
+}
+
+// A Backend storing elements as objects in an S3 compatible object store,
+// named by their IDs in hex and addressed path style. Requests are signed
+// with AWS Signature Version 4. S3Backend implements RemoteBackend, so it
+// can be used as a mirror or as the storage of a store, see
+// WithRemoteStorage
+type S3Backend struct {
+	cfg      S3Config
+	endpoint *url.URL
+}
+
+// Returns an S3Backend for the bucket described by 'cfg'
+func NewS3Backend(cfg S3Config) (*S3Backend, error) {
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	if endpoint.Scheme == "" || endpoint.Host == "" || cfg.Region == "" ||
+		cfg.Bucket == "" {
+		return nil, fmt.Errorf("%w: incomplete S3 configuration", ErrInvalidOption)
+	}
+
+	if cfg.Client == nil {
+		cfg.Client = http.DefaultClient
+	}
+
+	return &S3Backend{cfg: cfg, endpoint: endpoint}, nil
+}
+
+// Stores an element, replacing an object of the same ID
+func (b *S3Backend) Put(elem []byte, id uint64) error {
+	return b.PutCtx(context.Background(), elem, id)
+}
+
+// Like Put, with the request bound to 'ctx'
+func (b *S3Backend) PutCtx(ctx context.Context, elem []byte, id uint64) error {
+	_, err := b.do(ctx, http.MethodPut, id, elem)
+	return err
+}
+
+// Fetches an element
+//
+// Returns ErrDoesNotExist if there is no object for the ID
+func (b *S3Backend) Get(id uint64) ([]byte, error) {
+	return b.GetCtx(context.Background(), id)
+}
+
+// Like Get, with the request bound to 'ctx'
+func (b *S3Backend) GetCtx(ctx context.Context, id uint64) ([]byte, error) {
+	return b.do(ctx, http.MethodGet, id, nil)
+}
+
+// Removes an element. Removing an element that doesn't exist is not an
+// error, as in S3
+func (b *S3Backend) Delete(id uint64) error {
+	_, err := b.do(context.Background(), http.MethodDelete, id, nil)
+	return err
+}
+
+// the parts of a ListObjectsV2 response used by List
+type s3ListResult struct {
+	Contents []struct {
+		Key  string
+		Size int64
+	}
+
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+// Returns the IDs of the objects under the prefix, with their sizes.
+// Objects not named by an ID are skipped
+func (b *S3Backend) List(ctx context.Context) (map[uint64]int64, error) {
+	ret := make(map[uint64]int64)
+	params := map[string]string{"list-type": "2", "prefix": b.cfg.Prefix}
+	for {
+		u := *b.endpoint
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + b.cfg.Bucket
+		var query []string
+		for key, value := range params {
+			query = append(query, awsEscape(key)+"="+awsEscape(value))
+		}
+
+		sort.Strings(query)
+		u.RawQuery = strings.Join(query, "&")
+		data, resp, err := b.request(ctx, http.MethodGet, &u, nil)
+		if err != nil {
+			return nil, err
+		} else if resp.StatusCode/100 != 2 {
+			return nil, fmt.Errorf("S3 listing of %s: %s", b.cfg.Bucket, resp.Status)
+		}
+
+		var result s3ListResult
+		if err := xml.Unmarshal(data, &result); err != nil {
+			return nil, err
+		}
+
+		for _, obj := range result.Contents {
+			name := strings.TrimPrefix(obj.Key, b.cfg.Prefix)
+			if id, err := strconv.ParseUint(name, 16, 64); err == nil {
+				ret[id] = obj.Size
+			}
+		}
+
+		if !result.IsTruncated {
+			return ret, nil
+		}
+
+		params["continuation-token"] = result.NextContinuationToken
+	}
+}
+
+func (b *S3Backend) objectURL(id uint64) *url.URL {
+	u := *b.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + b.cfg.Bucket + "/" +
+		b.cfg.Prefix + strconv.FormatUint(id, 16)
+	return &u
+}
+
+// sends a signed request, returning the response along with its body
+func (b *S3Backend) request(ctx context.Context, method string, u *url.URL,
+	body []byte) ([]byte, *http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u.String(),
+		bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	b.sign(req, body, time.Now())
+	resp, err := b.cfg.Client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return data, resp, nil
+}
+
+func (b *S3Backend) do(ctx context.Context, method string, id uint64, body []byte) ([]byte, error) {
+	data, resp, err := b.request(ctx, method, b.objectURL(id), body)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound && method == http.MethodGet:
+		return nil, ErrDoesNotExist
+	case resp.StatusCode/100 != 2:
+		return nil, fmt.Errorf("S3 %s of element %x: %s", method, id, resp.Status)
+	}
+
+	return data, nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// signs a request with AWS Signature Version 4, signing the host, the
+// payload hash and the date
+func (b *S3Backend) sign(req *http.Request, body []byte, now time.Time) {
+	req.Header.Set("x-amz-content-sha256", sha256Hex(body))
+	signV4(req, sha256Hex(body), b.cfg.Region, "s3", b.cfg.AccessKey,
+		b.cfg.SecretKey, now)
+}
+
+// escapes a string as AWS Signature Version 4 expects, leaving only
+// unreserved characters as they are
+func awsEscape(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if 'A' <= ch && ch <= 'Z' || 'a' <= ch && ch <= 'z' ||
+			'0' <= ch && ch <= '9' || strings.IndexByte("-_.~", ch) >= 0 {
+			sb.WriteByte(ch)
+		} else {
+			fmt.Fprintf(&sb, "%%%02X", ch)
+		}
+	}
+
+	return sb.String()
+}
+
+// signs a request with AWS Signature Version 4 for 'service' in 'region',
+// as described in the AWS General Reference. The host, the date and every
+// header set on the request are signed. 'payloadHash' is the hex SHA-256 of
+// the body. The path is encoded once, as S3 expects
+func signV4(req *http.Request, payloadHash, region, service, accessKey,
+	secretKey string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("x-amz-date", amzDate)
+
+	segments := strings.Split(req.URL.Path, "/")
+	for i, segment := range segments {
+		segments[i] = awsEscape(segment)
+	}
+
+	path := strings.Join(segments, "/")
+	if path == "" {
+		path = "/"
+	}
+
+	var params []string
+	for key, values := range req.URL.Query() {
+		for _, value := range values {
+			params = append(params, awsEscape(key)+"="+awsEscape(value))
+		}
+	}
+
+	sort.Strings(params)
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+
+	headers := map[string]string{"host": host}
+	for name, values := range req.Header {
+		trimmed := make([]string, len(values))
+		for i, value := range values {
+			trimmed[i] = strings.Join(strings.Fields(value), " ")
+		}
+
+		headers[strings.ToLower(name)] = strings.Join(trimmed, ",")
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		if name != "authorization" {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+
+	signedHeaders := strings.Join(names, ";")
+	canonical := strings.Join([]string{
+		req.Method,
+		path,
+		strings.Join(params, "&"),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" +
+		sha256Hex([]byte(canonical))
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders,
+		hex.EncodeToString(hmacSHA256(key, toSign))))
+}
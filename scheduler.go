@@ -0,0 +1,136 @@
+package elstore
+
+import (
+	"sync"
+	"time"
+)
+
+// Runs the periodic background tasks of a store, such as retrying mirror
+// writes, and lets them be paused as a whole
+type scheduler struct {
+	// held for reading while a task runs, and for writing to pause
+	mu     sync.RWMutex
+	paused bool
+	resume chan struct{} // closed when resumed
+
+	tasksMu sync.Mutex
+	tasks   []*task
+}
+
+type task struct {
+	name     string
+	interval time.Duration
+	run      func()
+
+	mu      sync.Mutex
+	runs    uint64
+	lastRun time.Time
+	lastDur time.Duration
+}
+
+// Status of a periodic background task, as reported by Stats
+type TaskStatus struct {
+	Name         string
+	Interval     time.Duration
+	Runs         uint64        // completed runs since the store was opened
+	LastRun      time.Time     // start of the last completed run
+	LastDuration time.Duration // duration of the last completed run
+}
+
+// runs 'fn' every 'interval' in the background until the store is shut
+// down, except while background tasks are paused
+func (c *ElementStore) schedule(name string, interval time.Duration, fn func()) {
+	t := &task{name: name, interval: interval, run: fn}
+	c.sched.tasksMu.Lock()
+	c.sched.tasks = append(c.sched.tasks, t)
+	c.sched.tasksMu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-c.quit:
+				return
+			}
+
+			if !c.sched.begin(c.quit) {
+				return
+			}
+
+			start := time.Now()
+			t.run()
+			t.mu.Lock()
+			t.runs++
+			t.lastRun = start
+			t.lastDur = time.Since(start)
+			t.mu.Unlock()
+			c.sched.mu.RUnlock()
+		}
+	}()
+}
+
+// waits until background tasks are not paused and returns true, holding
+// a read lock, or returns false if 'quit' is closed first
+func (s *scheduler) begin(quit <-chan struct{}) bool {
+	for {
+		s.mu.RLock()
+		if !s.paused {
+			return true
+		}
+
+		resume := s.resume
+		s.mu.RUnlock()
+		select {
+		case <-resume:
+		case <-quit:
+			return false
+		}
+	}
+}
+
+// Pauses periodic background tasks, e.g. during latency critical windows,
+// and returns when the tasks that were running have finished. Tasks due
+// while paused run once background tasks are resumed
+//
+// Writes, deletes and cache admissions, which are driven by calls to the
+// store, are not affected
+func (c *ElementStore) PauseBackground() {
+	c.sched.mu.Lock()
+	defer c.sched.mu.Unlock()
+	if !c.sched.paused {
+		c.sched.paused = true
+		c.sched.resume = make(chan struct{})
+	}
+}
+
+// Resumes background tasks paused by PauseBackground
+func (c *ElementStore) ResumeBackground() {
+	c.sched.mu.Lock()
+	defer c.sched.mu.Unlock()
+	if c.sched.paused {
+		c.sched.paused = false
+		close(c.sched.resume)
+	}
+}
+
+func (c *ElementStore) schedulerStats(s *Stats) {
+	c.sched.mu.RLock()
+	s.BackgroundPaused = c.sched.paused
+	c.sched.mu.RUnlock()
+
+	c.sched.tasksMu.Lock()
+	defer c.sched.tasksMu.Unlock()
+	for _, t := range c.sched.tasks {
+		t.mu.Lock()
+		s.BackgroundTasks = append(s.BackgroundTasks, TaskStatus{
+			Name:         t.name,
+			Interval:     t.interval,
+			Runs:         t.runs,
+			LastRun:      t.lastRun,
+			LastDuration: t.lastDur,
+		})
+		t.mu.Unlock()
+	}
+}
@@ -0,0 +1,51 @@
+package elstore
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPauseBackground(t *testing.T) {
+	c, err := NewElementStore(0, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	var runs int64
+	c.schedule("test", time.Millisecond, func() {
+		atomic.AddInt64(&runs, 1)
+	})
+
+	waitRuns := func(n int64) {
+		for i := 0; atomic.LoadInt64(&runs) < n; i++ {
+			if i == 100 {
+				t.Fatal("task not run")
+			}
+
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	waitRuns(1)
+	c.PauseBackground()
+	paused := atomic.LoadInt64(&runs)
+	time.Sleep(20 * time.Millisecond)
+	if n := atomic.LoadInt64(&runs); n != paused {
+		t.Fatal("task run while paused", paused, n)
+	}
+
+	s := c.Stats()
+	if !s.BackgroundPaused || len(s.BackgroundTasks) != 1 ||
+		s.BackgroundTasks[0].Name != "test" ||
+		s.BackgroundTasks[0].Runs != uint64(paused) {
+		t.Fatal("unexpected stats", s.BackgroundPaused, s.BackgroundTasks)
+	}
+
+	c.ResumeBackground()
+	waitRuns(paused + 1)
+	if c.Stats().BackgroundPaused {
+		t.Fatal("expected background tasks to be resumed")
+	}
+}
@@ -0,0 +1,461 @@
+package elstore
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Small elements can be appended to segment files in the segments
+// directory of the workdir instead of getting a file each. Segments are
+// sequences of records of
+//
+//	ID          uint64
+//	written at  int64, Unix nanoseconds
+//	length      uint32, or segmentDeleted for a delete
+//	CRC-32      uint32, of the rest of the record
+//	data        the contents of the element file it replaces
+//
+// with all integers big endian. Later records of an ID replace earlier ones
+const (
+	segmentsDir         = ".segments"
+	segmentSuffix       = ".seg"
+	segmentMaxSize      = 64 << 20 // of records
+	segmentRecordHeader = 24
+	segmentDeleted      = 0xffffffff
+
+	// the most a record adds to an element: a checksum or compression
+	// header, and an encryption header, GCM nonce and tag
+	segmentElementOverhead = headerSize + encryptedSizeEnd + 12 + 16
+)
+
+// size at which a new segment is started
+var segmentSize int64 = segmentMaxSize
+
+// location of the latest record of an element
+type segmentLoc struct {
+	seg     int
+	off     int64 // of the record data
+	n       int
+	size    int64 // of the element in the record
+	written time.Time
+}
+
+type segmentStore struct {
+	dir     string
+	maxSize int // of elements stored in segments, 0 to store none
+	mode    os.FileMode
+
+	// held while appending, so that records are appended in the order
+	// they're indexed
+	appendMu  sync.Mutex
+	compactMu sync.Mutex
+
+	// held for writing only while the index or the set of segments
+	// changes, so that reads never wait for disk IO of other operations
+	mu    sync.RWMutex
+	files []*os.File // by segment number, nil once removed by compaction
+	size  int64      // of the last segment
+	index map[uint64]segmentLoc
+
+	compacting  int32  // number of the segment being compacted, plus one
+	readsMoving uint64 // reads of elements being moved by compaction
+
+	// segments with a damaged record, which are neither appended to nor
+	// compacted, so that the records after it are left for recovery
+	damaged map[int]bool
+}
+
+// Stores elements of at most 'maxSize' bytes in large segment files with an
+// index, rather than in a file each, for stores of many small elements.
+// Larger elements are stored in files of their own. Existing elements stay
+// where they are until superseded
+//
+// Space held by deleted and superseded elements is reclaimed by
+// CompactSegments. Segments can't be combined with a standby, a staging
+// directory or a shared workdir
+//
+// A store with segments opened without the option still reads, deletes
+// and compacts the elements in its segments, while new elements get a file
+// each
+//
+// 'maxSize' must be less than 64 MiB, less the space taken by the headers
+// of a record
+func WithSegments(maxSize int) Option {
+	if maxSize <= 0 || maxSize > segmentMaxSize-segmentElementOverhead {
+		return Option{err: fmt.Errorf("%w: segment element size %v", ErrInvalidOption, maxSize)}
+	}
+
+	return option(func(c *ElementStore) {
+		c.segmentMaxElement = maxSize
+	})
+}
+
+func segmentName(seg int) string {
+	return fmt.Sprintf("%08x%s", seg, segmentSuffix)
+}
+
+func parseSegmentName(name string) (int, bool) {
+	if !strings.HasSuffix(name, segmentSuffix) {
+		return 0, false
+	}
+
+	seg, err := strconv.ParseUint(strings.TrimSuffix(name, segmentSuffix), 16, 31)
+	return int(seg), err == nil
+}
+
+// opens the segments of the store and indexes their records, truncating
+// records torn by a crash. Segments with a damaged record are indexed up
+// to that record, left as they are and listed in the OpenReport
+func (c *ElementStore) openSegments() error {
+	dir := filepath.Join(c.workdir, segmentsDir)
+	if c.segmentMaxElement == 0 {
+		// segments written by an earlier WithSegments store
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+
+	if c.standby != "" || c.staging != "" || c.sharedWriter || c.sharedReader {
+		return fmt.Errorf("%w: segments can't be combined with a standby, "+
+			"staging or a shared workdir", ErrInvalidOption)
+	}
+
+	s := &segmentStore{
+		dir:     dir,
+		maxSize: c.segmentMaxElement,
+		index:   make(map[uint64]segmentLoc),
+		mode:    c.fileMode(),
+		damaged: make(map[int]bool),
+	}
+
+	if err := os.MkdirAll(s.dir, c.dirMode()); err != nil {
+		return err
+	}
+
+	entries, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+
+	// segments are numbered in the order they're written, with gaps left
+	// by compaction
+	var segs []int
+	for _, fi := range entries {
+		if seg, ok := parseSegmentName(fi.Name()); ok {
+			segs = append(segs, seg)
+		}
+	}
+
+	sort.Ints(segs)
+	for _, seg := range segs {
+		f, err := os.OpenFile(filepath.Join(s.dir, segmentName(seg)), os.O_RDWR, s.mode)
+		if err != nil {
+			s.close()
+			return err
+		}
+
+		for len(s.files) < seg {
+			s.files = append(s.files, nil)
+		}
+
+		s.files = append(s.files, f)
+		if s.size, err = s.load(seg); err != nil {
+			s.close()
+			return err
+		}
+
+		if s.damaged[seg] {
+			c.openReport.Damaged = append(c.openReport.Damaged, f.Name())
+			// appends go to a new segment
+			s.size = segmentSize
+		}
+	}
+
+	for id, loc := range s.index {
+		c.onDisk[id] = loc.size
+	}
+
+	c.segments = s
+	return nil
+}
+
+// a record read from a segment
+type segmentRecord struct {
+	id      uint64
+	written time.Time
+	off     int64  // of the data
+	data    []byte // nil for deletes
+}
+
+// calls 'fn' for each complete record read from 'r', and returns the
+// number of bytes up to the end of the last one. Also returns true if it
+// stopped at a damaged record, rather than at the end or at a record torn
+// by a crash, which is always the last one
+func scanSegment(r io.Reader, fn func(segmentRecord)) (int64, bool) {
+	br := bufio.NewReader(r)
+	var off int64
+	hdr := make([]byte, segmentRecordHeader)
+	for {
+		if _, err := io.ReadFull(br, hdr); err != nil {
+			return off, false
+		}
+
+		n := binary.BigEndian.Uint32(hdr[16:])
+		var data []byte
+		if n != segmentDeleted {
+			if n > segmentMaxSize {
+				return off, true
+			}
+
+			data = make([]byte, n)
+			if _, err := io.ReadFull(br, data); err != nil {
+				return off, false
+			}
+		}
+
+		if recordChecksum(hdr, data) != binary.BigEndian.Uint32(hdr[20:]) {
+			_, err := br.Peek(1)
+			return off, err == nil
+		}
+
+		fn(segmentRecord{
+			id:      binary.BigEndian.Uint64(hdr),
+			written: time.Unix(0, int64(binary.BigEndian.Uint64(hdr[8:]))),
+			off:     off + segmentRecordHeader,
+			data:    data,
+		})
+		off += segmentRecordHeader + int64(len(data))
+	}
+}
+
+// indexes the records of a segment, truncating it after the last complete
+// record unless it stopped at a damaged one. Returns the size of the
+// segment
+func (s *segmentStore) load(seg int) (int64, error) {
+	f := s.files[seg]
+	size, damaged := scanSegment(f, func(rec segmentRecord) {
+		if rec.data == nil {
+			delete(s.index, rec.id)
+		} else {
+			s.index[rec.id] = segmentLoc{seg, rec.off, len(rec.data),
+				elementSize(rec.data, int64(len(rec.data))), rec.written}
+		}
+	})
+
+	if damaged {
+		s.damaged[seg] = true
+		return size, nil
+	}
+
+	return size, f.Truncate(size)
+}
+
+func recordChecksum(hdr []byte, data []byte) uint32 {
+	sum := crc32.ChecksumIEEE(hdr[:20])
+	return crc32.Update(sum, crc32.IEEETable, data)
+}
+
+func (s *segmentStore) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, f := range s.files {
+		if f != nil {
+			f.Close()
+		}
+	}
+
+	s.files = nil
+}
+
+// appends a record written at 'written' to the last segment, starting a
+// new one if it's full, and indexes it if 'index' returns true given the
+// current location of the element. Returns the path of the segment and the
+// number of bytes written
+//
+// XXX: Assumes s.appendMu is held
+func (s *segmentStore) appendRecord(id uint64, data []byte, written time.Time, durable bool,
+	index func(loc segmentLoc, ok bool) bool) (string, int, error) {
+	s.mu.Lock()
+	if len(s.files) == 0 || s.size >= segmentSize {
+		f, err := os.OpenFile(filepath.Join(s.dir, segmentName(len(s.files))),
+			os.O_RDWR|os.O_CREATE|os.O_EXCL, s.mode)
+		if err != nil {
+			s.mu.Unlock()
+			return "", 0, err
+		}
+
+		s.files = append(s.files, f)
+		s.size = 0
+	}
+
+	seg, off, f := len(s.files)-1, s.size, s.files[len(s.files)-1]
+	s.mu.Unlock()
+
+	n := uint32(len(data))
+	if data == nil {
+		n = segmentDeleted
+	}
+
+	rec := make([]byte, segmentRecordHeader, segmentRecordHeader+len(data))
+	binary.BigEndian.PutUint64(rec, id)
+	binary.BigEndian.PutUint64(rec[8:], uint64(written.UnixNano()))
+	binary.BigEndian.PutUint32(rec[16:], n)
+	binary.BigEndian.PutUint32(rec[20:], recordChecksum(rec, data))
+	rec = append(rec, data...)
+
+	nw, err := f.WriteAt(rec, off)
+	if err == nil && durable {
+		err = f.Sync()
+	}
+
+	if err != nil {
+		// leave no torn record for the next append to follow
+		f.Truncate(off)
+		return "", nw, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.size += int64(len(rec))
+	if cur, ok := s.index[id]; index == nil || index(cur, ok) {
+		if data == nil {
+			delete(s.index, id)
+		} else {
+			s.index[id] = segmentLoc{seg, off + segmentRecordHeader, len(data),
+				elementSize(data, int64(len(data))), time.Unix(0, written.UnixNano())}
+		}
+	}
+
+	return f.Name(), nw, nil
+}
+
+func (s *segmentStore) lookup(id uint64) (segmentLoc, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	loc, ok := s.index[id]
+	return loc, ok
+}
+
+// returns the contents of the element file stored in a segment. Elements
+// being moved by compaction are read from where they were
+func (s *segmentStore) read(id uint64) ([]byte, error) {
+	for {
+		s.mu.RLock()
+		loc, ok := s.index[id]
+		var f *os.File
+		if ok && loc.seg < len(s.files) {
+			f = s.files[loc.seg]
+		}
+
+		s.mu.RUnlock()
+		if !ok {
+			return nil, os.ErrNotExist
+		} else if f == nil {
+			return nil, os.ErrClosed
+		}
+
+		if int(atomic.LoadInt32(&s.compacting)) == loc.seg+1 {
+			atomic.AddUint64(&s.readsMoving, 1)
+		}
+
+		data := make([]byte, loc.n)
+		_, err := f.ReadAt(data, loc.off)
+		if errors.Is(err, os.ErrClosed) {
+			// moved and its segment removed while we were reading
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		return data, nil
+	}
+}
+
+// appends the contents of an element file to the segments
+func (c *ElementStore) writeSegment(id uint64, data []byte) error {
+	c.segments.appendMu.Lock()
+	path, n, err := c.segments.appendRecord(id, data, time.Now(),
+		c.durability == SyncEveryWrite, nil)
+	c.segments.appendMu.Unlock()
+	atomic.AddUint64(&c.io.written, uint64(n))
+	if err != nil {
+		return err
+	}
+
+	return c.fileChanged(c.workdir, path, false)
+}
+
+// writes an element to a segment if it's small enough, and to a file
+// otherwise, removing the element from wherever it was stored before
+func (c *ElementStore) writeSegmented(elem []byte, id uint64) error {
+	if len(elem) > c.segments.maxSize || c.segments.maxSize == 0 {
+		if err := c.writeFile(c.root(id), elem, id); err != nil {
+			return err
+		}
+
+		return c.removeSegmented(id)
+	}
+
+	hdr, body := c.encryptElement(c.encodeElement(elem))
+	data := make([]byte, 0, len(hdr)+len(body))
+	if err := c.writeSegment(id, append(append(data, hdr...), body...)); err != nil {
+		return err
+	}
+
+	if err := os.Remove(c.elFile(c.root(id), id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// removes an element from the segments, if it's stored there
+func (c *ElementStore) removeSegmented(id uint64) error {
+	if _, ok := c.segments.lookup(id); !ok {
+		return nil
+	}
+
+	return c.writeSegment(id, nil)
+}
+
+func (c *ElementStore) readSegmented(id uint64) ([]byte, error) {
+	data, err := c.segments.read(id)
+	atomic.AddUint64(&c.io.read, uint64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+
+	if data, err = c.decryptElement(data); err != nil {
+		return nil, err
+	}
+
+	data, _, err = decodeElement(data)
+	return data, err
+}
+
+// returns the contents of the element file of 'id' in 'base', or of its
+// segment record
+func (c *ElementStore) readRaw(base string, id uint64) ([]byte, error) {
+	if c.segments != nil && base == c.root(id) {
+		if data, err := c.segments.read(id); !os.IsNotExist(err) {
+			return data, err
+		}
+	}
+
+	return readData(c.elFile(base, id), nil)
+}
@@ -0,0 +1,321 @@
+package elstore
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSegments(t *testing.T) {
+	large := make([]byte, 200)
+	c, err := NewElementStore(0, testDir, WithSegments(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for id, elem := range map[uint64][]byte{1: testData2, 2: large, 3: testData2} {
+		if err := c.Put(elem, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c.Sync()
+	if _, err := os.Stat(elFile(testDir, 1)); !os.IsNotExist(err) {
+		t.Fatal("small element written to a file of its own", err)
+	} else if _, err := os.Stat(elFile(testDir, 2)); err != nil {
+		t.Fatal("large element not written to a file of its own", err)
+	}
+
+	// replaced by elements stored the other way
+	for id, elem := range map[uint64][]byte{1: large, 2: testData2} {
+		if err := c.Delete(id); err != nil {
+			t.Fatal(err)
+		}
+
+		c.Sync()
+		if err := c.Put(elem, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := c.Delete(3); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Sync()
+
+	// a record torn by a crash is dropped
+	seg := filepath.Join(testDir, segmentsDir, segmentName(0))
+	f, err := os.OpenFile(seg, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.Write(make([]byte, segmentRecordHeader-1))
+	f.Close()
+
+	c.Close()
+	c, err = NewElementStore(0, testDir, WithSegments(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	if c.Has(3) {
+		t.Fatal("deleted element exists after reopening")
+	}
+
+	for id, expected := range map[uint64][]byte{1: large, 2: testData2} {
+		if data, err := c.Get(id); err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(data, expected) {
+			t.Fatalf("expected\n%v\n\ngot\n%v\n\n", expected, data)
+		}
+	}
+
+	if report, err := c.Verify(); err != nil || report.Verified != 2 {
+		t.Fatal("unexpected report", report, err)
+	}
+
+	if len(c.OpenReport().Ignored) != 0 {
+		t.Fatal("segments reported as ignored", c.OpenReport().Ignored)
+	}
+
+	if _, err := NewElementStore(0, testDir+"-2", WithSegments(100),
+		WithStagingDir(testDir+"-staging")); err == nil {
+		t.Fatal("expected error for segments combined with staging")
+	}
+
+	os.RemoveAll(testDir + "-2")
+	os.RemoveAll(testDir + "-staging")
+}
+
+func TestCompactSegments(t *testing.T) {
+	defer func(size int64) { segmentSize = size }(segmentSize)
+	segmentSize = 512
+
+	c, err := NewElementStore(0, testDir, WithSegments(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for id := uint64(0); id < 40; id++ {
+		if err := c.Put(testData2, id); err != nil {
+			t.Fatal(err)
+		}
+
+		c.Sync()
+	}
+
+	for id := uint64(0); id < 30; id++ {
+		if id%10 != 0 {
+			c.Delete(id)
+		}
+	}
+
+	segs := func() int {
+		entries, _ := os.ReadDir(filepath.Join(testDir, segmentsDir))
+		return len(entries)
+	}
+
+	before := segs()
+	moved, _ := c.segments.lookup(10)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			if _, err := c.Get(10); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	if err := c.CompactSegments(); err != nil {
+		t.Fatal(err)
+	}
+
+	<-done
+	if after := segs(); after >= before {
+		t.Fatal("no segments removed", before, after)
+	}
+
+	// moved records keep the time they were written
+	if loc, _ := c.segments.lookup(10); loc.seg == moved.seg {
+		t.Fatal("element not moved", loc)
+	} else if !loc.written.Equal(moved.written) {
+		t.Fatalf("expected\n%v\n\ngot\n%v\n\n", moved.written, loc.written)
+	}
+
+	// a read of an element in the segment being compacted
+	loc, _ := c.segments.lookup(10)
+	atomic.StoreInt32(&c.segments.compacting, int32(loc.seg+1))
+	c.Get(10)
+	atomic.StoreInt32(&c.segments.compacting, 0)
+	if n := c.Stats().CompactionReads; n == 0 {
+		t.Fatal("read of element being compacted not counted")
+	}
+
+	c.Close()
+	c, err = NewElementStore(0, testDir, WithSegments(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	if ids := c.IDs(); len(ids) != 13 {
+		t.Fatal("unexpected IDs after compaction", ids)
+	}
+
+	for _, id := range c.IDs() {
+		if data, err := c.Get(id); err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(data, testData2) {
+			t.Fatalf("expected\n%v\n\ngot\n%v\n\n", testData2, data)
+		}
+	}
+}
+
+func TestCompactSegmentsDryRun(t *testing.T) {
+	defer func(size int64) { segmentSize = size }(segmentSize)
+	segmentSize = 512
+
+	c, err := NewElementStore(0, testDir, WithSegments(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	for id := uint64(0); id < 20; id++ {
+		c.Put(testData2, id)
+		c.Sync()
+	}
+
+	for id := uint64(1); id < 10; id++ {
+		c.Delete(id)
+	}
+
+	report, err := c.CompactSegmentsWith(CompactOpts{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	} else if report.Segments == 0 || report.Reclaimed == 0 {
+		t.Fatal("nothing to compact", report)
+	}
+
+	if again, err := c.CompactSegmentsWith(CompactOpts{}); err != nil {
+		t.Fatal(err)
+	} else if again.Segments != report.Segments || again.Moved != report.Moved ||
+		again.Reclaimed != report.Reclaimed {
+		t.Fatalf("expected\n%v\n\ngot\n%v\n\n", report, again)
+	}
+}
+
+func TestSegmentsWithoutOption(t *testing.T) {
+	c, err := NewElementStore(0, testDir, WithSegments(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	if err := c.Put(testData2, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Close()
+	c, err = NewElementStore(0, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	if !c.Has(1) {
+		t.Fatal("element stored in a segment not found")
+	} else if err := c.Put(testData, 1); err != ErrAlreadyExists {
+		t.Fatal("expected ErrAlreadyExists, got", err)
+	} else if data, err := c.Get(1); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(data, testData2) {
+		t.Fatalf("expected\n%v\n\ngot\n%v\n\n", testData2, data)
+	}
+
+	// new elements get a file each
+	if err := c.Put(testData2, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Sync()
+	if _, err := os.Stat(elFile(testDir, 2)); err != nil {
+		t.Fatal("element not written to a file of its own", err)
+	}
+}
+
+func TestSegmentDamaged(t *testing.T) {
+	if _, err := NewElementStore(0, testDir, WithSegments(segmentMaxSize)); err == nil {
+		t.Fatal("expected error for elements too large for a segment")
+	}
+
+	c, err := NewElementStore(0, testDir, WithSegments(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for id := uint64(1); id <= 3; id++ {
+		c.Put(testData2, id)
+		c.Sync()
+	}
+
+	c.Close()
+
+	// the length of the record of 2 is out of range
+	seg := filepath.Join(testDir, segmentsDir, segmentName(0))
+	data, err := os.ReadFile(seg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pos := segmentRecordHeader + len(testData2) + headerSize + 16
+	length := append([]byte(nil), data[pos:pos+4]...)
+	copy(data[pos:], []byte{0xff, 0xff, 0xff, 0xf0})
+	if err := os.WriteFile(seg, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err = NewElementStore(0, testDir, WithSegments(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !c.Has(1) || c.Has(2) || c.Has(3) {
+		t.Fatal("expected only the element before the damaged record")
+	} else if damaged := c.OpenReport().Damaged; len(damaged) != 1 {
+		t.Fatal("damaged segment not reported", damaged)
+	} else if fi, err := os.Stat(seg); err != nil || fi.Size() != int64(len(data)) {
+		t.Fatal("damaged segment truncated", err)
+	}
+
+	// appended to a new segment
+	c.Put(testData2, 4)
+	c.Close()
+
+	// the records after the damaged one are still there once it's repaired
+	copy(data[pos:], length)
+	if err := os.WriteFile(seg, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err = NewElementStore(0, testDir, WithSegments(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	for id := uint64(1); id <= 4; id++ {
+		if data, err := c.Get(id); err != nil {
+			t.Fatal(id, err)
+		} else if !bytes.Equal(data, testData2) {
+			t.Fatalf("expected\n%v\n\ngot\n%v\n\n", testData2, data)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package elstore
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+var ErrSharded = errors.New("Not supported by stores with shards")
+
+// Write status of a shard directory
+type ShardStatus struct {
+	Dir string
+	Err error // the write error that stopped writes to the shard, if any
+}
+
+type shardErrors struct {
+	sync.Mutex
+	errs []error
+}
+
+// Spreads the elements over 'dirs', e.g. one directory per disk, to add up
+// their throughput. Each of the 64 subdirectories of the store is placed in
+// one of the directories, so IDs are spread by their lowest six bits. The
+// workdir keeps the bookkeeping of the store, such as the ownership record,
+// and may be one of 'dirs'
+//
+// A write error in a shard directory stops writes to that shard only, and
+// the store reports itself as Degraded. Elements of other shards can still
+// be written, see ShardStatus
+//
+// The directories and their order must stay the same between instances of
+// the store; elements in a directory that is left out are not found
+func WithShards(dirs ...string) Option {
+	if len(dirs) == 0 || len(dirs) > 64 {
+		return Option{err: fmt.Errorf("%w: %v shards", ErrInvalidOption, len(dirs))}
+	}
+
+	return option(func(c *ElementStore) {
+		c.shards = dirs
+		c.shardErrs.errs = make([]error, len(dirs))
+	})
+}
+
+// Returns the write status of each shard, in the order they were given to
+// WithShards, or nil if the store has no shards
+func (c *ElementStore) ShardStatus() []ShardStatus {
+	if len(c.shards) == 0 {
+		return nil
+	}
+
+	c.shardErrs.Lock()
+	defer c.shardErrs.Unlock()
+	ret := make([]ShardStatus, len(c.shards))
+	for i, dir := range c.shards {
+		ret[i] = ShardStatus{Dir: dir, Err: c.shardErrs.errs[i]}
+	}
+
+	return ret
+}
+
+// returns the index of the shard holding an element
+func (c *ElementStore) shard(id uint64) int {
+	// by subdirectory, see elDir
+	return int(id&0x3f) % len(c.shards)
+}
+
+// returns the directory holding the files of an element, not counting the
+// standby and staging directories
+func (c *ElementStore) root(id uint64) string {
+	if len(c.shards) == 0 {
+		return c.workdir
+	}
+
+	return c.shards[c.shard(id)]
+}
+
+// returns the error preventing an element from being written, if any
+func (c *ElementStore) writeErr(id uint64) error {
+	if err := c.WriteError(); err != nil || len(c.shards) == 0 {
+		return err
+	}
+
+	c.shardErrs.Lock()
+	defer c.shardErrs.Unlock()
+	return c.shardErrs.errs[c.shard(id)]
+}
+
+// records an error writing an element file. With shards, the error only
+// stops writes to the shard of the element
+func (c *ElementStore) elementWriteFailed(id uint64, err error) {
+	if len(c.shards) == 0 {
+		c.setWriteFailure(err)
+		return
+	}
+
+	c.shardErrs.Lock()
+	defer c.shardErrs.Unlock()
+	if ix := c.shard(id); c.shardErrs.errs[ix] == nil {
+		c.shardErrs.errs[ix] = err
+	}
+}
+
+func (c *ElementStore) shardFailed() bool {
+	c.shardErrs.Lock()
+	defer c.shardErrs.Unlock()
+	for _, err := range c.shardErrs.errs {
+		if err != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// returns the shard directories other than the workdir, creating them if
+// needed
+func (c *ElementStore) openShards() ([]string, error) {
+	var dirs []string
+	for _, dir := range c.shards {
+		if filepath.Clean(dir) == filepath.Clean(c.workdir) {
+			continue
+		}
+
+		if err := os.MkdirAll(dir, c.dirMode()); err != nil {
+			return nil, err
+		}
+
+		dirs = append(dirs, dir)
+	}
+
+	return dirs, nil
+}
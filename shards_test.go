@@ -0,0 +1,98 @@
+package elstore
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestShards(t *testing.T) {
+	shards := []string{testDir + "-shard0", testDir + "-shard1"}
+	c, err := NewElementStore(0, testDir, WithShards(shards...))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	for id := uint64(0); id < 4; id++ {
+		if err := c.Put(testData2, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c.Sync()
+	for id := uint64(0); id < 4; id++ {
+		if _, err := os.Stat(elFile(shards[id%2], id)); err != nil {
+			t.Fatal("element not in its shard", id, err)
+		}
+	}
+
+	c.Close()
+	c, err = NewElementStore(0, testDir, WithShards(shards...))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	for id := uint64(0); id < 4; id++ {
+		data, err := c.Get(id)
+		if err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(data, testData2) {
+			t.Fatalf("expected\n%v\n\ngot\n%v\n\n", testData2, data)
+		}
+	}
+
+	if _, err := c.RemoveToTrash(testDir + "-trash"); err != ErrSharded {
+		t.Fatal("expected ErrSharded, got", err)
+	}
+
+	if err := c.Remove(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, dir := range shards {
+		if _, err := os.Stat(dir); !os.IsNotExist(err) {
+			t.Fatal("shard not removed", dir, err)
+		}
+	}
+}
+
+func TestShardFailure(t *testing.T) {
+	shards := []string{testDir + "-shard0", testDir + "-shard1"}
+	c, err := NewElementStore(0, testDir, WithShards(shards...))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+
+	// a file in place of the subdirectory of 0 fails writes to shard 0
+	if err := ioutil.WriteFile(elDir(shards[0], 0), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Put(testData2, 0)
+	c.Sync()
+	if st := c.ShardStatus(); st[0].Err == nil || st[1].Err != nil {
+		t.Fatal("unexpected shard status", st)
+	} else if c.Health() != Degraded || c.WriteError() != nil {
+		t.Fatal("expected degraded store, got", c.Health(), c.WriteError())
+	}
+
+	if err := c.Put(testData2, 2); err == nil {
+		t.Fatal("expected write to failed shard to fail")
+	}
+
+	if err := c.Put(testData2, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Sync()
+	if data, err := c.Get(1); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(data, testData2) {
+		t.Fatalf("expected\n%v\n\ngot\n%v\n\n", testData2, data)
+	}
+}
@@ -0,0 +1,83 @@
+package elstore
+
+import (
+	"os"
+)
+
+// A marker file next to an element file signals that the element is being
+// written. Readers sharing the workdir treat such elements as nonexistent
+const markerSuffix = ".writing"
+
+// with 'exclusive' set, creating the marker fails if it already exists
+func createMarker(path string, mode os.FileMode, exclusive bool) error {
+	flags := os.O_RDWR | os.O_CREATE | os.O_TRUNC
+	if exclusive {
+		flags |= os.O_EXCL
+	}
+
+	f, err := os.OpenFile(path+markerSuffix, flags, mode)
+	if err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+func removeMarker(path string) error {
+	return os.Remove(path + markerSuffix)
+}
+
+func (c *ElementStore) parseMarker(name string) (uint64, bool) {
+	return c.parseSuffixed(name, markerSuffix)
+}
+
+// Makes the store mark elements that are being written, so that readers
+// opened WithSharedReader on the same workdir never observe half-written
+// elements
+func WithSharedWriter() Option {
+	return option(func(c *ElementStore) {
+		c.sharedWriter = true
+	})
+}
+
+// Opens the store as a reader of a workdir written to by another store
+// opened WithSharedWriter, possibly in another process. The reader picks up
+// elements written after it was opened, skips elements that are still
+// being written and does not claim ownership of the workdir. Put returns
+// ErrReadOnly
+func WithSharedReader() Option {
+	return option(func(c *ElementStore) {
+		c.sharedReader = true
+	})
+}
+
+// forgets elements found with a marker file during the startup walk. A
+// writer removes them, since the marker means the write never completed
+func (c *ElementStore) dropIncomplete(incomplete map[uint64]string) {
+	for id, path := range incomplete {
+		delete(c.onDisk, id)
+		if !c.sharedReader {
+			os.Remove(path)
+			removeMarker(path)
+		}
+	}
+}
+
+// looks for an element written by another store sharing the workdir
+func (c *ElementStore) discover(id uint64) bool {
+	path := c.elFile(c.root(id), id)
+	fi, ok := c.statFile(path)
+	if !ok {
+		return false
+	}
+
+	if _, ok := c.statFile(path + markerSuffix); ok {
+		return false
+	}
+
+	c.storeMutex.Lock()
+	c.onDisk[id] = fileElementSize(path, fi.Size())
+	c.notifyID(id)
+	c.storeMutex.Unlock()
+	return true
+}
@@ -0,0 +1,70 @@
+package elstore
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestSharedWorkdir(t *testing.T) {
+	w, err := NewElementStore(0, testDir, WithSharedWriter())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer w.Remove()
+	r, err := NewElementStore(0, testDir, WithSharedReader())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Put(testData2, 1); err != ErrReadOnly {
+		t.Fatal("expected ErrReadOnly, got", err)
+	}
+
+	if err := w.Put(testData2, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	w.Sync()
+	if err := w.WriteError(); err != nil {
+		t.Fatal("reader interfered with writer:", err)
+	}
+
+	data, err := r.Get(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(data, testData2) {
+		t.Fatalf("expected\n%v\n\ngot\n%v\n\n", testData2, data)
+	}
+
+	// simulate an element in the middle of being written
+	if err := w.Put(testData2, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	w.Sync()
+	if err := createMarker(elFile(testDir, 2), defaultFileMode, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Has(2) {
+		t.Fatal("reader observed element being written")
+	}
+
+	// a new writer discards the incomplete element
+	w, err = NewElementStore(0, testDir, WithSharedWriter())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if w.Has(2) {
+		t.Fatal("incomplete element loaded at startup")
+	}
+
+	if _, err := os.Stat(elFile(testDir, 2)); !os.IsNotExist(err) {
+		t.Fatal("incomplete element not removed at startup")
+	}
+}
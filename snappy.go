@@ -0,0 +1,174 @@
+package elstore
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// The Snappy block format: the uncompressed length as a uvarint, followed
+// by literals and copies of earlier output, each starting with a tag byte
+// whose low two bits tell them apart
+const (
+	snappyLiteral = 0
+	snappyCopy1   = 1 // 3 bits of length, 11 bits of offset
+	snappyCopy2   = 2 // 6 bits of length, 16 bits of offset
+	snappyCopy4   = 3 // 6 bits of length, 32 bits of offset
+
+	snappyTableBits = 14
+	snappyMaxOffset = 1<<16 - 1
+)
+
+var errSnappyCorrupt = errors.New("Corrupt Snappy data")
+
+// encodes 'src' in the Snappy block format, finding matches of at least
+// four bytes with a hash table of earlier positions
+func snappyEncode(src []byte) []byte {
+	dst := binary.AppendUvarint(make([]byte, 0, len(src)/2+16), uint64(len(src)))
+	table := make([]int, 1<<snappyTableBits) // positions plus one
+	lit := 0
+	for i := 0; i+4 <= len(src); {
+		v := binary.LittleEndian.Uint32(src[i:])
+		h := v * 0x1e35a7bd >> (32 - snappyTableBits)
+		cand := table[h] - 1
+		table[h] = i + 1
+		if cand < 0 || i-cand > snappyMaxOffset ||
+			binary.LittleEndian.Uint32(src[cand:]) != v {
+			i++
+			continue
+		}
+
+		n := 4
+		for i+n < len(src) && src[cand+n] == src[i+n] {
+			n++
+		}
+
+		dst = snappyLiteralTo(dst, src[lit:i])
+		dst = snappyCopyTo(dst, i-cand, n)
+		i += n
+		lit = i
+	}
+
+	return snappyLiteralTo(dst, src[lit:])
+}
+
+func snappyLiteralTo(dst, lit []byte) []byte {
+	for len(lit) > 0 {
+		chunk := lit
+		if len(chunk) > 1<<16 {
+			chunk = chunk[:1<<16]
+		}
+
+		n := len(chunk) - 1
+		switch {
+		case n < 60:
+			dst = append(dst, byte(n)<<2|snappyLiteral)
+		case n < 1<<8:
+			dst = append(dst, 60<<2|snappyLiteral, byte(n))
+		default:
+			dst = append(dst, 61<<2|snappyLiteral, byte(n), byte(n>>8))
+		}
+
+		dst = append(dst, chunk...)
+		lit = lit[len(chunk):]
+	}
+
+	return dst
+}
+
+// appends copies of 'n' bytes at 'off', which is at most snappyMaxOffset
+func snappyCopyTo(dst []byte, off, n int) []byte {
+	for n >= 68 {
+		dst = append(dst, 63<<2|snappyCopy2, byte(off), byte(off>>8))
+		n -= 64
+	}
+
+	if n > 64 {
+		// leaves at least four bytes for the last copy
+		dst = append(dst, 59<<2|snappyCopy2, byte(off), byte(off>>8))
+		n -= 60
+	}
+
+	if n >= 12 || off >= 2048 {
+		return append(dst, byte(n-1)<<2|snappyCopy2, byte(off), byte(off>>8))
+	}
+
+	return append(dst, byte(off>>8)<<5|byte(n-4)<<2|snappyCopy1, byte(off))
+}
+
+// decodes a Snappy block. Returns errSnappyCorrupt if it's malformed
+func snappyDecode(src []byte) ([]byte, error) {
+	size, k := binary.Uvarint(src)
+	// a copy of 64 bytes takes three, so no more than that is decoded
+	if k <= 0 || size/22 > uint64(len(src)) {
+		return nil, errSnappyCorrupt
+	}
+
+	dst := make([]byte, 0, size)
+	for s := k; s < len(src); {
+		tag := src[s]
+		var n, off int
+		switch tag & 3 {
+		case snappyLiteral:
+			n = int(tag >> 2)
+			s++
+			if n >= 60 {
+				width := n - 59
+				if s+width > len(src) {
+					return nil, errSnappyCorrupt
+				}
+
+				n = 0
+				for i := width - 1; i >= 0; i-- {
+					n = n<<8 | int(src[s+i])
+				}
+
+				s += width
+			}
+
+			n++
+			if n > len(src)-s || uint64(n) > size-uint64(len(dst)) {
+				return nil, errSnappyCorrupt
+			}
+
+			dst = append(dst, src[s:s+n]...)
+			s += n
+			continue
+		case snappyCopy1:
+			if s+2 > len(src) {
+				return nil, errSnappyCorrupt
+			}
+
+			n, off = 4+int(tag>>2&7), int(tag>>5)<<8|int(src[s+1])
+			s += 2
+		case snappyCopy2:
+			if s+3 > len(src) {
+				return nil, errSnappyCorrupt
+			}
+
+			n, off = 1+int(tag>>2), int(binary.LittleEndian.Uint16(src[s+1:]))
+			s += 3
+		case snappyCopy4:
+			if s+5 > len(src) {
+				return nil, errSnappyCorrupt
+			}
+
+			n, off = 1+int(tag>>2), int(binary.LittleEndian.Uint32(src[s+1:]))
+			s += 5
+		}
+
+		if off <= 0 || off > len(dst) || uint64(n) > size-uint64(len(dst)) {
+			return nil, errSnappyCorrupt
+		}
+
+		// copies may overlap their own output
+		for i := 0; i < n; i++ {
+			dst = append(dst, dst[len(dst)-off])
+		}
+	}
+
+	if uint64(len(dst)) != size {
+		return nil, errSnappyCorrupt
+	}
+
+	return dst, nil
+}
@@ -0,0 +1,239 @@
+package elstore
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"runtime"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// go test -run TestSoak -soak 4h. A short soak is run by default, and
+// none with -short
+var soakDuration = flag.Duration("soak", 2*time.Second, "run the soak test for the given duration")
+
+const (
+	soakWorkers   = 4
+	soakRoundOps  = 20000
+	soakIDsPerWkr = 2048
+	soakMaxElSize = 4096
+)
+
+// returns the number of open file descriptors of the process, or -1 if
+// that can't be determined on this platform
+func openFDs() int {
+	fds, err := ioutil.ReadDir("/proc/self/fd")
+	if err != nil {
+		return -1
+	}
+
+	return len(fds)
+}
+
+type soakResources struct {
+	goroutines int
+	fds        int
+	heap       uint64
+}
+
+func soakMeasure() soakResources {
+	runtime.GC()
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return soakResources{
+		goroutines: runtime.NumGoroutine(),
+		fds:        openFDs(),
+		heap:       ms.HeapAlloc,
+	}
+}
+
+// goroutines and files of a removed store are released asynchronously, so
+// the measurement is retried for a while before reporting a leak
+func soakCheckLeaks(baseline soakResources) error {
+	var now soakResources
+	for i := 0; i < 50; i++ {
+		now = soakMeasure()
+		if now.goroutines <= baseline.goroutines && now.fds <= baseline.fds {
+			return nil
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	if now.goroutines > baseline.goroutines {
+		buf := make([]byte, 1<<20)
+		buf = buf[:runtime.Stack(buf, true)]
+		return fmt.Errorf("goroutine leak: %v goroutines, expected at most %v\n%s",
+			now.goroutines, baseline.goroutines, buf)
+	}
+
+	return fmt.Errorf("file descriptor leak: %v open, expected at most %v",
+		now.fds, baseline.fds)
+}
+
+// runs random operations on a disjoint ID range of the store, keeping a
+// model of what the store is expected to contain
+func soakWorker(c *ElementStore, worker int, deadline time.Time, model map[uint64][]byte) error {
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano() + int64(worker)))
+	base := uint64(worker) << 32
+	for op := 0; op < soakRoundOps && time.Now().Before(deadline); op++ {
+		id := base + uint64(rnd.Intn(soakIDsPerWkr))
+		el := make([]byte, rnd.Intn(soakMaxElSize))
+		rnd.Read(el)
+
+		switch r := rnd.Intn(100); {
+		case r < 20:
+			err := c.Put(el, id)
+			if _, exists := model[id]; exists {
+				if err != ErrAlreadyExists {
+					return fmt.Errorf("Put(%x) of existing ID: %v", id, err)
+				}
+			} else if err != nil {
+				return fmt.Errorf("Put(%x): %v", id, err)
+			} else {
+				model[id] = el
+			}
+		case r < 25:
+			err := c.PutSupersede(el, id)
+			if err == nil {
+				model[id] = el
+			} else if err != ErrAlreadyExists {
+				return fmt.Errorf("PutSupersede(%x): %v", id, err)
+			}
+		case r < 30:
+			err := c.CancelPut(id)
+			_, exists := model[id]
+			switch {
+			case err == nil && exists:
+				delete(model, id)
+			case err == ErrAlreadyExists && exists:
+			case err == ErrDoesNotExist && !exists:
+			default:
+				return fmt.Errorf("CancelPut(%x): %v (in model: %v)", id, err, exists)
+			}
+		case r < 33:
+			err := c.Delete(id)
+			_, exists := model[id]
+			if exists && err == nil {
+				delete(model, id)
+			} else if exists || err != ErrDoesNotExist {
+				return fmt.Errorf("Delete(%x): %v (in model: %v)", id, err, exists)
+			}
+		case r < 35:
+			if has, exists := c.Has(id), model[id] != nil; has != exists {
+				return fmt.Errorf("Has(%x): %v, expected %v", id, has, exists)
+			}
+		default:
+			got, err := c.Get(id)
+			expected, exists := model[id]
+			if !exists {
+				if err != ErrDoesNotExist {
+					return fmt.Errorf("Get(%x) of missing ID: %v", id, err)
+				}
+			} else if err != nil {
+				return fmt.Errorf("Get(%x): %v", id, err)
+			} else if !bytes.Equal(got, expected) {
+				return fmt.Errorf("Get(%x): content mismatch", id)
+			}
+		}
+	}
+
+	return nil
+}
+
+// runs one store through a round of concurrent random operations, verifies
+// its content and removes it
+func soakRound(deadline time.Time) error {
+	c, err := NewElementStore(64, testDir+"-soak")
+	if err != nil {
+		return err
+	}
+
+	defer c.Remove()
+	models := make([]map[uint64][]byte, soakWorkers)
+	errs := make([]error, soakWorkers)
+	var wg sync.WaitGroup
+	for i := range models {
+		models[i] = make(map[uint64][]byte)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = soakWorker(c, i, deadline, models[i])
+		}(i)
+	}
+
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := c.Sync(); err != nil {
+		return err
+	}
+
+	if err := c.WriteError(); err != nil {
+		return err
+	}
+
+	var expected []uint64
+	for _, model := range models {
+		for id, el := range model {
+			expected = append(expected, id)
+			got, err := c.Get(id)
+			if err != nil {
+				return fmt.Errorf("Get(%x) after Sync: %v", id, err)
+			} else if !bytes.Equal(got, el) {
+				return fmt.Errorf("Get(%x) after Sync: content mismatch", id)
+			}
+		}
+	}
+
+	ids := c.IDs()
+	sortIDs(ids)
+	sort.Slice(expected, func(i, j int) bool { return expected[i] < expected[j] })
+	if fmt.Sprint(ids) != fmt.Sprint(expected) {
+		return fmt.Errorf("IDs: got %v IDs, expected %v", len(ids), len(expected))
+	}
+
+	return c.Remove()
+}
+
+// Runs rounds of randomized operations for the duration given by -soak,
+// at least one round, checking that each removed store gives back its goroutines and files and
+// that the heap doesn't grow between rounds
+func TestSoak(t *testing.T) {
+	if testing.Short() || *soakDuration <= 0 {
+		t.Skip("soak test not enabled")
+	}
+
+	deadline := time.Now().Add(*soakDuration)
+	baseline := soakMeasure()
+	var heapAfterFirst uint64
+	for round := 0; time.Now().Before(deadline); round++ {
+		if err := soakRound(deadline); err != nil {
+			t.Fatalf("round %v: %v", round, err)
+		}
+
+		if err := soakCheckLeaks(baseline); err != nil {
+			t.Fatalf("round %v: %v", round, err)
+		}
+
+		heap := soakMeasure().heap
+		if round == 0 {
+			heapAfterFirst = heap
+		} else if heap > 2*heapAfterFirst+16<<20 {
+			t.Fatalf("round %v: heap grew from %v to %v bytes", round,
+				heapAfterFirst, heap)
+		}
+
+		t.Logf("round %v: %v goroutines, %v fds, %v heap bytes", round,
+			runtime.NumGoroutine(), openFDs(), heap)
+	}
+}
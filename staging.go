@@ -0,0 +1,135 @@
+package elstore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+func (c *ElementStore) isStaged(id uint64) bool {
+	if c.staging == "" {
+		return false
+	}
+
+	c.storeMutex.RLock()
+	defer c.storeMutex.RUnlock()
+	_, ok := c.staged[id]
+	return ok
+}
+
+// writes an element to the staging directory, releases it from memory and
+// moves it into the workdir. Returns errCancelled or errSuperseded if the
+// write was cancelled or superseded before the element was staged
+func (c *ElementStore) writeStaged(pw *pendingWrite, elem []byte, version int, id uint64) error {
+	if err := c.writeFile(c.staging, elem, id); err != nil {
+		return err
+	}
+
+	c.storeMutex.Lock()
+	if pw.cancelled {
+		c.storeMutex.Unlock()
+		os.Remove(c.elFile(c.staging, id))
+		return errCancelled
+	} else if pw.version != version {
+		c.storeMutex.Unlock()
+		return errSuperseded
+	}
+
+	c.staged[id] = pw
+	c.onDisk[id] = int64(len(elem))
+	if c.inTransfer[id] == pw {
+		delete(c.inTransfer, id)
+	}
+
+	c.storeMutex.Unlock()
+
+	if err := c.commitStaged(id); err != nil {
+		return err
+	}
+
+	c.storeMutex.Lock()
+	defer c.storeMutex.Unlock()
+	delete(c.staged, id)
+	if pw.cancelled {
+		// deleted while being committed
+		c.removeElementFiles(id)
+	}
+
+	return nil
+}
+
+// moves a staged element into the workdir, renaming it if possible and
+// copying it otherwise (other file system, standby configured)
+func (c *ElementStore) commitStaged(id uint64) error {
+	src := c.elFile(c.staging, id)
+	if c.standby == "" {
+		dir := elDir(c.root(id), id)
+		if err := os.MkdirAll(dir, c.dirMode()); err != nil {
+			return err
+		}
+
+		dst := c.elFile(c.root(id), id)
+		if err := os.Rename(src, dst); err == nil {
+			return c.fileChanged(c.root(id), dst, false)
+		}
+	}
+
+	elem, err := c.readFile(c.staging, id, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := c.writeElement(elem, id); err != nil {
+		return err
+	}
+
+	return os.Remove(src)
+}
+
+// moves elements left in the staging directory into the workdir
+func (c *ElementStore) recoverStaged() error {
+	if err := os.MkdirAll(c.staging, c.dirMode()); err != nil {
+		return err
+	}
+
+	dirs, err := ioutil.ReadDir(c.staging)
+	if err != nil {
+		return err
+	}
+
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+
+		files, err := ioutil.ReadDir(filepath.Join(c.staging, dir.Name()))
+		if err != nil {
+			return err
+		}
+
+		for _, file := range files {
+			if c.isElementTmp(file.Name()) {
+				os.Remove(filepath.Join(c.staging, dir.Name(), file.Name()))
+				c.openReport.Incomplete++
+				continue
+			}
+
+			id, ok := c.parseName(file.Name())
+			if !ok || !file.Mode().IsRegular() {
+				continue
+			}
+
+			c.renameLegacy(filepath.Join(c.staging, dir.Name(), file.Name()), id, "")
+
+			if err := c.commitStaged(id); err != nil {
+				return err
+			}
+
+			// committed to the workdir by now
+			c.onDisk[id] = fileElementSize(c.elFile(c.root(id), id), file.Size())
+			c.openReport.Staged++
+		}
+	}
+
+	return nil
+}
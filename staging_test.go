@@ -0,0 +1,61 @@
+package elstore
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+var testStagingDir = testDir + "-staging"
+
+func TestStagingDir(t *testing.T) {
+	c, err := NewElementStore(0, testDir, WithStagingDir(testStagingDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	if err := c.Put(testData2, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Sync()
+	if err := c.WriteError(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(elFile(testDir, 1)); err != nil {
+		t.Fatal("element not moved into workdir:", err)
+	}
+
+	if _, err := os.Stat(elFile(testStagingDir, 1)); !os.IsNotExist(err) {
+		t.Fatal("element left in staging directory")
+	}
+
+	// an element left behind by a previous instance
+	os.MkdirAll(elDir(testStagingDir, 2), 0700)
+	if err := os.WriteFile(elFile(testStagingDir, 2), testData, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Close()
+	c, err = NewElementStore(0, testDir, WithStagingDir(testStagingDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+
+	data, err := c.Get(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(data, testData) {
+		t.Fatalf("expected\n%v\n\ngot\n%v\n\n", testData, data)
+	}
+
+	if _, err := os.Stat(elFile(testDir, 2)); err != nil {
+		t.Fatal("staged element not recovered into workdir:", err)
+	}
+}
@@ -0,0 +1,69 @@
+package elstore
+
+import (
+	"os"
+	"sync/atomic"
+)
+
+func (c *ElementStore) isFailedOver() bool {
+	return atomic.LoadInt32(&c.failedOver) != 0
+}
+
+func (c *ElementStore) failOver() {
+	atomic.StoreInt32(&c.failedOver, 1)
+}
+
+// writes to the workdir and the standby, unless either has failed. The
+// write succeeds as long as one of them is written
+func (c *ElementStore) writeWithStandby(elem []byte, id uint64) error {
+	primaryOK := !c.isFailedOver()
+	standbyOK := atomic.LoadInt32(&c.standbyFailed) == 0
+
+	var primaryErr, standbyErr error
+	if primaryOK {
+		primaryErr = c.writeFile(c.root(id), elem, id)
+	}
+
+	if standbyOK {
+		standbyErr = c.writeFile(c.standby, elem, id)
+		if standbyErr != nil {
+			atomic.StoreInt32(&c.standbyFailed, 1)
+		}
+	}
+
+	if primaryOK && primaryErr == nil {
+		return nil
+	}
+
+	if standbyOK && standbyErr == nil {
+		if primaryOK {
+			c.failOver()
+		}
+
+		return nil
+	}
+
+	if primaryErr != nil {
+		return primaryErr
+	}
+
+	return standbyErr
+}
+
+// reads from the workdir, falling back to the standby. Elements missing
+// from the workdir are read from the standby without failing over, since
+// they may have been written while a previous instance was failed over
+func (c *ElementStore) readWithStandby(id uint64, opened chan<- *os.File) ([]byte, error) {
+	if !c.isFailedOver() {
+		el, err := c.readFile(c.root(id), id, opened)
+		if err == nil || atomic.LoadInt32(&c.standbyFailed) != 0 {
+			return el, err
+		}
+
+		if !os.IsNotExist(err) && err != ErrChecksumMismatch {
+			c.failOver()
+		}
+	}
+
+	return c.readFile(c.standby, id, opened)
+}
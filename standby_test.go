@@ -0,0 +1,79 @@
+package elstore
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+var testStandbyDir = testDir + "-standby"
+
+func TestStandbyFallback(t *testing.T) {
+	c, err := NewElementStore(0, testDir, WithStandby(testStandbyDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	if err := c.Put(testData2, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Sync()
+	if err := os.Remove(elFile(testDir, 1)); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := c.Get(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(data, testData2) {
+		t.Fatalf("expected\n%v\n\ngot\n%v\n\n", testData2, data)
+	}
+
+	if h := c.Health(); h != Healthy {
+		t.Fatal("missing element caused failover", h)
+	}
+}
+
+func TestStandbyFailover(t *testing.T) {
+	c, err := NewElementStore(0, testDir, WithStandby(testStandbyDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+
+	// a regular file in place of the element directory breaks the workdir
+	if err := os.WriteFile(elDir(testDir, 1), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Put(testData2, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Sync()
+	if err := c.WriteError(); err != nil {
+		t.Fatal("write failed despite standby:", err)
+	}
+
+	if h := c.Health(); h != Degraded {
+		t.Fatal("expected degraded store, got", h)
+	}
+
+	data, err := c.Get(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(data, testData2) {
+		t.Fatalf("expected\n%v\n\ngot\n%v\n\n", testData2, data)
+	}
+
+	if _, err := os.Stat(elFile(testStandbyDir, 1)); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,239 @@
+package elstore
+
+import (
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+// Latency distribution of an operation. Percentiles are approximate; they
+// are reported as the upper bound of the histogram bucket they fall in,
+// which is at most 1/8th above the true value
+type LatencyStats struct {
+	Count uint64
+	P50   time.Duration
+	P95   time.Duration
+	P99   time.Duration
+	Max   time.Duration
+}
+
+// Number of elements within a range of sizes, and their total size
+type SizeBucket struct {
+	MinSize int64 // inclusive, in bytes
+	MaxSize int64 // inclusive, in bytes
+	Count   uint64
+	Bytes   uint64
+}
+
+// Snapshot of store statistics
+type Stats struct {
+	GetHit        LatencyStats // Get served from memory
+	GetDisk       LatencyStats // Get served from disk
+	PutEnqueue    LatencyStats // Put, up until the write is scheduled
+	WriteComplete LatencyStats // asynchronous writes, from start to finish
+
+	Elements uint64 // elements on disk
+	Bytes    uint64 // total size of elements on disk, as inserted
+
+	// Size distribution of the elements on disk in power-of-two buckets,
+	// from the smallest to the largest non-empty bucket
+	SizeDistribution []SizeBucket
+
+	// IO accounting since the store was opened. WrittenBytes includes
+	// writes to the standby and staging directories, rewrites of superseded
+	// elements and retried writes. Renames are free
+	AcceptedBytes uint64 // size of elements given to Put and PutSupersede
+	WrittenBytes  uint64 // bytes written to element files
+	MirroredBytes uint64 // bytes sent to mirrors, including retries
+	ReadBytes     uint64 // bytes read from element files
+
+	// (WrittenBytes + MirroredBytes) / AcceptedBytes, or zero if nothing
+	// was accepted
+	WriteAmplification float64
+
+	// Reads of elements served from segments while CompactSegments was
+	// moving them
+	CompactionReads uint64
+
+	// Lookups of missing IDs answered by the negative cache of a shared
+	// reader, see WithNegativeCache
+	NegativeCacheHits uint64
+
+	// Elements deleted to stay within WithMaxDiskBytes and WithMaxElements
+	DiskEvictions uint64
+
+	// Periodic background tasks, and whether they're paused by
+	// PauseBackground
+	BackgroundTasks  []TaskStatus
+	BackgroundPaused bool
+}
+
+// byte counters for IO accounting, updated atomically
+type ioCounters struct {
+	accepted uint64
+	written  uint64
+	read     uint64
+}
+
+// log-linear histogram: each power of two is split into histSubBuckets
+// linear buckets, giving a bounded relative error in the style of
+// HdrHistogram. Recording is lock free
+const (
+	histSubBits    = 3
+	histSubBuckets = 1 << histSubBits
+	histBuckets    = (64-histSubBits)*histSubBuckets + histSubBuckets
+)
+
+type histogram struct {
+	counts [histBuckets]uint64
+	max    int64
+}
+
+func histIndex(v uint64) int {
+	b := bits.Len64(v)
+	if b <= histSubBits+1 {
+		return int(v)
+	}
+
+	shift := uint(b - histSubBits - 1)
+	return int(shift)*histSubBuckets + int(v>>shift)
+}
+
+// returns the highest value mapping to bucket ix
+func histUpperBound(ix int) uint64 {
+	if ix < 2*histSubBuckets {
+		return uint64(ix)
+	}
+
+	shift := uint(ix/histSubBuckets - 1)
+	mantissa := uint64(ix%histSubBuckets + histSubBuckets)
+	return (mantissa+1)<<shift - 1
+}
+
+func (h *histogram) record(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+
+	atomic.AddUint64(&h.counts[histIndex(uint64(d))], 1)
+	for {
+		max := atomic.LoadInt64(&h.max)
+		if int64(d) <= max || atomic.CompareAndSwapInt64(&h.max, max, int64(d)) {
+			break
+		}
+	}
+}
+
+func (h *histogram) since(start time.Time) {
+	h.record(time.Since(start))
+}
+
+func (h *histogram) stats() LatencyStats {
+	var counts [histBuckets]uint64
+	var total uint64
+	for i := range counts {
+		counts[i] = atomic.LoadUint64(&h.counts[i])
+		total += counts[i]
+	}
+
+	max := time.Duration(atomic.LoadInt64(&h.max))
+	quantile := func(q float64) time.Duration {
+		if total == 0 {
+			return 0
+		}
+
+		target := uint64(q*float64(total) + 0.5)
+		if target == 0 {
+			target = 1
+		}
+
+		var seen uint64
+		for i, n := range counts {
+			seen += n
+			if seen >= target {
+				if d := time.Duration(histUpperBound(i)); d < max {
+					return d
+				}
+
+				return max
+			}
+		}
+
+		return max
+	}
+
+	return LatencyStats{
+		Count: total,
+		P50:   quantile(0.50),
+		P95:   quantile(0.95),
+		P99:   quantile(0.99),
+		Max:   max,
+	}
+}
+
+// Returns a snapshot of the store statistics
+func (c *ElementStore) Stats() Stats {
+	s := Stats{
+		GetHit:        c.getHitLatency.stats(),
+		GetDisk:       c.getDiskLatency.stats(),
+		PutEnqueue:    c.putLatency.stats(),
+		WriteComplete: c.writeLatency.stats(),
+	}
+
+	c.sizeStats(&s)
+	c.ioStats(&s)
+	c.schedulerStats(&s)
+	s.NegativeCacheHits = atomic.LoadUint64(&c.negativeHits)
+	s.DiskEvictions = atomic.LoadUint64(&c.evictions)
+	if c.segments != nil {
+		s.CompactionReads = atomic.LoadUint64(&c.segments.readsMoving)
+	}
+
+	return s
+}
+
+func (c *ElementStore) ioStats(s *Stats) {
+	s.AcceptedBytes = atomic.LoadUint64(&c.io.accepted)
+	s.WrittenBytes = atomic.LoadUint64(&c.io.written)
+	s.ReadBytes = atomic.LoadUint64(&c.io.read)
+	for _, m := range c.mirrors {
+		s.MirroredBytes += atomic.LoadUint64(&m.sent)
+	}
+
+	if s.AcceptedBytes > 0 {
+		s.WriteAmplification = float64(s.WrittenBytes+s.MirroredBytes) /
+			float64(s.AcceptedBytes)
+	}
+}
+
+func (c *ElementStore) sizeStats(s *Stats) {
+	// bucket i holds sizes with a bit length of i, i.e. [2^(i-1), 2^i)
+	var buckets [64]SizeBucket
+	c.storeMutex.RLock()
+	for _, size := range c.onDisk {
+		b := &buckets[bits.Len64(uint64(size))]
+		b.Count++
+		b.Bytes += uint64(size)
+	}
+
+	c.storeMutex.RUnlock()
+
+	first, last := -1, -1
+	for i := range buckets {
+		buckets[i].MinSize = int64(1) << uint(i) >> 1
+		buckets[i].MaxSize = int64(uint64(1)<<uint(i) - 1)
+		if buckets[i].Count > 0 {
+			if first < 0 {
+				first = i
+			}
+
+			last = i
+			s.Elements += buckets[i].Count
+			s.Bytes += buckets[i].Bytes
+		}
+	}
+
+	if first >= 0 {
+		s.SizeDistribution = buckets[first : last+1]
+	}
+}
@@ -0,0 +1,179 @@
+package elstore
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestHistogramBuckets(t *testing.T) {
+	for _, v := range []uint64{0, 1, 15, 16, 17, 1000, 1 << 40, 1<<64 - 1} {
+		ix := histIndex(v)
+		if ix < 0 || ix >= histBuckets {
+			t.Fatal("index out of range for", v, ix)
+		}
+
+		if up := histUpperBound(ix); up < v || (v > 16 && up-v > v/histSubBuckets) {
+			t.Fatal("bad upper bound for", v, up)
+		}
+	}
+}
+
+func TestHistogramQuantiles(t *testing.T) {
+	var h histogram
+	for i := 1; i <= 100; i++ {
+		h.record(time.Duration(i) * time.Millisecond)
+	}
+
+	s := h.stats()
+	if s.Count != 100 || s.Max != 100*time.Millisecond {
+		t.Fatal("unexpected count/max", s)
+	}
+
+	within := func(got, want time.Duration) bool {
+		return got >= want && got <= want+want/histSubBuckets
+	}
+
+	if !within(s.P50, 50*time.Millisecond) || !within(s.P95, 95*time.Millisecond) ||
+		!within(s.P99, 99*time.Millisecond) {
+		t.Fatal("unexpected percentiles", s)
+	}
+}
+
+func TestStatsRecorded(t *testing.T) {
+	c, err := NewElementStore(0, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	if err := c.Put(testData2, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Get(1)
+	c.Sync()
+	c.Get(1)
+
+	s := c.Stats()
+	if s.PutEnqueue.Count != 1 || s.WriteComplete.Count != 1 ||
+		s.GetHit.Count+s.GetDisk.Count != 2 {
+		t.Fatal("unexpected stats", s)
+	}
+}
+
+func TestSizeDistribution(t *testing.T) {
+	c, err := NewElementStore(0, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	for i, size := range []int{1, 5, 6, 7, 100} {
+		if err := c.Put(make([]byte, size), uint64(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c.Sync()
+	s := c.Stats()
+	if s.Elements != 5 || s.Bytes != 119 {
+		t.Fatal("unexpected totals", s.Elements, s.Bytes)
+	}
+
+	dist := s.SizeDistribution
+	if len(dist) != 7 || dist[0].MinSize != 1 || dist[0].MaxSize != 1 ||
+		dist[0].Count != 1 || dist[2].MinSize != 4 || dist[2].Count != 3 ||
+		dist[2].Bytes != 18 || dist[6].MaxSize != 127 || dist[6].Count != 1 {
+		t.Fatal("unexpected distribution", dist)
+	}
+}
+
+func TestIOStats(t *testing.T) {
+	c, err := NewElementStore(0, testDir, WithStandby(testDir+"-standby"),
+		WithMirrors(failingBackend{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	if s := c.Stats(); s.WriteAmplification != 0 {
+		t.Fatal("expected no write amplification, got", s.WriteAmplification)
+	}
+
+	if err := c.Put(make([]byte, 100), 1); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Sync()
+	if _, err := c.Get(1); err != nil {
+		t.Fatal(err)
+	}
+
+	// written to the workdir, the standby and the mirror. Files have a
+	// checksum header
+	s := c.Stats()
+	file := uint64(100 + headerSize)
+	if s.AcceptedBytes != 100 || s.WrittenBytes != 2*file ||
+		s.MirroredBytes != 100 || s.ReadBytes != file ||
+		s.WriteAmplification != float64(2*file+100)/100 {
+		t.Fatal("unexpected IO stats", s.AcceptedBytes, s.WrittenBytes,
+			s.MirroredBytes, s.ReadBytes, s.WriteAmplification)
+	}
+}
+
+func TestSizesAfterReopen(t *testing.T) {
+	key := make([]byte, 32)
+	compressible := bytes.Repeat([]byte("compressible text "), 100)
+	for _, opts := range [][]Option{
+		nil,
+		{WithEncryption(key)},
+		{WithEncryption(key), WithCompression(Snappy)},
+		{WithEncryption(key), WithSegments(1000)},
+	} {
+		c, err := NewElementStore(0, testDir, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := c.Put(compressible, 1); err != nil {
+			t.Fatal(err)
+		} else if err := c.Put(testData2, 2); err != nil {
+			t.Fatal(err)
+		}
+
+		c.Sync()
+		expected := c.Stats().Bytes
+		c.Close()
+		if c, err = NewElementStore(0, testDir, opts...); err != nil {
+			t.Fatal(err)
+		}
+
+		if s := c.Stats(); s.Bytes != expected {
+			t.Fatalf("expected\n%v\n\ngot\n%v\n\n", expected, s.Bytes)
+		}
+
+		if err := c.Remove(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// written before checksums
+	if err := os.MkdirAll(elDir(testDir, 1), 0755); err != nil {
+		t.Fatal(err)
+	} else if err := ioutil.WriteFile(elFile(testDir, 1), testData2, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := NewElementStore(0, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	if s := c.Stats(); s.Bytes != uint64(len(testData2)) {
+		t.Fatalf("expected\n%v\n\ngot\n%v\n\n", len(testData2), s.Bytes)
+	}
+}
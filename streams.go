@@ -0,0 +1,285 @@
+package elstore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// elements larger than this are streamed by GetReader, unless set by
+// WithStreamThreshold
+const defaultStreamThreshold = 1 << 20
+
+// Sets the size above which GetReader streams elements from their files
+// instead of reading them into memory, bypassing the cache. The default is
+// 1 MiB
+func WithStreamThreshold(size int64) Option {
+	if size <= 0 {
+		return Option{err: fmt.Errorf("%w: stream threshold %v", ErrInvalidOption, size)}
+	}
+
+	return option(func(c *ElementStore) {
+		c.streamThreshold = size
+	})
+}
+
+func (c *ElementStore) streamThresholdBytes() int64 {
+	if c.streamThreshold == 0 {
+		return defaultStreamThreshold
+	}
+
+	return c.streamThreshold
+}
+
+// returns true if elements can be streamed to their files, which are then
+// written the same way whatever their content
+func (c *ElementStore) canStream() bool {
+	c.keys.RLock()
+	encrypted := len(c.keys.aeads) > 0 && c.keys.aeads[0] != nil
+	c.keys.RUnlock()
+	return !encrypted && c.compression == NoCompression && c.segments == nil &&
+		c.standby == "" && c.staging == "" && !c.sharedWriter &&
+		len(c.mirrors) == 0
+}
+
+// Inserts an element read from 'r', streaming it straight to its file
+// rather than holding it in memory. Unlike Put, the element is written
+// before PutReader returns
+//
+// Elements are read into memory and written by Put when the store is
+// configured in a way that needs the whole element, i.e. with
+// compression, encryption, segments, a standby, a staging directory,
+// mirrors or a shared workdir
+//
+// Returns ErrAlreadyExists if the ID is already in use
+func (c *ElementStore) PutReader(r io.Reader, id uint64) error {
+	if c.IsReadOnly() {
+		return ErrReadOnly
+	}
+
+	if !c.canStream() {
+		elem, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+
+		return c.Put(elem, id)
+	}
+
+	if err := c.writeErr(id); err != nil {
+		return err
+	}
+
+	c.storeMutex.Lock()
+	if c.isClosed() {
+		c.storeMutex.Unlock()
+		return ErrClosed
+	} else if c.has(id) {
+		c.storeMutex.Unlock()
+		return ErrAlreadyExists
+	}
+
+	c.streaming[id] = false
+	prev, unlinked := c.cancelled[id], c.deleting[id]
+	c.storeMutex.Unlock()
+
+	c.awaitPrevious(id, prev, unlinked)
+	accepted := time.Now()
+
+	size, err := c.writeStream(r, id)
+	c.breaker.record(err)
+
+	c.storeMutex.Lock()
+	defer c.storeMutex.Unlock()
+	deleted := c.streaming[id]
+	delete(c.streaming, id)
+	if err != nil {
+		return err
+	} else if deleted {
+		os.Remove(c.elFile(c.root(id), id))
+		return nil
+	}
+
+	c.onDisk[id] = size
+	c.inserted.set(id, accepted)
+	c.notifyID(id)
+	atomic.AddUint64(&c.io.accepted, uint64(size))
+	c.checkCapacity()
+	return nil
+}
+
+// writes an element read from 'r' to its file, with a checksum header
+// written once the element has been read. Returns the size of the element
+func (c *ElementStore) writeStream(r io.Reader, id uint64) (int64, error) {
+	base := c.root(id)
+	if err := os.MkdirAll(elDir(base, id), c.dirMode()); err != nil {
+		return 0, err
+	}
+
+	path := c.elFile(base, id)
+	tmp := path + tmpSuffix
+	f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, c.fileMode())
+	if err != nil {
+		return 0, err
+	}
+
+	h := crc32.NewIEEE()
+	size, err := writeStreamed(f, io.TeeReader(r, h), h)
+	atomic.AddUint64(&c.io.written, uint64(size))
+	if err == nil && c.durability == SyncEveryWrite {
+		err = f.Sync()
+	}
+
+	if err != nil {
+		f.Close()
+	} else if err = f.Close(); err == nil {
+		if err = c.checkOwnership(); err == nil {
+			err = os.Rename(tmp, path)
+		}
+	}
+
+	if err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+
+	return size - int64(headerSize), c.fileChanged(base, path, false)
+}
+
+// writes a checksum header placeholder followed by the contents of 'r' to
+// 'f', and then the header. Returns the number of bytes written
+func writeStreamed(f *os.File, r io.Reader, h hash.Hash32) (int64, error) {
+	hdr := make([]byte, headerSize)
+	copy(hdr, checksumMagic)
+	if _, err := f.Write(hdr); err != nil {
+		return 0, err
+	}
+
+	n, err := io.Copy(f, r)
+	if err != nil {
+		return int64(headerSize) + n, err
+	}
+
+	binary.BigEndian.PutUint32(hdr[len(checksumMagic):], h.Sum32())
+	if _, err := f.WriteAt(hdr, 0); err != nil {
+		return int64(headerSize) + n, err
+	}
+
+	return int64(headerSize) + n, nil
+}
+
+// Returns a reader of an element and its size. Elements larger than the
+// threshold of WithStreamThreshold are streamed from their files without
+// being cached, and their checksums are verified once they've been read
+// to the end, where the reader returns ErrChecksumMismatch instead of
+// io.EOF on a mismatch. Other elements are read as by Get
+//
+// Compressed, encrypted and segmented elements are always read into
+// memory. The reader must be closed
+//
+// Returns ErrDoesNotExist if the ID is not recognized
+func (c *ElementStore) GetReader(id uint64) (io.ReadCloser, int64, error) {
+	if c.isClosed() {
+		return nil, 0, ErrClosed
+	}
+
+	c.storeMutex.RLock()
+	if target, ok := c.aliases[id]; ok {
+		id = target
+	}
+
+	if c.expired(id) {
+		c.storeMutex.RUnlock()
+		return nil, 0, ErrDoesNotExist
+	}
+
+	size, onDisk := c.onDisk[id]
+	_, inTransfer := c.inTransfer[id]
+	c.storeMutex.RUnlock()
+
+	if onDisk && !inTransfer && size > c.streamThresholdBytes() {
+		if r, n, ok := c.openStream(id); ok {
+			return r, n, nil
+		}
+
+		elem, err := c.GetWith(id, GetOpts{NoCache: true})
+		if err != nil {
+			return nil, 0, err
+		}
+
+		return ioutil.NopCloser(bytes.NewReader(elem)), int64(len(elem)), nil
+	}
+
+	elem, err := c.Get(id)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return ioutil.NopCloser(bytes.NewReader(elem)), int64(len(elem)), nil
+}
+
+// opens the file of an element for streaming. Returns false if the element
+// can't be streamed from its file
+func (c *ElementStore) openStream(id uint64) (io.ReadCloser, int64, bool) {
+	if c.segments != nil {
+		if _, ok := c.segments.lookup(id); ok {
+			return nil, 0, false
+		}
+	}
+
+	f, err := os.Open(c.elFile(c.root(id), id))
+	if err != nil {
+		return nil, 0, false
+	}
+
+	fi, err := f.Stat()
+	hdr := make([]byte, headerSize)
+	if err == nil {
+		_, err = io.ReadFull(f, hdr)
+	}
+
+	if err != nil {
+		f.Close()
+		return nil, 0, false
+	}
+
+	if !bytes.HasPrefix(hdr, []byte(checksumMagic)) {
+		// compressed, encrypted, or written before checksums
+		f.Close()
+		return nil, 0, false
+	}
+
+	sum := binary.BigEndian.Uint32(hdr[len(checksumMagic):])
+	return &checksumReader{c: c, f: f, hash: crc32.NewIEEE(), sum: sum},
+		fi.Size() - int64(headerSize), true
+}
+
+// reads an element from its file, verifying its checksum at the end
+type checksumReader struct {
+	c    *ElementStore
+	f    *os.File
+	hash hash.Hash32
+	sum  uint32
+}
+
+func (r *checksumReader) Read(p []byte) (int, error) {
+	n, err := r.f.Read(p)
+	r.hash.Write(p[:n])
+	atomic.AddUint64(&r.c.io.read, uint64(n))
+	if err == io.EOF && r.hash.Sum32() != r.sum {
+		return n, ErrChecksumMismatch
+	}
+
+	return n, err
+}
+
+func (r *checksumReader) Close() error {
+	return r.f.Close()
+}
@@ -0,0 +1,128 @@
+package elstore
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestPutGetReader(t *testing.T) {
+	c, err := NewElementStore(1, testDir, WithStreamThreshold(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	if err := c.PutReader(bytes.NewReader(testData), 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.PutReader(bytes.NewReader(testData), 1); err != ErrAlreadyExists {
+		t.Fatal("expected ErrAlreadyExists, got", err)
+	}
+
+	if data, err := c.Get(1); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(data, testData) {
+		t.Fatalf("expected\n%v\n\ngot\n%v\n\n", testData, data)
+	}
+
+	r, size, err := c.GetReader(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := r.(*checksumReader); !ok || size != int64(len(testData)) {
+		t.Fatalf("element not streamed: %T, size %v", r, size)
+	}
+
+	data, err := ioutil.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(data, testData) {
+		t.Fatalf("expected\n%v\n\ngot\n%v\n\n", testData, data)
+	}
+
+	// elements below the threshold are read as by Get
+	c.Put(testData2[:5], 2)
+	if r, size, err := c.GetReader(2); err != nil {
+		t.Fatal(err)
+	} else if _, ok := r.(*checksumReader); ok || size != 5 {
+		t.Fatalf("small element streamed: %T, size %v", r, size)
+	}
+
+	// corruption is reported at the end of the stream
+	path := elFile(testDir, 1)
+	file, _ := ioutil.ReadFile(path)
+	file[len(file)-1] ^= 1
+	if err := ioutil.WriteFile(path, file, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	r, _, err = c.GetReader(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer r.Close()
+	if _, err := ioutil.ReadAll(r); err != ErrChecksumMismatch {
+		t.Fatal("expected ErrChecksumMismatch, got", err)
+	}
+}
+
+func TestPutReaderBuffered(t *testing.T) {
+	c, err := NewElementStore(0, testDir, WithCompression(Gzip))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	if err := c.PutReader(bytes.NewReader(testData), 1); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Sync()
+	if fi, err := os.Stat(elFile(testDir, 1)); err != nil {
+		t.Fatal(err)
+	} else if fi.Size() >= int64(len(testData)) {
+		t.Fatal("element not compressed", fi.Size())
+	}
+
+	if data, err := c.Get(1); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(data, testData) {
+		t.Fatalf("expected\n%v\n\ngot\n%v\n\n", testData, data)
+	}
+}
+
+func TestPutReaderAfterDelete(t *testing.T) {
+	c, err := NewElementStore(0, testDir, WithStreamThreshold(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	for i := 0; i < 50; i++ {
+		if err := c.PutReader(bytes.NewReader(testData), 1); err != nil {
+			t.Fatal(err)
+		} else if err := c.Delete(1); err != nil {
+			t.Fatal(err)
+		} else if err := c.PutReader(bytes.NewReader(testData2), 1); err != nil {
+			t.Fatal(err)
+		}
+
+		// the file of the new element is not removed by the delete
+		c.Sync()
+		if data, err := c.GetWith(1, GetOpts{NoCache: true}); err != nil {
+			t.Fatal(i, err)
+		} else if !bytes.Equal(data, testData2) {
+			t.Fatalf("expected\n%v\n\ngot\n%v\n\n", testData2, data)
+		} else if err := c.Delete(1); err != nil {
+			t.Fatal(err)
+		}
+
+		c.Sync()
+	}
+}
@@ -0,0 +1,75 @@
+package elstore
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// Makes Put write the element to disk before returning, and return the
+// error of the write if it fails. A failed write doesn't stop further
+// writes, and is not listed by FailedWrites. Trades throughput for simpler
+// error handling in tools that store few elements
+//
+// Elements are written straight to the workdir even with a staging
+// directory. PutBatch is not affected by this option
+func WithSynchronousWrites() Option {
+	return option(func(c *ElementStore) {
+		c.synchronous = true
+	})
+}
+
+// inserts an element, writing it before returning. The ID is reserved in
+// 'streaming' while the element is written, the way PutReader does it
+func (c *ElementStore) putSync(ctx context.Context, elem []byte, id uint64, expires time.Time) error {
+	c.storeMutex.Lock()
+	if c.isClosed() {
+		c.storeMutex.Unlock()
+		return ErrClosed
+	} else if c.has(id) {
+		c.storeMutex.Unlock()
+		return ErrAlreadyExists
+	}
+
+	c.streaming[id] = false
+	prev, unlinked := c.cancelled[id], c.deleting[id]
+	c.storeMutex.Unlock()
+
+	c.awaitPrevious(id, prev, unlinked)
+	start := time.Now()
+	err := c.checkOwnership()
+	if err != nil {
+		// another instance has taken over the workdir
+		c.setWriteFailure(err)
+	} else {
+		err = c.writeElement(elem, id)
+		c.breaker.record(err)
+	}
+
+	c.writeLatency.since(start)
+	c.checkSlowOp("write", id, start)
+
+	c.storeMutex.Lock()
+	defer c.storeMutex.Unlock()
+	deleted := c.streaming[id]
+	delete(c.streaming, id)
+	if err != nil {
+		c.degradation.record(WriteFailures, err)
+		return err
+	} else if deleted {
+		c.removeElementFiles(id)
+		return nil
+	}
+
+	c.onDisk[id] = int64(len(elem))
+	c.inserted.set(id, start)
+	if !expires.IsZero() {
+		c.setExpiry(id, expires)
+	}
+
+	c.notifyID(id)
+	atomic.AddUint64(&c.io.accepted, uint64(len(elem)))
+	c.mirror(ctx, elem, id)
+	c.checkCapacity()
+	return nil
+}
@@ -0,0 +1,88 @@
+package elstore
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestSynchronousWrites(t *testing.T) {
+	c, err := NewElementStore(0, testDir, WithSynchronousWrites())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+
+	// a file in place of the subdirectory of 0 fails its write
+	if err := ioutil.WriteFile(elDir(testDir, 0), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Put(testData2, 0); err == nil {
+		t.Fatal("expected the write error to be returned")
+	} else if c.Has(0) || c.WriteError() != nil || len(c.FailedWrites()) != 0 {
+		t.Fatal("failed write left in the store")
+	}
+
+	// the element is on disk when Put returns
+	if err := c.Put(testData2, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	c.storeMutex.RLock()
+	_, onDisk := c.onDisk[1]
+	inTransfer := len(c.inTransfer)
+	c.storeMutex.RUnlock()
+	if !onDisk || inTransfer != 0 {
+		t.Fatal("element not written by Put")
+	}
+
+	data, err := ioutil.ReadFile(elFile(testDir, 1))
+	if err != nil {
+		t.Fatal(err)
+	} else if len(data) != len(testData2)+headerSize {
+		t.Fatal("unexpected file size", len(data))
+	}
+
+	if err := c.Put(testData2, 1); err != ErrAlreadyExists {
+		t.Fatal("expected ErrAlreadyExists, got", err)
+	}
+
+	got, err := c.Get(1)
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(got, testData2) {
+		t.Fatalf("expected\n%v\n\ngot\n%v\n\n", testData2, got)
+	}
+}
+
+func TestSynchronousPutAfterDelete(t *testing.T) {
+	c, err := NewElementStore(0, testDir, WithSynchronousWrites())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	for i := 0; i < 50; i++ {
+		if err := c.Put(testData, 1); err != nil {
+			t.Fatal(err)
+		} else if err := c.Delete(1); err != nil {
+			t.Fatal(err)
+		} else if err := c.Put(testData2, 1); err != nil {
+			t.Fatal(err)
+		}
+
+		// the file of the new element is not removed by the delete
+		c.Sync()
+		if data, err := c.GetWith(1, GetOpts{NoCache: true}); err != nil {
+			t.Fatal(i, err)
+		} else if !bytes.Equal(data, testData2) {
+			t.Fatalf("expected\n%v\n\ngot\n%v\n\n", testData2, data)
+		} else if err := c.Delete(1); err != nil {
+			t.Fatal(err)
+		}
+
+		c.Sync()
+	}
+}
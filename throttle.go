@@ -0,0 +1,85 @@
+package elstore
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Token buckets bounding the disk IO of background work, shared by all of
+// it. Buckets hold at most one second worth of tokens, and may go into
+// debt, which later callers wait out
+type ioThrottle struct {
+	mu          sync.Mutex
+	bytesPerSec float64
+	iops        float64
+	bytes, ops  float64 // available tokens
+	last        time.Time
+}
+
+// Bounds the combined disk IO of background work, such as mirror retries,
+// asynchronous deletes, Verify, Rekey and reads with Background priority,
+// to 'bytesPerSec' bytes and 'iops' operations per second. Zero removes a
+// limit. Foreground reads and writes are never throttled. This is a runtime
+// option
+func WithBackgroundIOLimit(bytesPerSec int64, iops int) Option {
+	if bytesPerSec < 0 || iops < 0 {
+		return Option{err: fmt.Errorf("%w: background IO limit %v B/s, %v IOPS",
+			ErrInvalidOption, bytesPerSec, iops)}
+	}
+
+	return runtimeOption(func(c *ElementStore) {
+		c.ioThrottle.set(float64(bytesPerSec), float64(iops))
+	})
+}
+
+func (t *ioThrottle) set(bytesPerSec, iops float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.bytesPerSec, t.iops = bytesPerSec, iops
+	t.bytes, t.ops = bytesPerSec, iops
+	t.last = time.Now()
+}
+
+func (t *ioThrottle) limits() (bytesPerSec, iops string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return strconv.FormatFloat(t.bytesPerSec, 'f', -1, 64),
+		strconv.FormatFloat(t.iops, 'f', -1, 64)
+}
+
+// takes 'ops' operations of 'n' bytes in total from the buckets, and
+// sleeps until the buckets are out of debt
+func (t *ioThrottle) wait(n, ops int) {
+	t.mu.Lock()
+	now := time.Now()
+	elapsed := now.Sub(t.last).Seconds()
+	t.last = now
+
+	take := func(tokens *float64, rate float64, n int) time.Duration {
+		if rate <= 0 {
+			return 0
+		}
+
+		*tokens += elapsed * rate
+		if *tokens > rate {
+			*tokens = rate
+		}
+
+		*tokens -= float64(n)
+		if *tokens >= 0 {
+			return 0
+		}
+
+		return time.Duration(-*tokens / rate * float64(time.Second))
+	}
+
+	delay := take(&t.bytes, t.bytesPerSec, n)
+	if d := take(&t.ops, t.iops, ops); d > delay {
+		delay = d
+	}
+
+	t.mu.Unlock()
+	time.Sleep(delay)
+}
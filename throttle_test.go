@@ -0,0 +1,89 @@
+package elstore
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIOThrottle(t *testing.T) {
+	var th ioThrottle
+	start := time.Now()
+	th.wait(1<<20, 1000)
+	if d := time.Since(start); d > 50*time.Millisecond {
+		t.Fatal("throttled without limits", d)
+	}
+
+	th.set(10000, 100)
+	th.wait(10000, 1) // the first second's worth is free
+	start = time.Now()
+	th.wait(1000, 1)
+	if d := time.Since(start); d < 80*time.Millisecond {
+		t.Fatal("bytes not throttled", d)
+	}
+
+	th.set(0, 100)
+	th.wait(0, 100)
+	start = time.Now()
+	th.wait(0, 5)
+	if d := time.Since(start); d < 40*time.Millisecond {
+		t.Fatal("operations not throttled", d)
+	}
+}
+
+func TestBackgroundIOLimit(t *testing.T) {
+	var changes []ConfigChange
+	c, err := NewElementStore(0, testDir, WithConfigChangeHandler(func(cc ConfigChange) {
+		changes = append(changes, cc)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	if err := c.ApplyOptions(WithBackgroundIOLimit(-1, 0)); err == nil {
+		t.Fatal("expected error for negative limit")
+	}
+
+	if err := c.ApplyOptions(WithBackgroundIOLimit(1<<20, 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := ConfigChange{"background_bytes_per_sec", "0", "1048576"}
+	if len(changes) != 1 || changes[0] != expected {
+		t.Fatalf("expected\n%v\n\ngot\n%v\n\n", expected, changes)
+	}
+}
+
+func TestBackgroundPriority(t *testing.T) {
+	c, err := NewElementStore(0, testDir, WithBackgroundIOLimit(0, 20))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	if err := c.Put(testData2, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Sync()
+	read := func(n int, priority Priority) time.Duration {
+		start := time.Now()
+		for i := 0; i < n; i++ {
+			if _, err := c.GetWith(1, GetOpts{NoCache: true, Priority: priority}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		return time.Since(start)
+	}
+
+	read(20, Background) // the first second's worth is free
+	if d := read(2, Background); d < 80*time.Millisecond {
+		t.Fatal("background reads not throttled", d)
+	}
+
+	// a second's worth, if they were throttled
+	if d := read(20, Foreground); d > 500*time.Millisecond {
+		t.Fatal("foreground reads throttled", d)
+	}
+}
@@ -0,0 +1,169 @@
+package elstore
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// lists the directories moved into a trash entry and where they came from
+const trashOrigin = ".origin"
+
+// Like Remove, but moves the workdir and standby into a new entry in
+// 'trashDir' instead of deleting them, which is instant for stores of any
+// size. Returns the path of the entry, which can be passed to
+// RestoreFromTrash until the entry is deleted by EmptyTrash
+//
+// 'trashDir' must be on the same file system as the workdir. A standby on
+// another file system is removed instead of being moved. If the workdir
+// can't be moved, the store is closed with its files left in place
+//
+// Returns ErrSharded for stores with shards
+func (c *ElementStore) RemoveToTrash(trashDir string) (string, error) {
+	if len(c.shards) > 0 {
+		return "", ErrSharded
+	}
+
+	// prepared while the store is open, so that failing leaves it usable
+	entry, err := c.newTrashEntry(trashDir)
+	if err != nil {
+		return "", err
+	}
+
+	c.storeMutex.Lock()
+	atomic.StoreInt32(&c.closed, 1)
+	c.storeMutex.Unlock()
+	c.Sync()
+
+	// open files prevent the rename on Windows
+	if err := c.release(); err != nil {
+		os.RemoveAll(entry)
+		return "", err
+	}
+
+	if err := os.Rename(c.workdir, filepath.Join(entry, "workdir")); err != nil {
+		os.RemoveAll(entry)
+		return "", err
+	}
+
+	if c.standby != "" {
+		if err := os.Rename(c.standby, filepath.Join(entry, "standby")); err != nil {
+			if err := os.RemoveAll(c.standby); err != nil {
+				return entry, err
+			}
+		}
+	}
+
+	// staged elements are committed by Sync, leaving nothing worth keeping
+	if c.staging != "" {
+		if err := os.RemoveAll(c.staging); err != nil {
+			return entry, err
+		}
+	}
+
+	return entry, nil
+}
+
+// creates an entry in 'trashDir' listing where the directories of the
+// store come from
+func (c *ElementStore) newTrashEntry(trashDir string) (string, error) {
+	if err := os.MkdirAll(trashDir, c.dirMode()); err != nil {
+		return "", err
+	}
+
+	entry := filepath.Join(trashDir,
+		fmt.Sprintf("%v.%v", filepath.Base(c.workdir), time.Now().UnixNano()))
+	if err := os.Mkdir(entry, c.dirMode()); err != nil {
+		return "", err
+	}
+
+	dirs := map[string]string{"workdir": c.workdir}
+	if c.standby != "" {
+		dirs["standby"] = c.standby
+	}
+
+	var origin strings.Builder
+	for name, dir := range dirs {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			os.Remove(entry)
+			return "", err
+		}
+
+		fmt.Fprintf(&origin, "%v %v\n", name, abs)
+	}
+
+	path := filepath.Join(entry, trashOrigin)
+	if err := ioutil.WriteFile(path, []byte(origin.String()), c.fileMode()); err != nil {
+		os.RemoveAll(entry)
+		return "", err
+	}
+
+	return entry, nil
+}
+
+// Moves the directories of a store removed by RemoveToTrash back to where
+// they were. 'entry' is the path returned by RemoveToTrash
+func RestoreFromTrash(entry string) error {
+	origin, err := ioutil.ReadFile(filepath.Join(entry, trashOrigin))
+	if err != nil {
+		return err
+	}
+
+	for _, line := range strings.Split(strings.TrimSpace(string(origin)), "\n") {
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) != 2 {
+			return fmt.Errorf("Malformed trash entry %v", entry)
+		}
+
+		src := filepath.Join(entry, fields[0])
+		if _, err := os.Stat(src); os.IsNotExist(err) {
+			// a standby removed instead of moved
+			continue
+		}
+
+		if _, err := os.Stat(fields[1]); err == nil {
+			return fmt.Errorf("Can't restore %v: %v already exists", src, fields[1])
+		}
+
+		if err := os.Rename(src, fields[1]); err != nil {
+			return err
+		}
+	}
+
+	return os.RemoveAll(entry)
+}
+
+// Deletes entries in 'trashDir' that were moved there by RemoveToTrash
+// longer than 'olderThan' ago. Deleting large stores takes time, so this
+// is typically run in a separate goroutine. Other files in 'trashDir' are
+// left alone
+func EmptyTrash(trashDir string, olderThan time.Duration) error {
+	entries, err := ioutil.ReadDir(trashDir)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		ix := strings.LastIndex(e.Name(), ".")
+		if !e.IsDir() || ix < 0 {
+			continue
+		}
+
+		ns, err := strconv.ParseInt(e.Name()[ix+1:], 10, 64)
+		if err != nil || time.Since(time.Unix(0, ns)) < olderThan {
+			continue
+		}
+
+		if err := os.RemoveAll(filepath.Join(trashDir, e.Name())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
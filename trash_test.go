@@ -0,0 +1,100 @@
+package elstore
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRemoveToTrash(t *testing.T) {
+	trash := testDir + "-trash"
+	defer os.RemoveAll(trash)
+
+	c, err := NewElementStore(0, testDir, WithStandby(testDir+"-standby"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Put(testData2, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	entry, err := c.RemoveToTrash(trash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, dir := range []string{testDir, testDir + "-standby"} {
+		if _, err := os.Stat(dir); !os.IsNotExist(err) {
+			t.Fatal("not moved to trash", dir, err)
+		}
+	}
+
+	if err := RestoreFromTrash(entry); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err = NewElementStore(0, testDir, WithStandby(testDir+"-standby"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	data, err := c.Get(1)
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(data, testData2) {
+		t.Fatalf("expected\n%v\n\ngot\n%v\n\n", testData2, data)
+	}
+
+	if entry, err = c.RemoveToTrash(trash); err != nil {
+		t.Fatal(err)
+	}
+
+	// too recent to be deleted
+	if err := EmptyTrash(trash, time.Hour); err != nil {
+		t.Fatal(err)
+	} else if _, err := os.Stat(entry); err != nil {
+		t.Fatal("entry deleted too early", err)
+	}
+
+	if err := EmptyTrash(trash, 0); err != nil {
+		t.Fatal(err)
+	} else if _, err := os.Stat(entry); !os.IsNotExist(err) {
+		t.Fatal("entry not deleted", err)
+	}
+}
+
+func TestRemoveToTrashFailed(t *testing.T) {
+	trash := testDir + "-trash"
+	defer os.RemoveAll(trash)
+
+	c, err := NewElementStore(0, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	if err := ioutil.WriteFile(trash, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.RemoveToTrash(trash); err == nil {
+		t.Fatal("expected error for a trash directory that is a file")
+	}
+
+	// left usable, with its background work running
+	if c.isShutdown() || c.isClosed() {
+		t.Fatal("store shut down by a failed RemoveToTrash")
+	}
+
+	if err := c.Put(testData2, 1); err != nil {
+		t.Fatal(err)
+	} else if data, err := c.GetWith(1, GetOpts{RequireDurable: true}); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(data, testData2) {
+		t.Fatalf("expected\n%v\n\ngot\n%v\n\n", testData2, data)
+	}
+}
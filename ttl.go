@@ -0,0 +1,118 @@
+package elstore
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// Expiry deadlines are kept in a log like insertion times, and expired
+// elements are deleted by a janitor task every expiryInterval
+const expiryFile = ".expiry"
+
+var expiryInterval = time.Second
+
+// Inserts an element into the store that expires after 'ttl'. Expired
+// elements are treated as nonexistent by Get, Has and IDs, and are deleted
+// in the background, along with their aliases. Expiry deadlines survive
+// restarts. An expired ID can't be reused until its element is deleted
+//
+// Returns ErrAlreadyExists if the ID is already in use
+func (c *ElementStore) PutWithTTL(elem []byte, id uint64, ttl time.Duration) error {
+	if ttl <= 0 {
+		return fmt.Errorf("%w: TTL %v", ErrInvalidOption, ttl)
+	}
+
+	return c.put(context.Background(), elem, id, time.Now().Add(ttl))
+}
+
+// loads the expiry deadlines and starts the janitor if any element expires
+func (c *ElementStore) loadExpiries() error {
+	if err := c.openTimeLog(&c.expiries, expiryFile); err != nil {
+		return err
+	}
+
+	if len(c.expiries.times) > 0 {
+		c.startExpiry()
+	}
+
+	return nil
+}
+
+// XXX: Assumes a storeMutex-lock is held, or that the store is being opened
+func (c *ElementStore) setExpiry(id uint64, expires time.Time) {
+	c.expiries.set(id, expires)
+	c.startExpiry()
+}
+
+func (c *ElementStore) startExpiry() {
+	atomic.StoreInt32(&c.ttlUsed, 1)
+	if c.sharedReader {
+		return
+	}
+
+	c.janitor.Do(func() {
+		c.schedule("expiry", expiryInterval, c.expireElements)
+	})
+}
+
+// returns true if an element has expired
+func (c *ElementStore) expired(id uint64) bool {
+	if atomic.LoadInt32(&c.ttlUsed) == 0 {
+		return false
+	}
+
+	t, ok := c.expiries.get(id)
+	return ok && !time.Now().Before(t)
+}
+
+// like expired, for the target of an alias
+//
+// XXX: Assumes a storeMutex-lock is held
+func (c *ElementStore) resolvedExpired(id uint64) bool {
+	if target, ok := c.aliases[id]; ok {
+		id = target
+	}
+
+	return c.expired(id)
+}
+
+// XXX: Assumes a storeMutex-lock is held
+func (c *ElementStore) dropExpired(ids []uint64) []uint64 {
+	if atomic.LoadInt32(&c.ttlUsed) == 0 {
+		return ids
+	}
+
+	live := ids[:0]
+	for _, id := range ids {
+		if !c.resolvedExpired(id) {
+			live = append(live, id)
+		}
+	}
+
+	return live
+}
+
+// deletes expired elements
+func (c *ElementStore) expireElements() {
+	if c.IsReadOnly() {
+		return
+	}
+
+	now := time.Now().UnixNano()
+	var ids []uint64
+	c.expiries.Lock()
+	for id, t := range c.expiries.times {
+		if t <= now {
+			ids = append(ids, id)
+		}
+	}
+
+	c.expiries.Unlock()
+	for _, id := range ids {
+		if err := c.Delete(id); err == ErrDoesNotExist {
+			c.expiries.forget(id)
+		}
+	}
+}
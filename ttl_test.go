@@ -0,0 +1,117 @@
+package elstore
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPutWithTTL(t *testing.T) {
+	defer func(interval time.Duration) { expiryInterval = interval }(expiryInterval)
+	expiryInterval = 10 * time.Millisecond
+
+	c, err := NewElementStore(0, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	if err := c.PutWithTTL(testData2, 1, 0); !errors.Is(err, ErrInvalidOption) {
+		t.Fatal("expected ErrInvalidOption, got", err)
+	}
+
+	if err := c.PutWithTTL(testData2, 1, 200*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Put(testData2, 2)
+	c.Alias(3, 1)
+	c.Sync()
+	info, err := c.Info(1)
+	if err != nil {
+		t.Fatal(err)
+	} else if info.Expires.IsZero() {
+		t.Fatal("expected an expiry deadline")
+	}
+
+	// the deadline survives a restart
+	c.Close()
+	c, err = NewElementStore(0, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	if reopened, err := c.Info(1); err != nil {
+		t.Fatal(err)
+	} else if !reopened.Expires.Equal(info.Expires) {
+		t.Fatalf("expected\n%v\n\ngot\n%v\n\n", info.Expires, reopened.Expires)
+	}
+
+	time.Sleep(time.Until(info.Expires))
+	if c.Has(1) || c.Has(3) {
+		t.Fatal("expired element still present")
+	} else if _, err := c.Get(3); err != ErrDoesNotExist {
+		t.Fatal("expected ErrDoesNotExist, got", err)
+	} else if ids := c.IDs(); !reflect.DeepEqual(ids, []uint64{2}) {
+		t.Fatal("unexpected IDs", ids)
+	}
+
+	// deleted from disk by the janitor
+	for i := 0; ; i++ {
+		if _, err := os.Stat(elFile(c.root(1), 1)); os.IsNotExist(err) {
+			break
+		} else if i == 100 {
+			t.Fatal("expired element not deleted")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := c.Put(testData2, 1); err != nil {
+		t.Fatal("expired ID not reusable:", err)
+	}
+}
+
+func TestExpiredNotListed(t *testing.T) {
+	defer func(interval time.Duration) { expiryInterval = interval }(expiryInterval)
+	expiryInterval = time.Hour
+
+	c, err := NewElementStore(0, testDir, WithStreamThreshold(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	c.Put(testData, 1)
+	if err := c.PutWithTTL(testData, 2, 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	} else if err := c.PutWithTTL(testData2, 3, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Sync()
+	time.Sleep(60 * time.Millisecond)
+	if _, _, err := c.GetReader(2); err != ErrDoesNotExist {
+		t.Fatal("expected ErrDoesNotExist, got", err)
+	}
+
+	var seen []uint64
+	report, err := c.DeleteWhereWith(func(id uint64, info ElementInfo) bool {
+		seen = append(seen, id)
+		if id == 3 && info.Expires.IsZero() {
+			t.Error("expected an expiry deadline")
+		}
+
+		return true
+	}, DeleteOpts{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(seen, []uint64{1, 3}) {
+		t.Fatalf("expected\n%v\n\ngot\n%v\n\n", []uint64{1, 3}, seen)
+	} else if want := int64(len(testData) + len(testData2)); report.Bytes != want {
+		t.Fatalf("expected\n%v\n\ngot\n%v\n\n", want, report.Bytes)
+	}
+}
@@ -0,0 +1,102 @@
+package elstore
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+)
+
+// A Codec converts values of type T to and from element payloads
+type Codec[T any] interface {
+	Marshal(v T) ([]byte, error)
+	Unmarshal(data []byte) (T, error)
+}
+
+// Encodes values with encoding/gob. Each element carries its own type
+// information, so elements are self-contained at the cost of some size
+type GobCodec[T any] struct{}
+
+func (GobCodec[T]) Marshal(v T) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (GobCodec[T]) Unmarshal(data []byte) (T, error) {
+	var v T
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&v)
+	return v, err
+}
+
+// Encodes values with encoding/json
+type JSONCodec[T any] struct{}
+
+func (JSONCodec[T]) Marshal(v T) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec[T]) Unmarshal(data []byte) (T, error) {
+	var v T
+	err := json.Unmarshal(data, &v)
+	return v, err
+}
+
+// A Codec made from a pair of functions, e.g. the methods of a type with
+// its own binary encoding
+type FuncCodec[T any] struct {
+	MarshalFunc   func(v T) ([]byte, error)
+	UnmarshalFunc func(data []byte) (T, error)
+}
+
+func (c FuncCodec[T]) Marshal(v T) ([]byte, error) {
+	return c.MarshalFunc(v)
+}
+
+func (c FuncCodec[T]) Unmarshal(data []byte) (T, error) {
+	return c.UnmarshalFunc(data)
+}
+
+// A type safe wrapper around an ElementStore, storing values of type T
+// encoded by a Codec
+type TypedStore[T any] struct {
+	store *ElementStore
+	codec Codec[T]
+}
+
+// Returns a TypedStore storing values in 'store' using 'codec'
+func NewTypedStore[T any](store *ElementStore, codec Codec[T]) *TypedStore[T] {
+	return &TypedStore[T]{store: store, codec: codec}
+}
+
+// Returns the underlying ElementStore, e.g. for Sync or Remove
+func (s *TypedStore[T]) Store() *ElementStore {
+	return s.store
+}
+
+// Encodes and inserts a value into the store
+//
+// Returns ErrAlreadyExists if the ID is already in use
+func (s *TypedStore[T]) Put(v T, id uint64) error {
+	data, err := s.codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return s.store.Put(data, id)
+}
+
+// Gets and decodes a value from the store
+//
+// returns ErrDoesNotExist if the ID is not recognized
+func (s *TypedStore[T]) Get(id uint64) (T, error) {
+	data, err := s.store.Get(id)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+
+	return s.codec.Unmarshal(data)
+}
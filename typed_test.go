@@ -0,0 +1,65 @@
+package elstore
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+type typedTestValue struct {
+	Name  string
+	Count int
+}
+
+func TestTypedStore(t *testing.T) {
+	c, err := NewElementStore(0, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	v := typedTestValue{"elements", 3}
+	codecs := []Codec[typedTestValue]{
+		GobCodec[typedTestValue]{},
+		JSONCodec[typedTestValue]{},
+	}
+
+	for i, codec := range codecs {
+		s := NewTypedStore(c, codec)
+		if err := s.Put(v, uint64(i)); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := s.Get(uint64(i))
+		if err != nil {
+			t.Fatal(err)
+		} else if !reflect.DeepEqual(got, v) {
+			t.Fatal("expected", v, "got", got)
+		}
+	}
+
+	ints := NewTypedStore[int](c, FuncCodec[int]{
+		MarshalFunc: func(v int) ([]byte, error) {
+			return []byte(strconv.Itoa(v)), nil
+		},
+		UnmarshalFunc: func(data []byte) (int, error) {
+			return strconv.Atoi(string(data))
+		},
+	})
+
+	if err := ints.Put(42, 10); err != nil {
+		t.Fatal(err)
+	} else if got, err := ints.Get(10); err != nil || got != 42 {
+		t.Fatal("expected 42, got", got, err)
+	}
+
+	if _, err := ints.Get(11); err != ErrDoesNotExist {
+		t.Fatal("expected ErrDoesNotExist, got", err)
+	}
+
+	// a payload the codec doesn't understand
+	if _, err := ints.Get(0); err == nil || errors.Is(err, ErrDoesNotExist) {
+		t.Fatal("expected a decoding error, got", err)
+	}
+}
@@ -0,0 +1,75 @@
+package elstore
+
+import (
+	"context"
+	"time"
+)
+
+// how often WaitForID looks for elements written by another store when
+// opened WithSharedReader
+const waitPollInterval = 100 * time.Millisecond
+
+// Blocks until an element or alias with the ID exists in the store, e.g.
+// to hand elements over from a producer to a consumer. Stores opened
+// WithSharedReader poll the workdir for the element
+//
+// Returns the error of 'ctx' if it's done first
+func (c *ElementStore) WaitForID(ctx context.Context, id uint64) error {
+	c.storeMutex.Lock()
+	if c.has(id) {
+		c.storeMutex.Unlock()
+		return nil
+	}
+
+	ch := make(chan struct{})
+	c.idWaiters[id] = append(c.idWaiters[id], ch)
+	c.storeMutex.Unlock()
+
+	var poll <-chan time.Time
+	if c.sharedReader {
+		ticker := time.NewTicker(waitPollInterval)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+
+	for {
+		select {
+		case <-ch:
+			return nil
+		case <-poll:
+			// notifies 'ch' if found
+			c.discover(id)
+		case <-ctx.Done():
+			c.stopWaiting(id, ch)
+			return ctx.Err()
+		}
+	}
+}
+
+func (c *ElementStore) stopWaiting(id uint64, ch chan struct{}) {
+	c.storeMutex.Lock()
+	defer c.storeMutex.Unlock()
+	waiters := c.idWaiters[id][:0]
+	for _, w := range c.idWaiters[id] {
+		if w != ch {
+			waiters = append(waiters, w)
+		}
+	}
+
+	if len(waiters) == 0 {
+		delete(c.idWaiters, id)
+	} else {
+		c.idWaiters[id] = waiters
+	}
+}
+
+// wakes up WaitForID calls waiting for an ID that now exists
+//
+// XXX: Assumes a storeMutex write lock is held
+func (c *ElementStore) notifyID(id uint64) {
+	for _, ch := range c.idWaiters[id] {
+		close(ch)
+	}
+
+	delete(c.idWaiters, id)
+}
@@ -0,0 +1,85 @@
+package elstore
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestWaitForID(t *testing.T) {
+	c, err := NewElementStore(0, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	done := make(chan error)
+	for _, id := range []uint64{1, 2} {
+		go func(id uint64) {
+			done <- c.WaitForID(context.Background(), id)
+		}(id)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	if err := c.Put(testData2, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Alias(2, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// already there
+	if err := c.WaitForID(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := c.WaitForID(ctx, 3); err != context.DeadlineExceeded {
+		t.Fatal("expected context.DeadlineExceeded, got", err)
+	}
+
+	c.storeMutex.RLock()
+	defer c.storeMutex.RUnlock()
+	if len(c.idWaiters) != 0 {
+		t.Fatal("waiters left", c.idWaiters)
+	}
+}
+
+func TestWaitForIDSharedReader(t *testing.T) {
+	w, err := NewElementStore(0, testDir, WithSharedWriter())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer w.Remove()
+	r, err := NewElementStore(0, testDir, WithSharedReader())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- r.WaitForID(context.Background(), 1)
+	}()
+
+	if err := w.Put(testData2, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * waitPollInterval):
+		t.Fatal("element written by another store not noticed")
+	}
+}
@@ -0,0 +1,137 @@
+package elstore
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// The warmup list is a text file of hexadecimal IDs, one per line, highest
+// priority first. Empty lines and lines starting with '#' are ignored
+const warmupFile = ".warmup"
+
+// Makes Health report WarmingUp until the elements of the warmup list are
+// loaded into the cache, so that load balancers hold off traffic until
+// reads are served from memory
+func WithWarmupReadiness() Option {
+	return option(func(c *ElementStore) {
+		c.warmupReadiness = true
+	})
+}
+
+// Replaces the warmup list of the store. The elements of the list are
+// loaded into the cache in order when the store is opened, until the cache
+// is full. Warm elements may be evicted later like any other
+func (c *ElementStore) SetWarmupList(ids []uint64) error {
+	if c.IsReadOnly() {
+		return ErrReadOnly
+	}
+
+	var buf bytes.Buffer
+	for _, id := range ids {
+		buf.WriteString(strconv.FormatUint(id, 16))
+		buf.WriteByte('\n')
+	}
+
+	_, err := writeData(filepath.Join(c.workdir, warmupFile), c.fileMode(),
+		c.durability != NoSync, buf.Bytes())
+	return err
+}
+
+// Returns the warmup list of the store, which is empty unless set
+func (c *ElementStore) WarmupList() ([]uint64, error) {
+	f, err := os.Open(filepath.Join(c.workdir, warmupFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+	var ids []uint64
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		id, err := strconv.ParseUint(line, 16, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids, scanner.Err()
+}
+
+// Waits for the elements of the warmup list to be loaded into the cache
+//
+// Returns ctx.Err() if 'ctx' is done first
+func (c *ElementStore) WaitWarmup(ctx context.Context) error {
+	select {
+	case <-c.warmupDone:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// loads the elements of the warmup list into the cache in the background
+func (c *ElementStore) startWarmup() {
+	c.warmupDone = make(chan struct{})
+	ids, err := c.WarmupList()
+	if err != nil || len(ids) == 0 || (!c.cacheEnabled() && c.memTier == nil) {
+		// a broken list must not keep the store from opening
+		close(c.warmupDone)
+		return
+	}
+
+	atomic.StoreInt32(&c.warming, 1)
+	go func() {
+		defer close(c.warmupDone)
+		defer atomic.StoreInt32(&c.warming, 0)
+		for _, id := range ids {
+			if c.isShutdown() {
+				return
+			}
+
+			gen := c.cacheGeneration()
+			el, err := c.GetWith(id, GetOpts{NoCache: true})
+			if err != nil {
+				continue
+			} else if !c.preload(el, id, gen) {
+				return
+			}
+		}
+	}()
+}
+
+// adds an element read at cacheGen 'gen' to the cache if there's room for
+// it, without evicting others. Returns false if the cache is full
+func (c *ElementStore) preload(el []byte, id uint64, gen uint64) bool {
+	if c.memTier != nil {
+		c.setMemTier(el, id, gen)
+		return true
+	}
+
+	c.storeMutex.Lock()
+	defer c.storeMutex.Unlock()
+	if gen != c.cacheGen || c.inMem.Has(id) {
+		return true
+	} else if !c.cacheFits(len(el)) {
+		return c.inMem.Len() < c.cacheSize()
+	}
+
+	c.inMem.Add(id, 0)
+	c.inMemIDMap.Store(id, el)
+	c.cacheBytes += int64(len(el))
+	return true
+}
@@ -0,0 +1,66 @@
+package elstore
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWarmup(t *testing.T) {
+	c, err := NewElementStore(2, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	for id := uint64(1); id <= 3; id++ {
+		c.Put(testData2, id)
+	}
+
+	c.Sync()
+	if ids, err := c.WarmupList(); err != nil || ids != nil {
+		t.Fatal("expected no warmup list, got", ids, err)
+	}
+
+	if err := c.SetWarmupList([]uint64{3, 4, 1, 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	// operators may edit the list by hand
+	path := filepath.Join(testDir, warmupFile)
+	data, _ := ioutil.ReadFile(path)
+	ioutil.WriteFile(path, append([]byte("# hot elements\n\n"), data...), 0600)
+	if ids, err := c.WarmupList(); err != nil {
+		t.Fatal(err)
+	} else if want := []uint64{3, 4, 1, 2}; !reflect.DeepEqual(ids, want) {
+		t.Fatalf("expected\n%v\n\ngot\n%v\n\n", want, ids)
+	}
+
+	c.Close()
+	c, err = NewElementStore(2, testDir, WithWarmupReadiness())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := c.WaitWarmup(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// 4 doesn't exist, and the cache is full before 2
+	cached := func(id uint64) bool {
+		_, ok := c.inMemIDMap.Load(id)
+		return ok
+	}
+
+	if !cached(3) || !cached(1) || cached(2) {
+		t.Fatal("unexpected cache contents after warmup")
+	} else if h := c.Health(); h != Healthy {
+		t.Fatal("expected healthy store after warmup, got", h)
+	}
+}
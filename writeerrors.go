@@ -0,0 +1,65 @@
+package elstore
+
+// Invokes 'handler' for every element that could not be written. The
+// element is no longer in the store, and can be retried with Put once the
+// cause is fixed and ClearWriteErrors has been called
+//
+// The handler is called from the goroutine writing the element and should
+// not block
+func WithWriteErrorHandler(handler func(id uint64, err error)) Option {
+	return option(func(c *ElementStore) {
+		c.writeErrorHandler = handler
+	})
+}
+
+// the error preventing writes, wrapped as atomic.Value can't hold nil or
+// errors of differing types
+type writeFailure struct {
+	err error
+}
+
+func (c *ElementStore) setWriteFailure(err error) {
+	c.writeFailure.Store(writeFailure{err})
+}
+
+func (c *ElementStore) recordFailedWrite(id uint64, err error) {
+	c.storeMutex.Lock()
+	c.failedWrites[id] = err
+	c.storeMutex.Unlock()
+
+	c.degradation.record(WriteFailures, err)
+
+	if c.writeErrorHandler != nil {
+		c.writeErrorHandler(id, err)
+	}
+}
+
+// Returns the elements that could not be written since the store was
+// opened or ClearWriteErrors was called, and their errors
+func (c *ElementStore) FailedWrites() map[uint64]error {
+	c.storeMutex.RLock()
+	defer c.storeMutex.RUnlock()
+	ret := make(map[uint64]error, len(c.failedWrites))
+	for id, err := range c.failedWrites {
+		ret[id] = err
+	}
+
+	return ret
+}
+
+// Clears the write error of the store and of its shards, allowing writes
+// again, and forgets the failed writes. Elements that failed can then be
+// inserted again
+func (c *ElementStore) ClearWriteErrors() {
+	c.storeMutex.Lock()
+	c.failedWrites = make(map[uint64]error)
+	c.setWriteFailure(nil)
+	c.storeMutex.Unlock()
+
+	c.shardErrs.Lock()
+	for i := range c.shardErrs.errs {
+		c.shardErrs.errs[i] = nil
+	}
+
+	c.shardErrs.Unlock()
+}
@@ -0,0 +1,92 @@
+package elstore
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestFailedWrites(t *testing.T) {
+	var mu sync.Mutex
+	handled := make(map[uint64]error)
+	c, err := NewElementStore(0, testDir, WithWriteErrorHandler(func(id uint64, err error) {
+		mu.Lock()
+		handled[id] = err
+		mu.Unlock()
+	}))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+
+	// a file in place of the subdirectory of 0 fails its write
+	if err := ioutil.WriteFile(elDir(testDir, 0), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Put(testData2, 0)
+	c.Sync()
+	failed := c.FailedWrites()
+	if c.WriteError() == nil || len(failed) != 1 || failed[0] == nil {
+		t.Fatal("write failure not recorded", c.WriteError(), failed)
+	}
+
+	mu.Lock()
+	if len(handled) != 1 || handled[0] != failed[0] {
+		t.Fatal("handler not called", handled)
+	}
+
+	mu.Unlock()
+	if c.Has(0) {
+		t.Fatal("failed element still in store")
+	}
+
+	os.Remove(elDir(testDir, 0))
+	c.ClearWriteErrors()
+	if c.WriteError() != nil || len(c.FailedWrites()) != 0 {
+		t.Fatal("write errors not cleared")
+	}
+
+	if err := c.Put(testData2, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Sync()
+	if _, err := c.GetWith(0, GetOpts{NoCache: true}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWriteErrorConcurrent(t *testing.T) {
+	c, err := NewElementStore(0, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	if err := ioutil.WriteFile(elDir(testDir, 0), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// failing writes set the error while it's read and cleared, which the
+	// race detector catches unless it's synchronized
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			c.WriteError()
+			c.Health()
+			c.ClearWriteErrors()
+		}
+	}()
+
+	for i := 0; i < 20; i++ {
+		c.Put(testData2, 0)
+	}
+
+	<-done
+	c.Sync()
+}
@@ -0,0 +1,113 @@
+package elstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var ErrQueueFull = errors.New("Write queue full")
+
+type queuedWrite struct {
+	pw *pendingWrite
+	id uint64
+}
+
+// a fixed number of writer goroutines fed from a bounded queue. A slot is
+// reserved before an element is inserted, so queueing it never blocks
+type writePool struct {
+	writers int
+	block   bool
+	writes  chan queuedWrite
+	slots   chan struct{}
+	start   sync.Once
+}
+
+// Writes elements using 'n' goroutines instead of one goroutine per Put.
+// Up to 'queueSize' elements wait in a queue for a writer. When the queue
+// is full, Put blocks until there is room if 'block' is set, and fails with
+// ErrQueueFull otherwise. PutCtx gives up waiting when its context is done
+//
+// PutBatch is not affected by this option
+func WithWriteConcurrency(n, queueSize int, block bool) Option {
+	if n < 1 || queueSize < 1 {
+		return Option{err: fmt.Errorf("%w: write concurrency %v, queue size %v",
+			ErrInvalidOption, n, queueSize)}
+	}
+
+	return option(func(c *ElementStore) {
+		c.writePool = &writePool{
+			writers: n,
+			block:   block,
+			writes:  make(chan queuedWrite, queueSize),
+			slots:   make(chan struct{}, queueSize),
+		}
+	})
+}
+
+// reserves room in the write queue for an element
+func (c *ElementStore) reserveWrite(ctx context.Context) error {
+	p := c.writePool
+	if p == nil {
+		return nil
+	}
+
+	if !p.block {
+		select {
+		case p.slots <- struct{}{}:
+			return nil
+		default:
+			return ErrQueueFull
+		}
+	}
+
+	select {
+	case p.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// gives back room reserved for an element that was not inserted
+func (c *ElementStore) cancelWrite() {
+	if c.writePool != nil {
+		<-c.writePool.slots
+	}
+}
+
+// XXX: Assumes a storeMutex-lock is held, and room reserved by reserveWrite
+func (c *ElementStore) scheduleWrite(pw *pendingWrite, id uint64) {
+	p := c.writePool
+	if p == nil {
+		go c.write(pw, id)
+		return
+	} else if c.isShutdown() {
+		// no writers left
+		<-p.slots
+		go c.write(pw, id)
+		return
+	}
+
+	p.start.Do(func() {
+		for i := 0; i < p.writers; i++ {
+			go c.writer()
+		}
+	})
+
+	p.writes <- queuedWrite{pw, id}
+}
+
+func (c *ElementStore) writer() {
+	p := c.writePool
+	for {
+		select {
+		case w := <-p.writes:
+			<-p.slots
+			c.write(w.pw, w.id)
+		case <-c.quit:
+			return
+		}
+	}
+}
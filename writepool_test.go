@@ -0,0 +1,69 @@
+package elstore
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestWriteConcurrency(t *testing.T) {
+	c, err := NewElementStore(0, testDir, WithWriteConcurrency(2, 4, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Remove()
+	for id := uint64(1); id <= 100; id++ {
+		for {
+			err := c.Put(testData2, id)
+			if err == nil {
+				break
+			} else if err != ErrQueueFull {
+				t.Fatal(err)
+			}
+
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	c.Sync()
+	if err := c.WriteError(); err != nil {
+		t.Fatal(err)
+	}
+
+	for id := uint64(1); id <= 100; id++ {
+		if _, err := c.GetWith(id, GetOpts{RequireDurable: true}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// fill the queue without writers taking from it
+	p := c.writePool
+	for i := 0; i < cap(p.slots); i++ {
+		p.slots <- struct{}{}
+	}
+
+	if err := c.Put(testData2, 101); err != ErrQueueFull {
+		t.Fatal("expected ErrQueueFull, got", err)
+	} else if c.Has(101) {
+		t.Fatal("element inserted despite full queue")
+	}
+
+	p.block = true
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := c.PutCtx(ctx, testData2, 101); err != context.DeadlineExceeded {
+		t.Fatal("expected context.DeadlineExceeded, got", err)
+	}
+
+	done := make(chan error)
+	go func() { done <- c.Put(testData2, 101) }()
+	<-p.slots
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 1; i < cap(p.slots); i++ {
+		<-p.slots
+	}
+}